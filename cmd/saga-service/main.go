@@ -1,11 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"homework/internal/model"
 	"homework/internal/saga"
 	"homework/internal/service"
-	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -15,13 +15,18 @@ func main() {
 	billingSvc := service.NewBillingService()
 	inventorySvc := service.NewInventoryService()
 	discountSvc := service.NewDiscountService()
+	userSvc := service.NewUserService()
+
+	for _, name := range []string{"dasha", "nastya", "tom"} {
+		userSvc.RegisterUser(name, model.UserProfile{Name: name, Email: name + "@example.com"})
+	}
 
 	billingSvc.SetUserBalance("dasha", 1000.0)
 	billingSvc.SetUserBalance("nastya", 100.0)
 	inventorySvc.SetStock("apple", 100)
 	discountSvc.SetUserDiscount("dasha", 15.0)
 
-	sagaOrch := saga.NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	sagaOrch := saga.NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc, saga.WithUserService(userSvc))
 
 	items1 := []model.OrderItem{
 		{ProductID: "apple", Quantity: 2, Price: 200.0},
@@ -45,6 +50,15 @@ func main() {
 
 	inventorySvc.SetStock("apple", 30) 
 
+	// Balances are set before the sagas start: reset while they run, they
+	// would decide the outcome instead of the contention for stock. dasha
+	// cannot afford an apple, nastya and tom compete for the last 30.
+	names := []string{"dasha", "nastya", "tom"}
+	for _, userID := range names {
+		billingSvc.SetUserBalance(userID, 5000.0)
+	}
+	billingSvc.SetUserBalance("dasha", 50.0)
+
 	numSagas := 100
 	var successCount int32
 	var failCount int32
@@ -57,18 +71,10 @@ func main() {
 		go func(index int) {
 			defer wg.Done()
 
-			names := []string{"dasha", "nastya", "tom"}
 			userID := names[index%len(names)]
 			orderID := fmt.Sprintf("order_%d", index)
 			sagaID := fmt.Sprintf("saga_%d", index)
 
-			balance := 500.0
-			if index%3 == 0 {
-				balance = 50.0 
-			}
-			billingSvc.SetUserBalance(userID, balance)
-			discountSvc.SetUserDiscount(userID, float64(index%10))
-
 			items := []model.OrderItem{
 				{ProductID: "apple", Quantity: 1, Price: 100.0},
 			}
@@ -78,13 +84,10 @@ func main() {
 				atomic.AddInt32(&successCount, 1)
 			} else {
 				atomic.AddInt32(&failCount, 1)
-				if result.Error != nil {
-					errMsg := result.Error.Error()
-					if strings.Contains(errMsg, "insufficient stock") {
-						atomic.AddInt32(&stockFailures, 1)
-					} else if strings.Contains(errMsg, "insufficient funds") {
-						atomic.AddInt32(&balanceFailures, 1)
-					}
+				if errors.Is(result.Error, service.ErrInsufficientStock) {
+					atomic.AddInt32(&stockFailures, 1)
+				} else if errors.Is(result.Error, service.ErrInsufficientFunds) {
+					atomic.AddInt32(&balanceFailures, 1)
 				}
 			}
 		}(i)
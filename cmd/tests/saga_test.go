@@ -52,7 +52,7 @@ func (s *SagaTestSuite) TestSuccessfulOrder() {
 	s.NotNil(result.Execution)
 	s.Equal(saga.SagaStatusCompleted, result.Execution.Status)
 	s.NotEmpty(result.Execution.OrderID)
-	s.Equal(5, len(result.Execution.Steps))
+	s.Equal(7, len(result.Execution.Steps))
 
 	order, err := s.orchestrator.GetOrder(result.Execution.OrderID)
 	s.NoError(err)
@@ -179,8 +179,8 @@ func (s *SagaTestSuite) TestConcurrentOrders() {
 	for i := 0; i < 5; i++ {
 		go func(index int) {
 			result := orchestrator.ExecuteOrderSaga(
-				fmt.Sprintf("saga-%d", index),
-				fmt.Sprintf("order-%d", index),
+				fmt.Sprintf("concurrent-saga-%d", index),
+				fmt.Sprintf("concurrent-order-%d", index),
 				"user1",
 				items,
 			)
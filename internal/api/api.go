@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"homework/internal/auth"
+	"homework/internal/model"
+	"homework/internal/saga"
+	"homework/internal/service"
+	"homework/internal/tenant"
+)
+
+// API is the authorized entry point for transport handlers. Every call takes
+// the caller's principal from ctx and only ever reaches the services of the
+// principal's tenant, so there is no way to name another tenant's data.
+// Within the tenant, customers may only place, change and see their own
+// orders and sagas, support may act on any order, and balance, stock and
+// discount changes are reserved for admins.
+type API struct {
+	tenants *tenant.Registry
+}
+
+func New(tenants *tenant.Registry) *API {
+	return &API{tenants: tenants}
+}
+
+// TenantConfig returns the configuration of the caller's tenant, e.g. the
+// currency its amounts are in.
+func (a *API) TenantConfig(ctx context.Context) (model.Tenant, error) {
+	_, scope, err := a.scope(ctx)
+	if err != nil {
+		return model.Tenant{}, err
+	}
+	return scope.Config, nil
+}
+
+func (a *API) PlaceOrder(ctx context.Context, sagaID, orderID, userID string, items []model.OrderItem, options saga.OrderSagaOptions) (*saga.SagaResult, error) {
+	scope, err := a.authorizeUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PromoCode != "" && scope.Config.DiscountPolicy.DisablePromoCodes {
+		return nil, fmt.Errorf("%w: promo codes are disabled for tenant %s", service.ErrInvalidPromoCode, scope.Config.ID)
+	}
+	return scope.Orchestrator.ExecuteOrderSagaWithOptions(sagaID, orderID, userID, items, options), nil
+}
+
+func (a *API) ModifyOrder(ctx context.Context, sagaID, orderID string, items []model.OrderItem) (*saga.SagaResult, error) {
+	scope, _, err := a.authorizeOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return scope.Orchestrator.ModifyOrder(sagaID, orderID, items), nil
+}
+
+func (a *API) CancelOrder(ctx context.Context, sagaID, orderID, reason string) (*saga.SagaResult, error) {
+	scope, _, err := a.authorizeOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return scope.Orchestrator.ExecuteCancelOrderSaga(sagaID, orderID, reason), nil
+}
+
+func (a *API) RequestReturn(ctx context.Context, orderID string, items []model.ReturnItem, reason string) (*model.ReturnRequest, error) {
+	scope, _, err := a.authorizeOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return scope.Orchestrator.RequestReturn(orderID, items, reason)
+}
+
+func (a *API) GetOrder(ctx context.Context, orderID string) (*model.Order, error) {
+	_, order, err := a.authorizeOrder(ctx, orderID)
+	return order, err
+}
+
+// ListOrders lists the caller's own orders for customers. A customer asking
+// for another user's orders is refused rather than silently narrowed.
+func (a *API) ListOrders(ctx context.Context, query model.OrderQuery) (*model.OrderPage, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if principal.Role == auth.RoleCustomer {
+		if query.Filter.UserID != "" && query.Filter.UserID != principal.Subject {
+			return nil, fmt.Errorf("%w: orders of user %s", auth.ErrForbidden, query.Filter.UserID)
+		}
+		query.Filter.UserID = principal.Subject
+	}
+
+	return scope.Orchestrator.ListOrders(query)
+}
+
+func (a *API) GetSaga(ctx context.Context, sagaID string) (*saga.SagaExecution, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execution, err := scope.Orchestrator.GetSagaExecution(sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !principal.CanActFor(execution.UserID) {
+		return nil, fmt.Errorf("%w: saga %s", auth.ErrForbidden, sagaID)
+	}
+	return execution, nil
+}
+
+func (a *API) ListSagas(ctx context.Context) ([]*saga.SagaExecution, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sagas := scope.Orchestrator.GetAllSagas()
+	visible := sagas[:0]
+	for _, execution := range sagas {
+		if principal.CanActFor(execution.UserID) {
+			visible = append(visible, execution)
+		}
+	}
+	return visible, nil
+}
+
+func (a *API) GetUserBalance(ctx context.Context, userID string) (float64, error) {
+	scope, err := a.authorizeUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return scope.Billing.GetUserBalance(userID), nil
+}
+
+func (a *API) SetUserBalance(ctx context.Context, userID string, balance float64) error {
+	scope, err := a.requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	scope.Billing.SetUserBalance(userID, balance)
+	return nil
+}
+
+func (a *API) SetStock(ctx context.Context, productID string, stock int) error {
+	scope, err := a.requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	scope.Inventory.SetStock(productID, stock)
+	return nil
+}
+
+func (a *API) SetUserDiscount(ctx context.Context, userID string, percentage float64) error {
+	scope, err := a.requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	scope.Discounts.SetUserDiscount(userID, percentage)
+	return nil
+}
+
+// scope resolves the caller and the tenant its credentials were issued for.
+func (a *API) scope(ctx context.Context) (*auth.Principal, *tenant.Tenant, error) {
+	principal, err := auth.PrincipalFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scope, err := a.tenants.Get(principal.TenantID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", auth.ErrForbidden, err)
+	}
+	return principal, scope, nil
+}
+
+// authorizeOrder loads the order and checks that the caller may act on it.
+func (a *API) authorizeOrder(ctx context.Context, orderID string) (*tenant.Tenant, *model.Order, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order, err := scope.Orchestrator.GetOrder(orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !principal.CanActFor(order.UserID) {
+		return nil, nil, fmt.Errorf("%w: order %s", auth.ErrForbidden, orderID)
+	}
+	return scope, order, nil
+}
+
+func (a *API) authorizeUser(ctx context.Context, userID string) (*tenant.Tenant, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !principal.CanActFor(userID) {
+		return nil, fmt.Errorf("%w: user %s", auth.ErrForbidden, userID)
+	}
+	return scope, nil
+}
+
+func (a *API) requireAdmin(ctx context.Context) (*tenant.Tenant, error) {
+	principal, scope, err := a.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if principal.Role != auth.RoleAdmin {
+		return nil, fmt.Errorf("%w: admin role required", auth.ErrForbidden)
+	}
+	return scope, nil
+}
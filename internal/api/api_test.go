@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"homework/internal/auth"
+	"homework/internal/model"
+	"homework/internal/saga"
+	"homework/internal/service"
+	"homework/internal/tenant"
+)
+
+func TestAPI_CustomersOnlySeeTheirOwnOrders(t *testing.T) {
+	api := createTestAPI()
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "alice", Role: auth.RoleCustomer})
+	bob := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "bob", Role: auth.RoleCustomer})
+	support := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "agent", Role: auth.RoleSupport})
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 10.0}}
+
+	if _, err := api.PlaceOrder(bob, "saga-0", "order-0", "alice", items, saga.OrderSagaOptions{}); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden placing an order for someone else, got: %v", err)
+	}
+
+	result, err := api.PlaceOrder(alice, "saga-1", "order-1", "alice", items, saga.OrderSagaOptions{})
+	if err != nil || !result.Success {
+		t.Fatalf("Expected order to succeed, got: %v, %v", err, result.Error)
+	}
+	api.PlaceOrder(bob, "saga-2", "order-2", "bob", items, saga.OrderSagaOptions{})
+	orderID := result.Execution.OrderID
+
+	if _, err := api.GetOrder(alice, orderID); err != nil {
+		t.Errorf("Expected owner to read the order, got: %v", err)
+	}
+	if _, err := api.GetOrder(bob, orderID); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for another customer's order, got: %v", err)
+	}
+	if _, err := api.GetOrder(support, orderID); err != nil {
+		t.Errorf("Expected support to read any order, got: %v", err)
+	}
+	if _, err := api.CancelOrder(bob, "cancel-1", orderID, "not mine"); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden cancelling another customer's order, got: %v", err)
+	}
+
+	page, _ := api.ListOrders(alice, model.OrderQuery{})
+	if len(page.Orders) != 1 || page.Orders[0].UserID != "alice" {
+		t.Errorf("Expected only alice's order, got %d orders", len(page.Orders))
+	}
+	if _, err := api.ListOrders(alice, model.OrderQuery{Filter: model.OrderFilter{UserID: "bob"}}); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden listing bob's orders, got: %v", err)
+	}
+
+	if _, err := api.GetSaga(bob, "saga-1"); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for another customer's saga, got: %v", err)
+	}
+	if reused, _ := api.PlaceOrder(bob, "saga-1", "order-3", "bob", items, saga.OrderSagaOptions{}); !errors.Is(reused.Error, saga.ErrSagaExists) {
+		t.Errorf("Expected ErrSagaExists reusing another customer's saga ID, got: %v", reused.Error)
+	}
+	if execution, err := api.GetSaga(alice, "saga-1"); err != nil || execution.UserID != "alice" || execution.Status != saga.SagaStatusCompleted {
+		t.Errorf("Expected alice's saga to be left alone, got %+v, %v", execution, err)
+	}
+	sagas, _ := api.ListSagas(alice)
+	for _, execution := range sagas {
+		if execution.UserID != "alice" {
+			t.Errorf("Expected only alice's sagas, got one of %s", execution.UserID)
+		}
+	}
+	if all, _ := api.ListSagas(support); len(all) < 2 {
+		t.Errorf("Expected support to see every saga, got %d", len(all))
+	}
+}
+
+func TestAPI_AdminOnlyChanges(t *testing.T) {
+	api := createTestAPI()
+	customer := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "alice", Role: auth.RoleCustomer})
+	support := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "agent", Role: auth.RoleSupport})
+	admin := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "root", Role: auth.RoleAdmin})
+
+	for _, ctx := range []context.Context{customer, support} {
+		if err := api.SetUserBalance(ctx, "alice", 1e6); !errors.Is(err, auth.ErrForbidden) {
+			t.Errorf("Expected ErrForbidden changing a balance, got: %v", err)
+		}
+		if err := api.SetStock(ctx, "product1", 0); !errors.Is(err, auth.ErrForbidden) {
+			t.Errorf("Expected ErrForbidden changing stock, got: %v", err)
+		}
+		if err := api.SetUserDiscount(ctx, "alice", 99.0); !errors.Is(err, auth.ErrForbidden) {
+			t.Errorf("Expected ErrForbidden changing a discount, got: %v", err)
+		}
+	}
+
+	if err := api.SetUserBalance(admin, "alice", 42.0); err != nil {
+		t.Fatalf("Expected admin to change balances, got: %v", err)
+	}
+	if balance, _ := api.GetUserBalance(customer, "alice"); balance != 42.0 {
+		t.Errorf("Expected balance 42.00, got %.2f", balance)
+	}
+
+	if _, err := api.GetUserBalance(context.Background(), "alice"); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated without a principal, got: %v", err)
+	}
+}
+
+func TestAPI_TenantIsolation(t *testing.T) {
+	api := createTestAPI()
+	shop1 := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop1", Subject: "alice", Role: auth.RoleCustomer})
+	shop2 := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop2", Subject: "alice", Role: auth.RoleCustomer})
+	shop2Admin := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop2", Subject: "root", Role: auth.RoleAdmin})
+	unknown := auth.WithPrincipal(context.Background(), &auth.Principal{TenantID: "shop3", Subject: "alice", Role: auth.RoleAdmin})
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 10.0}}
+
+	result, err := api.PlaceOrder(shop1, "saga-1", "order-1", "alice", items, saga.OrderSagaOptions{})
+	if err != nil || !result.Success {
+		t.Fatalf("Expected order to succeed, got: %v, %v", err, result.Error)
+	}
+
+	order, _ := api.GetOrder(shop1, result.Execution.OrderID)
+	if order.TenantID != "shop1" || result.Execution.TenantID != "shop1" {
+		t.Errorf("Expected order and saga of shop1, got %q and %q", order.TenantID, result.Execution.TenantID)
+	}
+
+	// The same user ID in another tenant is a different customer.
+	if _, err := api.GetOrder(shop2, order.ID); err == nil {
+		t.Error("Expected shop1's order to be invisible from shop2")
+	}
+	if _, err := api.GetSaga(shop2, "saga-1"); err == nil {
+		t.Error("Expected shop1's saga to be invisible from shop2")
+	}
+	if page, _ := api.ListOrders(shop2, model.OrderQuery{}); len(page.Orders) != 0 {
+		t.Errorf("Expected no orders in shop2, got %d", len(page.Orders))
+	}
+
+	if err := api.SetUserBalance(shop2Admin, "alice", 5.0); err != nil {
+		t.Fatalf("Expected shop2's admin to change shop2 balances, got: %v", err)
+	}
+	// 1000.00 less the 10.00 order and 6.00 standard shipping.
+	if balance, _ := api.GetUserBalance(shop1, "alice"); balance != 984.0 {
+		t.Errorf("Expected shop1's balance untouched at 984.00, got %.2f", balance)
+	}
+
+	if _, err := api.GetUserBalance(unknown, "alice"); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for an unknown tenant, got: %v", err)
+	}
+
+	config, _ := api.TenantConfig(shop2)
+	if config.Currency != "EUR" || !config.DiscountPolicy.DisablePromoCodes {
+		t.Errorf("Expected shop2 to use EUR without promo codes, got %+v", config)
+	}
+	_, err = api.PlaceOrder(shop2, "saga-2", "order-2", "alice", items, saga.OrderSagaOptions{PromoCode: "ANY"})
+	if !errors.Is(err, service.ErrInvalidPromoCode) {
+		t.Errorf("Expected promo codes to be disabled in shop2, got: %v", err)
+	}
+}
+
+func createTestAPI() *API {
+	tenants := tenant.NewRegistry()
+
+	shop1, _ := tenants.Register(model.Tenant{ID: "shop1", Name: "Shop One", Currency: "USD"})
+	shop1.Billing.SetUserBalance("alice", 1000.0)
+	shop1.Billing.SetUserBalance("bob", 1000.0)
+	shop1.Inventory.SetStock("product1", 100)
+
+	shop2, _ := tenants.Register(model.Tenant{
+		ID:             "shop2",
+		Name:           "Shop Two",
+		Currency:       "EUR",
+		DiscountPolicy: model.DiscountPolicy{DisablePromoCodes: true},
+	})
+	shop2.Inventory.SetStock("product1", 100)
+
+	return New(tenants)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// APIKeyStore issues long-lived keys for machine clients. Only a SHA-256
+// hash of each key is kept, so the plain key is shown once at creation.
+type APIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]Principal
+}
+
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{keys: make(map[string]Principal)}
+}
+
+func (s *APIKeyStore) Create(principal Principal) (string, error) {
+	if !principal.valid() {
+		return "", fmt.Errorf("invalid principal: %q of tenant %q with role %q", principal.Subject, principal.TenantID, principal.Role)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[hashAPIKey(key)] = principal
+	return key, nil
+}
+
+func (s *APIKeyStore) Revoke(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, hashAPIKey(key))
+}
+
+func (s *APIKeyStore) Verify(key string) (*Principal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	principal, exists := s.keys[hashAPIKey(key)]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	return &principal, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
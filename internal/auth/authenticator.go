@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator accepts a bearer JWT in the Authorization header or an API
+// key in the X-API-Key header.
+type Authenticator struct {
+	tokens  *KeyRing
+	apiKeys *APIKeyStore
+}
+
+func NewAuthenticator(tokens *KeyRing, apiKeys *APIKeyStore) *Authenticator {
+	return &Authenticator{tokens: tokens, apiKeys: apiKeys}
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		if a.apiKeys == nil {
+			return nil, fmt.Errorf("%w: API keys are not accepted", ErrUnauthenticated)
+		}
+		return a.apiKeys.Verify(key)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, fmt.Errorf("%w: missing credentials", ErrUnauthenticated)
+	}
+
+	if !strings.HasPrefix(header, "Bearer ") || a.tokens == nil {
+		return nil, fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+	}
+	return a.tokens.Verify(strings.TrimPrefix(header, "Bearer "))
+}
+
+// Middleware authenticates every request and stores the principal in its
+// context. Requests without valid credentials get 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// StatusCode maps authentication and authorization errors to HTTP status
+// codes, and anything else to 0.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	}
+	return 0
+}
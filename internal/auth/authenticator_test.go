@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	ring := NewKeyRing("k1", []byte("secret"))
+	apiKeys := NewAPIKeyStore()
+	authenticator := NewAuthenticator(ring, apiKeys)
+
+	var seen *Principal
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFromContext(r.Context())
+	}))
+
+	token, _ := ring.Issue(Principal{TenantID: "shop1", Subject: "user1", Role: RoleCustomer}, time.Hour)
+	key, _ := apiKeys.Create(Principal{TenantID: "shop1", Subject: "ops", Role: RoleAdmin})
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		status  int
+		subject string
+	}{
+		{"bearer token", "Authorization", "Bearer " + token, http.StatusOK, "user1"},
+		{"api key", APIKeyHeader, key, http.StatusOK, "ops"},
+		{"missing credentials", "", "", http.StatusUnauthorized, ""},
+		{"basic auth", "Authorization", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, ""},
+		{"unknown api key", APIKeyHeader, "nope", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			if tt.header != "" {
+				request.Header.Set(tt.header, tt.value)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, recorder.Code)
+			}
+			if tt.subject != "" && (seen == nil || seen.Subject != tt.subject) {
+				t.Errorf("Expected principal %s in the context, got %+v", tt.subject, seen)
+			}
+		})
+	}
+
+	apiKeys.Revoke(key)
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set(APIKeyHeader, key)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked key to be rejected, got status %d", recorder.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleCustomer, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Principal is the authenticated caller. Subject is the user ID of a
+// customer and the operator's ID for support and admin. Every principal
+// belongs to exactly one tenant, staff included.
+type Principal struct {
+	TenantID string
+	Subject  string
+	Role     Role
+}
+
+func (p Principal) valid() bool {
+	return p.TenantID != "" && p.Subject != "" && p.Role.Valid()
+}
+
+// CanActFor reports whether the principal may read or change data owned by
+// userID within its tenant. Customers are limited to their own data; staff
+// may act for anyone.
+func (p *Principal) CanActFor(userID string) bool {
+	if p.Role == RoleSupport || p.Role == RoleAdmin {
+		return true
+	}
+	return p.Subject == userID
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, error) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	if !ok || principal == nil {
+		return nil, ErrUnauthenticated
+	}
+	return principal, nil
+}
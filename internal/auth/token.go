@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type tokenClaims struct {
+	TenantID  string `json:"tid"`
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// KeyRing signs JWTs with HMAC-SHA256 using local keys. New tokens are
+// signed with the active key; tokens signed with any key still in the ring
+// verify, so keys can be rotated without logging everyone out.
+type KeyRing struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+	now    func() time.Time
+}
+
+func NewKeyRing(keyID string, secret []byte) *KeyRing {
+	ring := &KeyRing{keys: make(map[string][]byte), now: time.Now}
+	ring.AddKey(keyID, secret)
+	ring.active = keyID
+	return ring
+}
+
+func (k *KeyRing) AddKey(keyID string, secret []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyID] = append([]byte(nil), secret...)
+}
+
+// Rotate makes keyID the signing key. The key must already be in the ring.
+func (k *KeyRing) Rotate(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, exists := k.keys[keyID]; !exists {
+		return fmt.Errorf("signing key not found: %s", keyID)
+	}
+	k.active = keyID
+	return nil
+}
+
+func (k *KeyRing) RemoveKey(keyID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, keyID)
+}
+
+func (k *KeyRing) Issue(principal Principal, ttl time.Duration) (string, error) {
+	if !principal.valid() {
+		return "", fmt.Errorf("invalid principal: %q of tenant %q with role %q", principal.Subject, principal.TenantID, principal.Role)
+	}
+
+	k.mu.RLock()
+	keyID := k.active
+	secret, exists := k.keys[keyID]
+	k.mu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("signing key not found: %s", keyID)
+	}
+
+	now := k.now()
+	header, err := encodeSegment(tokenHeader{Algorithm: "HS256", Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encodeSegment(tokenClaims{
+		TenantID:  principal.TenantID,
+		Subject:   principal.Subject,
+		Role:      principal.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + claims
+	return signingInput + "." + sign(secret, signingInput), nil
+}
+
+func (k *KeyRing) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Algorithm != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrUnauthenticated, header.Algorithm)
+	}
+
+	k.mu.RLock()
+	secret, exists := k.keys[header.KeyID]
+	k.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrUnauthenticated, header.KeyID)
+	}
+
+	expected := sign(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if !k.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	principal := Principal{TenantID: claims.TenantID, Subject: claims.Subject, Role: claims.Role}
+	if !principal.valid() {
+		return nil, fmt.Errorf("%w: invalid claims", ErrUnauthenticated)
+	}
+
+	return &principal, nil
+}
+
+func sign(secret []byte, signingInput string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeSegment(value interface{}) (string, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_IssueAndVerify(t *testing.T) {
+	ring := NewKeyRing("k1", []byte("first-secret"))
+
+	token, err := ring.Issue(Principal{TenantID: "shop1", Subject: "user1", Role: RoleCustomer}, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	principal, err := ring.Verify(token)
+	if err != nil {
+		t.Fatalf("Expected valid token, got: %v", err)
+	}
+	if principal.Subject != "user1" || principal.Role != RoleCustomer {
+		t.Errorf("Unexpected principal: %+v", principal)
+	}
+
+	parts := strings.Split(token, ".")
+	forged, _ := encodeSegment(tokenClaims{Subject: "user1", Role: RoleAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if _, err := ring.Verify(parts[0] + "." + forged + "." + parts[2]); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected tampered claims to be rejected, got: %v", err)
+	}
+
+	other := NewKeyRing("k1", []byte("other-secret"))
+	if _, err := other.Verify(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected a token signed with another secret to be rejected, got: %v", err)
+	}
+
+	if _, err := ring.Verify("not.a.token"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected malformed token to be rejected, got: %v", err)
+	}
+}
+
+func TestKeyRing_Expiry(t *testing.T) {
+	ring := NewKeyRing("k1", []byte("secret"))
+	now := time.Now()
+	ring.now = func() time.Time { return now }
+
+	token, _ := ring.Issue(Principal{TenantID: "shop1", Subject: "user1", Role: RoleCustomer}, time.Minute)
+
+	now = now.Add(2 * time.Minute)
+	if _, err := ring.Verify(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected expired token to be rejected, got: %v", err)
+	}
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	ring := NewKeyRing("k1", []byte("first-secret"))
+	old, _ := ring.Issue(Principal{TenantID: "shop1", Subject: "admin1", Role: RoleAdmin}, time.Hour)
+
+	ring.AddKey("k2", []byte("second-secret"))
+	if err := ring.Rotate("k2"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	fresh, _ := ring.Issue(Principal{TenantID: "shop1", Subject: "admin1", Role: RoleAdmin}, time.Hour)
+
+	if _, err := ring.Verify(old); err != nil {
+		t.Errorf("Expected token of the previous key to stay valid, got: %v", err)
+	}
+
+	ring.RemoveKey("k1")
+	if _, err := ring.Verify(old); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected token of a removed key to be rejected, got: %v", err)
+	}
+	if _, err := ring.Verify(fresh); err != nil {
+		t.Errorf("Expected token of the active key to be valid, got: %v", err)
+	}
+
+	if err := ring.Rotate("missing"); err == nil {
+		t.Error("Expected error rotating to an unknown key")
+	}
+}
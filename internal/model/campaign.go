@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+// Campaign is a time-boxed discount paid for from a fixed budget. It takes
+// Percentage of the order or a fixed Amount off; EligibleUsers and Segments
+// restrict who can use it, and both empty means everyone.
+type Campaign struct {
+	ID            string
+	TenantID      string
+	Name          string
+	StartsAt      time.Time
+	EndsAt        time.Time
+	EligibleUsers []string
+	Segments      []string
+	Percentage    float64
+	Amount        float64
+	Budget        float64
+	Spent         float64
+	Redemptions   int
+}
+
+func (c *Campaign) Clone() *Campaign {
+	clone := *c
+	clone.EligibleUsers = append([]string(nil), c.EligibleUsers...)
+	clone.Segments = append([]string(nil), c.Segments...)
+	return &clone
+}
+
+func (c *Campaign) IsActive(now time.Time) bool {
+	return !now.Before(c.StartsAt) && now.Before(c.EndsAt)
+}
+
+func (c *Campaign) RemainingBudget() float64 {
+	return c.Budget - c.Spent
+}
+
+// DiscountFor returns what the campaign takes off an order amount, before
+// checking the budget.
+func (c *Campaign) DiscountFor(amount float64) float64 {
+	discount := c.Amount
+	if c.Percentage > 0 {
+		discount = amount * c.Percentage / 100.0
+	}
+	if discount > amount {
+		discount = amount
+	}
+	return discount
+}
+
+type CampaignReport struct {
+	CampaignID      string
+	Name            string
+	Active          bool
+	Redemptions     int
+	Spent           float64
+	RemainingBudget float64
+}
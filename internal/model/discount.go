@@ -1,9 +1,91 @@
 package model
 
+import "time"
+
 type Discount struct {
 	ID         string
+	TenantID   string
 	UserID     string
 	OrderID    string
 	Amount     float64
 	Percentage float64
+	PromoCode  string
+	// CampaignID and CampaignAmount record the part of the discount drawn
+	// from a campaign budget.
+	CampaignID     string
+	CampaignAmount float64
+	// AppliedRules lists the rules behind a rule-based discount.
+	AppliedRules []AppliedRule
+	// Lines splits Amount over the order lines in cents.
+	Lines []LineAllocation
+}
+
+func (d *Discount) Clone() *Discount {
+	clone := *d
+	if d.AppliedRules != nil {
+		clone.AppliedRules = make([]AppliedRule, len(d.AppliedRules))
+		for i, rule := range d.AppliedRules {
+			rule.Allocations = append([]LineAllocation(nil), rule.Allocations...)
+			clone.AppliedRules[i] = rule
+		}
+	}
+	clone.Lines = append([]LineAllocation(nil), d.Lines...)
+	return &clone
+}
+
+type PromoCodeType string
+
+const (
+	PromoCodePercentage PromoCodeType = "percentage"
+	PromoCodeFixed      PromoCodeType = "fixed"
+)
+
+// PromoCode is a discount redeemed by code. Value is a percentage or a fixed
+// amount depending on Type. Zero limits and zero validity bounds mean no
+// restriction.
+type PromoCode struct {
+	Code                  string
+	TenantID              string
+	Type                  PromoCodeType
+	Value                 float64
+	ValidFrom             time.Time
+	ValidUntil            time.Time
+	MinOrderTotal         float64
+	MaxRedemptions        int
+	MaxRedemptionsPerUser int
+	Redemptions           int
+	RedemptionsByUser     map[string]int
+}
+
+func (p *PromoCode) Clone() *PromoCode {
+	clone := *p
+	clone.RedemptionsByUser = make(map[string]int, len(p.RedemptionsByUser))
+	for userID, count := range p.RedemptionsByUser {
+		clone.RedemptionsByUser[userID] = count
+	}
+	return &clone
+}
+
+// IsActive reports whether the code can be redeemed at the given time.
+func (p *PromoCode) IsActive(now time.Time) bool {
+	if !p.ValidFrom.IsZero() && now.Before(p.ValidFrom) {
+		return false
+	}
+	if !p.ValidUntil.IsZero() && !now.Before(p.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// DiscountFor returns the amount taken off an order total, never more than
+// the total itself.
+func (p *PromoCode) DiscountFor(totalAmount float64) float64 {
+	amount := p.Value
+	if p.Type == PromoCodePercentage {
+		amount = totalAmount * p.Value / 100.0
+	}
+	if amount > totalAmount {
+		amount = totalAmount
+	}
+	return amount
 }
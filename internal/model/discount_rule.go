@@ -0,0 +1,83 @@
+package model
+
+import "time"
+
+type StackingPolicy string
+
+const (
+	// StackingExclusive rules apply alone, and only when no matching rule
+	// has a higher priority.
+	StackingExclusive StackingPolicy = "exclusive"
+	// StackingBestOf rules compete with each other: only the largest applies.
+	StackingBestOf StackingPolicy = "best_of"
+	// StackingCumulative rules add up with every other applied rule.
+	StackingCumulative StackingPolicy = "cumulative"
+)
+
+type RuleActionType string
+
+const (
+	RuleActionPercentOff RuleActionType = "percent_off"
+	RuleActionFixedOff   RuleActionType = "fixed_off"
+	RuleActionBuyXGetY   RuleActionType = "buy_x_get_y"
+	RuleActionFreeItem   RuleActionType = "free_item"
+)
+
+type DiscountRule struct {
+	ID         string
+	Name       string
+	Priority   int
+	Stacking   StackingPolicy
+	Conditions RuleConditions
+	Action     RuleAction
+}
+
+// RuleConditions must all hold for a rule to match. ProductIDs and
+// Categories restrict the lines the rule applies to; empty fields match
+// everything.
+type RuleConditions struct {
+	Segments      []string
+	ProductIDs    []string
+	Categories    []string
+	MinOrderTotal float64
+	MinQuantity   int
+	Weekdays      []time.Weekday
+}
+
+// RuleAction describes the reward. Percentage is used by percent_off, Amount
+// by fixed_off, BuyQuantity and GetQuantity by buy_x_get_y (every
+// BuyQuantity+GetQuantity units, GetQuantity are free), and FreeProductID and
+// FreeQuantity by free_item.
+type RuleAction struct {
+	Type          RuleActionType
+	Percentage    float64
+	Amount        float64
+	BuyQuantity   int
+	GetQuantity   int
+	FreeProductID string
+	FreeQuantity  int
+}
+
+// AppliedRule is a rule that contributed to a discount, with its amount
+// split over the order lines it applied to.
+type AppliedRule struct {
+	RuleID      string
+	Name        string
+	Amount      float64
+	Allocations []LineAllocation
+}
+
+// LineAllocation is the part of a discount attributed to one order line,
+// identified by its index in the order items.
+type LineAllocation struct {
+	Line      int
+	ProductID string
+	Amount    float64
+}
+
+// DiscountCap limits the total discount of an order to Amount and to
+// Percentage of its subtotal. Zero fields do not limit.
+type DiscountCap struct {
+	Amount     float64
+	Percentage float64
+}
@@ -1,17 +1,117 @@
 package model
 
+import "time"
+
+// InventoryReservation holds stock for an order. Pending is set while the
+// saga that reserved the stock is still in flight.
 type InventoryReservation struct {
 	ID        string
+	TenantID  string
 	OrderID   string
 	ProductID string
 	Quantity  int
 	Status    ReservationStatus
+	Pending   bool
+	CreatedAt time.Time
+}
+
+func (r *InventoryReservation) Clone() *InventoryReservation {
+	clone := *r
+	return &clone
 }
 
 type ReservationStatus string
 
 const (
 	ReservationStatusReserved ReservationStatus = "reserved"
+	ReservationStatusBackordered ReservationStatus = "backordered"
 	ReservationStatusReleased  ReservationStatus = "released"
 	ReservationStatusFailed    ReservationStatus = "failed"
 )
+
+// StockLevel is the stock of a product together with the units held by
+// reservations of sagas still in flight. Stock already excludes them; a
+// reader willing to bet on those sagas failing may count them as available.
+type StockLevel struct {
+	ProductID string
+	Stock     int
+	Pending   int
+}
+
+type InventoryEvent struct {
+	Type              InventoryEventType
+	ProductID         string
+	OrderID           string
+	ReservationID     string
+	Quantity          int
+	Stock             int
+	SuggestedQuantity int
+	CreatedAt         time.Time
+}
+
+type InventoryEventType string
+
+const (
+	InventoryEventBackordered        InventoryEventType = "backordered"
+	InventoryEventBackorderFulfilled InventoryEventType = "backorder_fulfilled"
+	InventoryEventLowStock           InventoryEventType = "low_stock"
+)
+
+type StockMovement struct {
+	ID         string
+	TenantID   string
+	ProductID  string
+	Type       StockMovementType
+	Quantity   int
+	StockAfter int
+	OrderID    string
+	Reason     string
+	Actor      string
+	CreatedAt  time.Time
+}
+
+type StockMovementType string
+
+const (
+	StockMovementRestock    StockMovementType = "restock"
+	StockMovementReserve    StockMovementType = "reserve"
+	StockMovementRelease    StockMovementType = "release"
+	StockMovementAdjustment StockMovementType = "adjustment"
+	StockMovementShrinkage  StockMovementType = "shrinkage"
+)
+
+type StockMovementFilter struct {
+	Types []StockMovementType
+	From  time.Time
+	To    time.Time
+}
+
+func (f StockMovementFilter) Matches(movement StockMovement) bool {
+	if !f.From.IsZero() && movement.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !movement.CreatedAt.Before(f.To) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, movementType := range f.Types {
+		if movement.Type == movementType {
+			return true
+		}
+	}
+	return false
+}
+
+type StockReconciliation struct {
+	ProductID     string
+	ExpectedStock int
+	ActualStock   int
+	Discrepancy   int
+	Movements     int
+}
+
+func (r StockReconciliation) Balanced() bool {
+	return r.Discrepancy == 0
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+type LoyaltyTransactionType string
+
+const (
+	LoyaltyTransactionAccrual            LoyaltyTransactionType = "accrual"
+	LoyaltyTransactionRedemption         LoyaltyTransactionType = "redemption"
+	LoyaltyTransactionAccrualReversal    LoyaltyTransactionType = "accrual_reversal"
+	LoyaltyTransactionRedemptionReversal LoyaltyTransactionType = "redemption_reversal"
+	LoyaltyTransactionExpiry             LoyaltyTransactionType = "expiry"
+)
+
+// LoyaltyTransaction is one entry of a user's points history. Points are
+// positive for every type; the type tells whether they were added or taken.
+// Amount is the money value of redeemed points and ExpiresAt is set on
+// accruals only.
+type LoyaltyTransaction struct {
+	ID        string
+	TenantID  string
+	UserID    string
+	OrderID   string
+	Type      LoyaltyTransactionType
+	Points    int
+	Amount    float64
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// LoyaltyProgram sets how many points a unit of money spent earns, what a
+// point is worth when redeemed and how long accrued points stay valid.
+type LoyaltyProgram struct {
+	PointsPerUnit float64
+	PointValue    float64
+	PointsTTL     time.Duration
+}
@@ -4,24 +4,144 @@ import "time"
 
 type Order struct {
 	ID        string
+	TenantID  string
 	UserID   string
 	Items    []OrderItem
 	Status   OrderStatus
+	StatusHistory []OrderStatusChange
+	ShippingCost float64
+	PromoCode string
 	Total    float64
+	Currency string
+	Version   int64
+	// LockedBySaga names the saga still working on the order when the order
+	// is read through the orchestrator; the order may yet be rolled back.
+	LockedBySaga string
 	CreatedAt time.Time
 }
 
+func (o *Order) Clone() *Order {
+	clone := *o
+	clone.Items = append([]OrderItem(nil), o.Items...)
+	clone.StatusHistory = append([]OrderStatusChange(nil), o.StatusHistory...)
+	return &clone
+}
+
+// Subtotal is the value of the order lines, excluding shipping.
+func (o *Order) Subtotal() float64 {
+	subtotal := 0.0
+	for _, item := range o.Items {
+		subtotal += item.Price * float64(item.Quantity)
+	}
+	return subtotal
+}
+
 type OrderItem struct {
 	ProductID string
 	Quantity  int
 	Price     float64
+	// Discount is the part of the order discount allocated to this line.
+	Discount float64
+}
+
+// DiscountTotal is the order discount as allocated over the lines.
+func (o *Order) DiscountTotal() float64 {
+	total := 0.0
+	for _, item := range o.Items {
+		total += item.Discount
+	}
+	return total
 }
 
 type OrderStatus string
 
 const (
 	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusAwaitingPayment OrderStatus = "awaiting_payment"
 	OrderStatusConfirmed OrderStatus = "confirmed"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
 	OrderStatusFailed    OrderStatus = "failed"
 	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusRefunded  OrderStatus = "refunded"
+)
+
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:         {OrderStatusAwaitingPayment, OrderStatusCancelled, OrderStatusFailed},
+	OrderStatusAwaitingPayment: {OrderStatusConfirmed, OrderStatusCancelled, OrderStatusFailed},
+	OrderStatusConfirmed:       {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:         {OrderStatusDelivered, OrderStatusRefunded},
+	OrderStatusDelivered:       {OrderStatusRefunded},
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s OrderStatus) IsTerminal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+type OrderStatusChange struct {
+	From   OrderStatus
+	To     OrderStatus
+	Reason string
+	At     time.Time
+}
+
+// OrderFilter selects orders for listing. Zero fields match everything; the
+// created-at range is half-open [CreatedFrom, CreatedTo).
+type OrderFilter struct {
+	UserID      string
+	Statuses    []OrderStatus
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+func (f OrderFilter) Matches(order *Order) bool {
+	if f.UserID != "" && order.UserID != f.UserID {
+		return false
+	}
+	if !f.CreatedFrom.IsZero() && order.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && !order.CreatedAt.Before(f.CreatedTo) {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if order.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+type OrderSortField string
+
+const (
+	OrderSortByCreatedAt OrderSortField = "created_at"
+	OrderSortByTotal     OrderSortField = "total"
 )
+
+// OrderQuery describes one page of a listing. Cursor is the NextCursor of the
+// previous page and is only valid with the same filter and sort.
+type OrderQuery struct {
+	Filter     OrderFilter
+	SortBy     OrderSortField
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+type OrderPage struct {
+	Orders     []*Order
+	NextCursor string
+}
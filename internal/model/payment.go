@@ -3,19 +3,36 @@ package model
 import "time"
 
 type Payment struct {
-	ID        string
-	OrderID   string
-	UserID    string
-	Amount    float64
-	Status    PaymentStatus
-	CreatedAt time.Time
+	ID             string
+	TenantID       string
+	OrderID        string
+	UserID         string
+	Amount         float64
+	RefundedAmount float64
+	Currency       string
+	Status         PaymentStatus
+	Version        int64
+	CreatedAt      time.Time
+}
+
+func (p *Payment) Clone() *Payment {
+	clone := *p
+	return &clone
 }
 
 type PaymentStatus string
 
 const (
-	PaymentStatusPending   PaymentStatus = "pending"
-	PaymentStatusCompleted PaymentStatus = "completed"
-	PaymentStatusFailed    PaymentStatus = "failed"
-	PaymentStatusRefunded  PaymentStatus = "refunded"
+	PaymentStatusPending           PaymentStatus = "pending"
+	PaymentStatusCompleted         PaymentStatus = "completed"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
 )
+
+func (p *Payment) Refundable() float64 {
+	if p.Status != PaymentStatusCompleted && p.Status != PaymentStatusPartiallyRefunded {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
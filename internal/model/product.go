@@ -1,7 +1,37 @@
 package model
 
+import "time"
+
 type Product struct {
-	ID    string
-	Price float64
-	Stock int
+	ID        string
+	TenantID  string
+	Price     float64
+	Stock     int
+	Backorder BackorderPolicy
+	Reorder   ReorderPolicy
+	Version   int64
+}
+
+func (p *Product) Clone() *Product {
+	clone := *p
+	return &clone
+}
+
+type BackorderPolicy struct {
+	Limit       int
+	ReleaseDate time.Time
+}
+
+func (p BackorderPolicy) IsPreorder(now time.Time) bool {
+	return now.Before(p.ReleaseDate)
+}
+
+type ReorderPolicy struct {
+	Point          int
+	LeadTime       time.Duration
+	VelocityWindow time.Duration
+}
+
+func (p ReorderPolicy) Enabled() bool {
+	return p.Point > 0
 }
@@ -0,0 +1,64 @@
+package model
+
+import "time"
+
+type ReturnRequest struct {
+	ID            string
+	TenantID      string
+	OrderID       string
+	UserID        string
+	Items         []ReturnItem
+	Reason        string
+	Status        ReturnStatus
+	StatusHistory []ReturnStatusChange
+	RefundAmount  float64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (r *ReturnRequest) Clone() *ReturnRequest {
+	clone := *r
+	clone.Items = append([]ReturnItem(nil), r.Items...)
+	clone.StatusHistory = append([]ReturnStatusChange(nil), r.StatusHistory...)
+	return &clone
+}
+
+type ReturnItem struct {
+	ProductID string
+	Quantity  int
+	Sellable  int
+}
+
+type ReturnStatus string
+
+const (
+	ReturnStatusRequested ReturnStatus = "requested"
+	ReturnStatusApproved  ReturnStatus = "approved"
+	ReturnStatusRejected  ReturnStatus = "rejected"
+	ReturnStatusReceived  ReturnStatus = "received"
+	ReturnStatusInspected ReturnStatus = "inspected"
+	ReturnStatusCompleted ReturnStatus = "completed"
+)
+
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnStatusRequested: {ReturnStatusApproved, ReturnStatusRejected},
+	ReturnStatusApproved:  {ReturnStatusReceived},
+	ReturnStatusReceived:  {ReturnStatusInspected},
+	ReturnStatusInspected: {ReturnStatusCompleted},
+}
+
+func (s ReturnStatus) CanTransitionTo(next ReturnStatus) bool {
+	for _, allowed := range returnTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type ReturnStatusChange struct {
+	From   ReturnStatus
+	To     ReturnStatus
+	Reason string
+	At     time.Time
+}
@@ -0,0 +1,64 @@
+package model
+
+import "time"
+
+type Shipment struct {
+	ID             string
+	TenantID       string
+	OrderID        string
+	UserID         string
+	Warehouse      string
+	Carrier        string
+	Cost           float64
+	EstimatedDays  int
+	TrackingNumber string
+	Status         ShipmentStatus
+	Tracking       []TrackingEvent
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (s *Shipment) Clone() *Shipment {
+	clone := *s
+	clone.Tracking = append([]TrackingEvent(nil), s.Tracking...)
+	return &clone
+}
+
+type ShipmentStatus string
+
+const (
+	ShipmentStatusCreated        ShipmentStatus = "created"
+	ShipmentStatusPickedUp       ShipmentStatus = "picked_up"
+	ShipmentStatusInTransit      ShipmentStatus = "in_transit"
+	ShipmentStatusOutForDelivery ShipmentStatus = "out_for_delivery"
+	ShipmentStatusDelivered      ShipmentStatus = "delivered"
+	ShipmentStatusCancelled      ShipmentStatus = "cancelled"
+)
+
+var shipmentTransitions = map[ShipmentStatus][]ShipmentStatus{
+	ShipmentStatusCreated:        {ShipmentStatusPickedUp, ShipmentStatusCancelled},
+	ShipmentStatusPickedUp:       {ShipmentStatusInTransit},
+	ShipmentStatusInTransit:      {ShipmentStatusOutForDelivery, ShipmentStatusDelivered},
+	ShipmentStatusOutForDelivery: {ShipmentStatusDelivered, ShipmentStatusInTransit},
+}
+
+func (s ShipmentStatus) CanTransitionTo(next ShipmentStatus) bool {
+	for _, allowed := range shipmentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type TrackingEvent struct {
+	Status   ShipmentStatus
+	Location string
+	At       time.Time
+}
+
+type ShippingQuote struct {
+	Carrier       string
+	Cost          float64
+	EstimatedDays int
+}
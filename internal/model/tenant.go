@@ -0,0 +1,17 @@
+package model
+
+// Tenant is the configuration of one storefront. Amounts of the tenant's
+// orders and payments are in Currency, an ISO 4217 code.
+type Tenant struct {
+	ID             string
+	Name           string
+	Currency       string
+	DiscountPolicy DiscountPolicy
+}
+
+// DiscountPolicy limits the discounts a tenant grants. Cap bounds the total
+// discount of every order and DisablePromoCodes turns promo codes off.
+type DiscountPolicy struct {
+	Cap               DiscountCap
+	DisablePromoCodes bool
+}
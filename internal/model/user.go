@@ -1,5 +1,41 @@
 package model
 
+import "time"
+
 type User struct {
-	ID string
+	ID        string
+	TenantID  string
+	Profile   UserProfile
+	Status    UserStatus
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (u *User) Clone() *User {
+	clone := *u
+	clone.Profile.Addresses = append([]Address(nil), u.Profile.Addresses...)
+	return &clone
+}
+
+type UserProfile struct {
+	Name      string
+	Email     string
+	Segment   string
+	Addresses []Address
 }
+
+type Address struct {
+	Line1      string
+	Line2      string
+	City       string
+	PostalCode string
+	Country    string
+}
+
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+)
@@ -0,0 +1,190 @@
+package saga
+
+import (
+	"fmt"
+	"time"
+
+	"homework/internal/model"
+)
+
+// ExecuteCancelOrderSaga cancels a confirmed order that has not shipped yet
+// and has no return other than rejected ones:
+// the shipment is called off, inventory goes back on the shelf, the discount is released, the payment is
+// refunded, loyalty points earned or redeemed are reversed and the order is moved to cancelled. If a step fails, the steps
+// already done are undone so the order stays confirmed and consistent.
+func (o *SagaOrchestrator) ExecuteCancelOrderSaga(sagaID, orderID, reason string) *SagaResult {
+	now := time.Now()
+
+	execution := &SagaExecution{
+		ID:            sagaID,
+		TenantID:      o.tenantID,
+		Type:          SagaTypeCancelOrder,
+		OrderID:       orderID,
+		Status:        SagaStatusInProgress,
+		Steps:         make([]SagaStep, 0),
+		Compensations: make([]CompensationAction, 0),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := o.register(execution); err != nil {
+		return &SagaResult{Error: err, Execution: execution}
+	}
+
+	err := o.executeCancelOrderSaga(execution, reason)
+
+	return &SagaResult{
+		Success:   err == nil && execution.Status == SagaStatusCompleted,
+		Error:     err,
+		Execution: execution,
+	}
+}
+
+func (o *SagaOrchestrator) executeCancelOrderSaga(execution *SagaExecution, reason string) error {
+	defer o.finishSaga(execution)
+
+	var order *model.Order
+	err := o.runStep(execution, "validate_cancellation", func() (interface{}, error) {
+		if err := o.lockOrder(execution, execution.OrderID); err != nil {
+			return nil, err
+		}
+
+		var err error
+		order, err = o.orderService.GetOrder(execution.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		if order.Status != model.OrderStatusConfirmed {
+			return nil, fmt.Errorf("order %s cannot be cancelled in status %s", order.ID, order.Status)
+		}
+		for _, request := range o.returnService.GetReturnsByOrder(order.ID) {
+			if request.Status != model.ReturnStatusRejected {
+				return nil, fmt.Errorf("order %s has a return and cannot be cancelled", order.ID)
+			}
+		}
+		execution.UserID = order.UserID
+		return order, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = o.runStep(execution, "cancel_shipment", func() (interface{}, error) {
+		return nil, o.shippingService.CancelShipmentsByOrderID(order.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "create_shipment",
+		Action: func() error {
+			for _, group := range o.shippingService.SplitByWarehouse(order.Items) {
+				if _, err := o.shippingService.CreateWarehouseShipment(order.ID, order.UserID, group.Warehouse, group.Items); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	err = o.runStep(execution, "release_inventory", func() (interface{}, error) {
+		return nil, o.inventoryService.ReleaseItems(order.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "reserve_inventory",
+		Action: func() error {
+			_, err := o.inventoryService.ReserveItems(order.ID, order.Items)
+			return err
+		},
+	})
+
+	discount, _ := o.discountService.GetDiscountByOrderID(order.ID)
+	if discount != nil {
+		err = o.runStep(execution, "restore_discount", func() (interface{}, error) {
+			return discount, o.discountService.RemoveDiscount(discount.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "reapply_discount",
+			Action: func() error {
+				return o.discountService.RestoreDiscount(discount)
+			},
+		})
+	}
+
+	var payment *model.Payment
+	err = o.runStep(execution, "refund_payment", func() (interface{}, error) {
+		var err error
+		payment, err = o.billingService.GetPaymentByOrderID(order.ID)
+		if err != nil {
+			return nil, err
+		}
+		return payment, o.billingService.RefundPaymentByOrderID(order.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "recharge_payment",
+		Action: func() error {
+			_, err := o.billingService.ProcessPayment(order.ID, payment.UserID, payment.Amount)
+			return err
+		},
+	})
+
+	accrual, _ := o.loyaltyService.GetAccrual(order.UserID, order.ID)
+	if accrual != nil {
+		err = o.runStep(execution, "reverse_accrual", func() (interface{}, error) {
+			return o.loyaltyService.ReverseAccrual(order.UserID, order.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "accrue_points",
+			Action: func() error {
+				_, err := o.loyaltyService.AccruePoints(order.UserID, order.ID, payment.Amount)
+				return err
+			},
+		})
+	}
+
+	redemption, _ := o.loyaltyService.GetRedemption(order.UserID, order.ID)
+	if redemption != nil {
+		err = o.runStep(execution, "restore_points", func() (interface{}, error) {
+			return o.loyaltyService.ReverseRedemption(order.UserID, order.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "redeem_points",
+			Action: func() error {
+				_, err := o.loyaltyService.RedeemPoints(order.UserID, order.ID, redemption.Points, redemption.Amount)
+				return err
+			},
+		})
+	}
+
+	err = o.runStep(execution, "cancel_order", func() (interface{}, error) {
+		return nil, o.orderService.Transition(order.ID, model.OrderStatusCancelled, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Status = SagaStatusCompleted
+	o.updateExecution(execution)
+	return nil
+}
@@ -0,0 +1,244 @@
+package saga
+
+import (
+	"testing"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func TestCancelOrderSaga_RefundsAndReleases(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	}
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", placed.Execution.OrderID, "customer changed mind")
+	if !result.Success {
+		t.Fatalf("Expected cancellation to succeed, got error: %v", result.Error)
+	}
+
+	if result.Execution.Type != SagaTypeCancelOrder {
+		t.Errorf("Expected saga type %s, got %s", SagaTypeCancelOrder, result.Execution.Type)
+	}
+
+	order, _ := orchestrator.GetOrder(placed.Execution.OrderID)
+	if order.Status != model.OrderStatusCancelled {
+		t.Errorf("Expected order status %s, got %s", model.OrderStatusCancelled, order.Status)
+	}
+
+	if balance := billingSvc.GetUserBalance("user1"); balance != 1000.0 {
+		t.Errorf("Expected balance 1000.00, got %.2f", balance)
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 10 {
+		t.Errorf("Expected stock 10, got %d", stock)
+	}
+
+	if _, err := discountSvc.GetDiscountByOrderID(order.ID); err == nil {
+		t.Error("Expected discount to be released")
+	}
+}
+
+func TestCancelOrderSaga_RejectsUnconfirmedOrder(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	orchestrator.ExecuteCancelOrderSaga("cancel-1", placed.Execution.OrderID, "first cancellation")
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-2", placed.Execution.OrderID, "second cancellation")
+	if result.Success {
+		t.Fatal("Expected cancelling an already cancelled order to fail")
+	}
+
+	if result.Execution.Status != SagaStatusFailed {
+		t.Errorf("Expected status %s, got %s", SagaStatusFailed, result.Execution.Status)
+	}
+}
+
+func TestCancelOrderSaga_RefundFailureCompensates(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	}
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	orderID := placed.Execution.OrderID
+
+	billingSvc.RefundPaymentByOrderID(orderID)
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", orderID, "customer changed mind")
+	if result.Success {
+		t.Fatal("Expected cancellation to fail when the refund fails")
+	}
+
+	if result.Execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected status %s, got %s", SagaStatusCompensated, result.Execution.Status)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Status != model.OrderStatusConfirmed {
+		t.Errorf("Expected order to stay %s, got %s", model.OrderStatusConfirmed, order.Status)
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 8 {
+		t.Errorf("Expected inventory to be reserved again, got stock %d", stock)
+	}
+
+	if _, err := discountSvc.GetDiscountByOrderID(orderID); err != nil {
+		t.Errorf("Expected discount to be restored, got: %v", err)
+	}
+}
+
+func TestCancelOrderSaga_ReversesLoyaltyPoints(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	loyaltySvc := service.NewLoyaltyService(service.DefaultLoyaltyProgram())
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithLoyaltyService(loyaltySvc))
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 200.0},
+	}
+
+	first := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", items)
+	second := orchestrator.ExecuteOrderSagaWithOptions("saga-2", "order-2", "user3", items, OrderSagaOptions{RedeemPoints: 100})
+	if !first.Success || !second.Success {
+		t.Fatalf("Expected orders to succeed, got errors: %v, %v", first.Error, second.Error)
+	}
+
+	before := loyaltySvc.Balance("user3")
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", second.Execution.OrderID, "customer changed mind")
+	if !result.Success {
+		t.Fatalf("Expected cancellation to succeed, got error: %v", result.Error)
+	}
+
+	accrual, _ := loyaltySvc.GetAccrual("user3", first.Execution.OrderID)
+	if balance := loyaltySvc.Balance("user3"); balance != accrual.Points {
+		t.Errorf("Expected only the first order's %d points to remain, had %d and got %d", accrual.Points, before, balance)
+	}
+
+	history := loyaltySvc.History("user3")
+	last := history[len(history)-1]
+	if last.Type != model.LoyaltyTransactionRedemptionReversal || last.Points != 100 {
+		t.Errorf("Expected the redemption to be reversed last, got %+v", last)
+	}
+}
+
+func TestCancelOrderSaga_RejectsOrderWithReturn(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	returnSvc := service.NewReturnService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithReturnService(returnSvc))
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+
+	request, _ := orchestrator.RequestReturn(orderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+	}, "too small")
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 1})
+	if result := orchestrator.ExecuteReturnSaga("return-1", request.ID); !result.Success {
+		t.Fatalf("Expected return to succeed, got error: %v", result.Error)
+	}
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", orderID, "customer changed mind")
+	if result.Success {
+		t.Fatal("Expected cancelling an order with a return to fail")
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 9 {
+		t.Errorf("Expected only the returned unit back in stock, got %d", stock)
+	}
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Status != model.OrderStatusConfirmed {
+		t.Errorf("Expected order to stay %s, got %s", model.OrderStatusConfirmed, order.Status)
+	}
+}
+
+func TestCancelOrderSaga_CancelsOrderPaidWithPoints(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	loyaltySvc := service.NewLoyaltyService(service.DefaultLoyaltyProgram())
+
+	billingSvc.SetUserBalance("user3", 2000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithLoyaltyService(loyaltySvc))
+
+	first := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 1000.0},
+	})
+	second := orchestrator.ExecuteOrderSagaWithOptions("saga-2", "order-2", "user3", []model.OrderItem{
+		{ProductID: "product2", Quantity: 1, Price: 2.0},
+	}, OrderSagaOptions{RedeemPoints: 1000})
+	if !first.Success || !second.Success {
+		t.Fatalf("Expected orders to succeed, got errors: %v, %v", first.Error, second.Error)
+	}
+	if payment, _ := billingSvc.GetPaymentByOrderID(second.Execution.OrderID); payment.Amount != 0 {
+		t.Fatalf("Expected the points to cover the order, got a payment of %.2f", payment.Amount)
+	}
+
+	balance := billingSvc.GetUserBalance("user3")
+	points := loyaltySvc.Balance("user3")
+	redemption, _ := loyaltySvc.GetRedemption("user3", second.Execution.OrderID)
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", second.Execution.OrderID, "customer changed mind")
+	if !result.Success {
+		t.Fatalf("Expected cancellation to succeed, got error: %v", result.Error)
+	}
+
+	if got := billingSvc.GetUserBalance("user3"); got != balance {
+		t.Errorf("Expected balance to stay %.2f, got %.2f", balance, got)
+	}
+	if got := loyaltySvc.Balance("user3"); got != points+redemption.Points {
+		t.Errorf("Expected the %d redeemed points back, got %d from %d", redemption.Points, got, points)
+	}
+	if stock := inventorySvc.GetStock("product2"); stock != 10 {
+		t.Errorf("Expected stock 10, got %d", stock)
+	}
+}
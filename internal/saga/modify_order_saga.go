@@ -0,0 +1,349 @@
+package saga
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+// ModifyOrder replaces the items of an order that has not shipped yet: a
+// pending or awaiting-payment order, or a confirmed order none of whose
+// shipments has been picked up. Orders being fulfilled or returned cannot be
+// modified. Only the difference is applied: added units are reserved,
+// removed units are released, the discount is repriced, the shipments are
+// requoted and the payment is charged or credited the change in the amount
+// due. A failed step before the payment undoes the deltas applied so far,
+// leaving the order as it was; once the payment is adjusted, the points
+// earned by the order are adjusted too.
+func (o *SagaOrchestrator) ModifyOrder(sagaID, orderID string, items []model.OrderItem) *SagaResult {
+	now := time.Now()
+
+	execution := &SagaExecution{
+		ID:            sagaID,
+		TenantID:      o.tenantID,
+		Type:          SagaTypeModifyOrder,
+		OrderID:       orderID,
+		Status:        SagaStatusInProgress,
+		Steps:         make([]SagaStep, 0),
+		Compensations: make([]CompensationAction, 0),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := o.register(execution); err != nil {
+		return &SagaResult{Error: err, Execution: execution}
+	}
+
+	err := o.executeModifyOrderSaga(execution, items)
+
+	return &SagaResult{
+		Success:   err == nil && execution.Status == SagaStatusCompleted,
+		Error:     err,
+		Execution: execution,
+	}
+}
+
+func (o *SagaOrchestrator) executeModifyOrderSaga(execution *SagaExecution, items []model.OrderItem) error {
+	defer o.finishSaga(execution)
+
+	var order *model.Order
+	var added, removed []model.OrderItem
+	err := o.runStep(execution, "validate_modification", func() (interface{}, error) {
+		if err := o.lockOrder(execution, execution.OrderID); err != nil {
+			return nil, err
+		}
+
+		var err error
+		order, err = o.orderService.GetOrder(execution.OrderID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch order.Status {
+		case model.OrderStatusPending, model.OrderStatusAwaitingPayment, model.OrderStatusConfirmed:
+		default:
+			return nil, fmt.Errorf("order %s cannot be modified in status %s", order.ID, order.Status)
+		}
+
+		for _, request := range o.returnService.GetReturnsByOrder(order.ID) {
+			if request.Status != model.ReturnStatusRejected {
+				return nil, fmt.Errorf("order %s has a return in progress and cannot be modified", order.ID)
+			}
+		}
+		for _, shipment := range o.shippingService.GetShipmentsByOrderID(order.ID) {
+			if shipment.Status != model.ShipmentStatusCreated && shipment.Status != model.ShipmentStatusCancelled {
+				return nil, fmt.Errorf("order %s is being fulfilled and cannot be modified", order.ID)
+			}
+		}
+
+		if err := service.ValidateOrderItems(items); err != nil {
+			return nil, err
+		}
+
+		execution.UserID = order.UserID
+		added, removed = itemDelta(order.Items, items)
+		return order, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(added) > 0 {
+		err = o.runStep(execution, "reserve_delta", func() (interface{}, error) {
+			return o.inventoryService.ReserveItems(order.ID, added)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "release_delta",
+			Action: func() error {
+				return o.inventoryService.ReleaseQuantities(order.ID, added)
+			},
+		})
+	}
+
+	if len(removed) > 0 {
+		err = o.runStep(execution, "release_delta", func() (interface{}, error) {
+			return removed, o.inventoryService.ReleaseQuantities(order.ID, removed)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "reserve_delta",
+			Action: func() error {
+				_, err := o.inventoryService.ReserveItems(order.ID, removed)
+				return err
+			},
+		})
+	}
+
+	var modified *model.Order
+	err = o.runStep(execution, "update_order", func() (interface{}, error) {
+		var err error
+		modified, err = o.orderService.UpdateOrder(order.ID, order.Version, func(current *model.Order) error {
+			current.Items = make([]model.OrderItem, len(items))
+			for i, item := range items {
+				item.Discount = 0
+				current.Items[i] = item
+			}
+			return nil
+		})
+		return modified, err
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "restore_order",
+		Action: func() error {
+			current, err := o.orderService.GetOrder(order.ID)
+			if err != nil {
+				return err
+			}
+			_, err = o.orderService.UpdateOrder(order.ID, current.Version, func(current *model.Order) error {
+				current.Items = order.Items
+				return nil
+			})
+			return err
+		},
+	})
+
+	// An order without a discount is evaluated afresh, as the new items may
+	// qualify for one.
+	discount, _ := o.discountService.GetDiscountByOrderID(order.ID)
+	repriced := discount
+	if discount == nil {
+		err = o.runStep(execution, "apply_discount", func() (interface{}, error) {
+			var err error
+			repriced, err = o.discountService.ApplyDiscount(order.ID, order.UserID, modified.Items)
+			if err != nil || repriced == nil {
+				return nil, err
+			}
+
+			if _, err := o.orderService.SetLineDiscounts(order.ID, repriced.Lines); err != nil {
+				if removeErr := o.discountService.RemoveDiscount(repriced.ID); removeErr != nil {
+					return nil, fmt.Errorf("%w; removing discount: %v", err, removeErr)
+				}
+				return nil, err
+			}
+			return repriced, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if repriced != nil {
+			applied := repriced
+			execution.Compensations = append(execution.Compensations, CompensationAction{
+				Name: "remove_discount",
+				Action: func() error {
+					return o.discountService.RemoveDiscount(applied.ID)
+				},
+			})
+		}
+	} else {
+		// The discount is restored exactly as it was, so that its promo code
+		// and campaign budget are charged as before.
+		restoreDiscount := func() error {
+			if err := o.discountService.RemoveDiscount(discount.ID); err != nil {
+				return err
+			}
+			return o.discountService.RestoreDiscount(discount)
+		}
+
+		err = o.runStep(execution, "recalculate_discount", func() (interface{}, error) {
+			var err error
+			repriced, err = o.discountService.RecalculateDiscount(order.ID, modified.Items)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := o.orderService.SetLineDiscounts(order.ID, repriced.Lines); err != nil {
+				if restoreErr := restoreDiscount(); restoreErr != nil {
+					return nil, fmt.Errorf("%w; restoring discount: %v", err, restoreErr)
+				}
+				return nil, err
+			}
+			return repriced, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name:   "restore_discount",
+			Action: restoreDiscount,
+		})
+	}
+
+	if hasActiveShipment(o.shippingService.GetShipmentsByOrderID(order.ID)) {
+		err = o.runStep(execution, "cancel_shipments", func() (interface{}, error) {
+			return nil, o.shippingService.CancelShipmentsByOrderID(order.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		execution.Compensations = append(execution.Compensations, CompensationAction{
+			Name: "restore_shipments",
+			Action: func() error {
+				for _, group := range o.shippingService.SplitByWarehouse(order.Items) {
+					if _, err := o.shippingService.CreateWarehouseShipment(order.ID, order.UserID, group.Warehouse, group.Items); err != nil {
+						return err
+					}
+				}
+				_, err := o.orderService.SetShippingCost(order.ID, order.ShippingCost)
+				return err
+			},
+		})
+
+		// The new items are requoted and shipped like a new order.
+		_, err = o.runSubSaga(execution, "requote_shipping", "cancel_shipment", execution.ID+"/split_shipment", SagaTypeSplitShipment, func(child *SagaExecution) error {
+			updated, err := o.splitShipment(child, modified)
+			if err != nil {
+				return err
+			}
+			modified = updated
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	due := modified.Total - order.Total
+	if discount != nil {
+		due += discount.Amount
+	}
+	if repriced != nil {
+		due -= repriced.Amount
+	}
+
+	payment, _ := o.billingService.GetPaymentByOrderID(order.ID)
+	if payment != nil && payment.Refundable() > 0 {
+		// Once the payment is adjusted the modification is kept, so the
+		// points are brought in line by retrying instead of rolling back.
+		paid := payment.Amount + due
+		err = o.runStepOfKind(execution, "adjust_payment", StepKindPivot, func() (interface{}, error) {
+			return o.billingService.AdjustPayment(order.ID, paid)
+		})
+		if err != nil {
+			return err
+		}
+
+		if accrual, _ := o.loyaltyService.GetAccrual(order.UserID, order.ID); accrual != nil {
+			reversed := false
+			err = o.runForward(execution, []StepDefinition{
+				{
+					Name: "adjust_accrual",
+					Action: func() (interface{}, error) {
+						if !reversed {
+							if _, err := o.loyaltyService.ReverseAccrual(order.UserID, order.ID); err != nil {
+								return nil, err
+							}
+							reversed = true
+						}
+						return o.loyaltyService.AccruePoints(order.UserID, order.ID, paid)
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	execution.Status = SagaStatusCompleted
+	o.updateExecution(execution)
+	return nil
+}
+
+func hasActiveShipment(shipments []*model.Shipment) bool {
+	for _, shipment := range shipments {
+		if shipment.Status != model.ShipmentStatusCancelled {
+			return true
+		}
+	}
+	return false
+}
+
+// itemDelta compares the per-product quantities of two item lists and
+// returns the units to reserve and to release to turn before into after.
+func itemDelta(before, after []model.OrderItem) (added, removed []model.OrderItem) {
+	quantities := make(map[string]int)
+	prices := make(map[string]float64)
+	for _, item := range before {
+		quantities[item.ProductID] -= item.Quantity
+		prices[item.ProductID] = item.Price
+	}
+	for _, item := range after {
+		quantities[item.ProductID] += item.Quantity
+		prices[item.ProductID] = item.Price
+	}
+
+	productIDs := make([]string, 0, len(quantities))
+	for productID := range quantities {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Strings(productIDs)
+
+	for _, productID := range productIDs {
+		delta := quantities[productID]
+		switch {
+		case delta > 0:
+			added = append(added, model.OrderItem{ProductID: productID, Quantity: delta, Price: prices[productID]})
+		case delta < 0:
+			removed = append(removed, model.OrderItem{ProductID: productID, Quantity: -delta, Price: prices[productID]})
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,387 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func TestModifyOrderSaga_AppliesDelta(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 5)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+		{ProductID: "product2", Quantity: 3, Price: 50.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected modification to succeed, got error: %v", result.Error)
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 9 {
+		t.Errorf("Expected product1 stock 9, got %d", stock)
+	}
+	if stock := inventorySvc.GetStock("product2"); stock != 2 {
+		t.Errorf("Expected product2 stock 2, got %d", stock)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Subtotal() != 250.0 || order.Total != 250.0+order.ShippingCost {
+		t.Errorf("Expected subtotal 250.00 plus shipping, got total %.2f", order.Total)
+	}
+
+	discount, _ := discountSvc.GetDiscountByOrderID(orderID)
+	if discount.Amount != 25.0 {
+		t.Errorf("Expected discount 25.00, got %.2f", discount.Amount)
+	}
+
+	expectedBalance := 1000.0 - (order.Total - discount.Amount)
+	if balance := billingSvc.GetUserBalance("user1"); math.Abs(balance-expectedBalance) > 0.001 {
+		t.Errorf("Expected balance %.2f, got %.2f", expectedBalance, balance)
+	}
+}
+
+func TestModifyOrderSaga_PaymentFailureCompensates(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	orderID := placed.Execution.OrderID
+	before, _ := orchestrator.GetOrder(orderID)
+	billingSvc.SetUserBalance("user1", 0)
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 5, Price: 100.0},
+	})
+	if result.Success {
+		t.Fatal("Expected modification to fail without funds")
+	}
+
+	if result.Execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected status %s, got %s", SagaStatusCompensated, result.Execution.Status)
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 8 {
+		t.Errorf("Expected stock 8, got %d", stock)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Total != before.Total || order.Items[0].Quantity != 2 {
+		t.Errorf("Expected order to be restored, got total %.2f and items %+v", order.Total, order.Items)
+	}
+
+	discount, _ := discountSvc.GetDiscountByOrderID(orderID)
+	if discount.Amount != 20.0 {
+		t.Errorf("Expected discount 20.00, got %.2f", discount.Amount)
+	}
+
+	if balance := billingSvc.GetUserBalance("user1"); balance != 0 {
+		t.Errorf("Expected balance 0.00, got %.2f", balance)
+	}
+}
+
+func TestModifyOrderSaga_RejectsShippedOrder(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	orchestrator.orderService.ShipOrder(placed.Execution.OrderID)
+
+	result := orchestrator.ModifyOrder("modify-1", placed.Execution.OrderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	if result.Success {
+		t.Error("Expected modifying a shipped order to fail")
+	}
+}
+
+func TestModifyOrderSaga_UnshippedStatuses(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 2, Price: 100.0}}
+
+	pending, _ := orchestrator.orderService.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	awaiting, _ := orchestrator.orderService.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if err := orchestrator.orderService.Transition(awaiting.ID, model.OrderStatusAwaitingPayment, "checkout"); err != nil {
+		t.Fatalf("Failed to move the order to awaiting payment: %v", err)
+	}
+
+	for i, orderID := range []string{pending.ID, awaiting.ID} {
+		result := orchestrator.ModifyOrder(fmt.Sprintf("modify-%d", i), orderID, items)
+		if !result.Success {
+			t.Errorf("Expected an unpaid order to be modified, got error: %v", result.Error)
+		}
+		if order, _ := orchestrator.GetOrder(orderID); order.Items[0].Quantity != 2 {
+			t.Errorf("Expected 2 units after the modification, got %d", order.Items[0].Quantity)
+		}
+	}
+}
+
+func TestModifyOrderSaga_RejectsOrderBeingFulfilled(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+
+	shipments := orchestrator.shippingService.GetShipmentsByOrderID(orderID)
+	if _, err := orchestrator.shippingService.UpdateTracking(shipments[0].ID, model.ShipmentStatusPickedUp, "main"); err != nil {
+		t.Fatalf("Failed to pick up the shipment: %v", err)
+	}
+	balance := orchestrator.billingService.GetUserBalance("user1")
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 3, Price: 100.0},
+	})
+	if result.Success {
+		t.Fatal("Expected modifying an order being fulfilled to fail")
+	}
+	if len(result.Execution.Steps) != 1 {
+		t.Errorf("Expected nothing to run after validation, got %d steps", len(result.Execution.Steps))
+	}
+	if got := orchestrator.billingService.GetUserBalance("user1"); got != balance {
+		t.Errorf("Expected balance %.2f to be left alone, got %.2f", balance, got)
+	}
+}
+
+func TestModifyOrderSaga_ReevaluatesDiscountRules(t *testing.T) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+	discountSvc.SetDiscountCap(model.DiscountCap{Amount: 60.0})
+	discountSvc.AddRule(model.DiscountRule{
+		ID:         "product2-bogo",
+		Priority:   10,
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{ProductIDs: []string{"product2"}},
+		Action:     model.RuleAction{Type: model.RuleActionBuyXGetY, BuyQuantity: 1, GetQuantity: 1},
+	})
+	discountSvc.AddRule(model.DiscountRule{
+		ID:       "ten-off",
+		Stacking: model.StackingCumulative,
+		Action:   model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 10.0},
+	})
+
+	orchestrator := NewSagaOrchestrator(service.NewOrderService(), billingSvc, inventorySvc, discountSvc)
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+		{ProductID: "product2", Quantity: 4, Price: 50.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected modification to succeed, got error: %v", result.Error)
+	}
+
+	discount, _ := discountSvc.GetDiscountByOrderID(orderID)
+	if discount.Amount != 60.0 {
+		t.Errorf("Expected BOGO and 10%% off capped at 60.00, got %.2f", discount.Amount)
+	}
+	if len(discount.AppliedRules) != 2 || discount.AppliedRules[0].RuleID != "product2-bogo" {
+		t.Errorf("Expected both rules to apply, got %+v", discount.AppliedRules)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.DiscountTotal() != 60.0 {
+		t.Errorf("Expected line discounts of 60.00, got %.2f", order.DiscountTotal())
+	}
+	expectedBalance := 1000.0 - (order.Total - discount.Amount)
+	if balance := billingSvc.GetUserBalance("user3"); math.Abs(balance-expectedBalance) > 0.001 {
+		t.Errorf("Expected balance %.2f, got %.2f", expectedBalance, balance)
+	}
+}
+
+func TestModifyOrderSaga_AppliesDiscountTheOrderNowQualifiesFor(t *testing.T) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.AddRule(model.DiscountRule{
+		ID:         "big-order",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{MinOrderTotal: 300.0},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 10.0},
+	})
+
+	orchestrator := NewSagaOrchestrator(service.NewOrderService(), billingSvc, inventorySvc, discountSvc)
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+	if _, err := discountSvc.GetDiscountByOrderID(orderID); err == nil {
+		t.Fatal("Expected no discount below the rule's minimum")
+	}
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 4, Price: 100.0}}
+
+	balance := billingSvc.GetUserBalance("user3")
+	billingSvc.SetUserBalance("user3", 0)
+	if result := orchestrator.ModifyOrder("modify-1", orderID, items); result.Success {
+		t.Fatal("Expected modification to fail without funds")
+	}
+	if _, err := discountSvc.GetDiscountByOrderID(orderID); err == nil {
+		t.Error("Expected the new discount to be removed when the modification is compensated")
+	}
+
+	billingSvc.SetUserBalance("user3", balance)
+	result := orchestrator.ModifyOrder("modify-2", orderID, items)
+	if !result.Success {
+		t.Fatalf("Expected modification to succeed, got error: %v", result.Error)
+	}
+
+	discount, err := discountSvc.GetDiscountByOrderID(orderID)
+	if err != nil || discount.Amount != 40.0 {
+		t.Fatalf("Expected 40.00 off the modified order, got %+v, %v", discount, err)
+	}
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.DiscountTotal() != 40.0 {
+		t.Errorf("Expected line discounts of 40.00, got %.2f", order.DiscountTotal())
+	}
+	expectedBalance := 1000.0 - (order.Total - discount.Amount)
+	if got := billingSvc.GetUserBalance("user3"); math.Abs(got-expectedBalance) > 0.001 {
+		t.Errorf("Expected balance %.2f, got %.2f", expectedBalance, got)
+	}
+}
+
+func TestModifyOrderSaga_RequotesShippingAndPoints(t *testing.T) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	shippingSvc := service.NewShippingService(service.DefaultRateTable())
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+	shippingSvc.SetProductWarehouse("product2", "east")
+
+	orchestrator := NewSagaOrchestrator(service.NewOrderService(), billingSvc, inventorySvc, service.NewDiscountService(), WithShippingService(shippingSvc))
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+		{ProductID: "product2", Quantity: 3, Price: 50.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected modification to succeed, got error: %v", result.Error)
+	}
+
+	// Two units from main and three from east, at 5.00 plus 1.00 a unit.
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.ShippingCost != 15.0 || order.Total != 365.0 {
+		t.Errorf("Expected shipping 15.00 for a total of 365.00, got %.2f and %.2f", order.ShippingCost, order.Total)
+	}
+
+	active := 0
+	for _, shipment := range shippingSvc.GetShipmentsByOrderID(orderID) {
+		if shipment.Status != model.ShipmentStatusCancelled {
+			active++
+		}
+	}
+	if active != 2 {
+		t.Errorf("Expected one shipment per warehouse, got %d", active)
+	}
+
+	if balance := billingSvc.GetUserBalance("user3"); balance != 1000.0-365.0 {
+		t.Errorf("Expected balance 635.00, got %.2f", balance)
+	}
+	if points := orchestrator.loyaltyService.Balance("user3"); points != 365 {
+		t.Errorf("Expected points for the new amount paid, got %d", points)
+	}
+}
+
+func TestModifyOrderSaga_PromoMinimumCompensates(t *testing.T) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+	shippingSvc := service.NewShippingService(service.DefaultRateTable())
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.CreatePromoCode(model.PromoCode{Code: "MINUS20", Type: model.PromoCodeFixed, Value: 20.0, MinOrderTotal: 150.0})
+
+	orchestrator := NewSagaOrchestrator(service.NewOrderService(), billingSvc, inventorySvc, discountSvc, WithShippingService(shippingSvc))
+	placed := orchestrator.ExecuteOrderSagaWithPromoCode("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	}, "MINUS20")
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+	orderID := placed.Execution.OrderID
+	before, _ := orchestrator.GetOrder(orderID)
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !errors.Is(result.Error, service.ErrInvalidPromoCode) {
+		t.Fatalf("Expected the promo minimum to fail the modification, got: %v", result.Error)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Total != before.Total || order.DiscountTotal() != 20.0 {
+		t.Errorf("Expected order to be restored, got total %.2f with discount %.2f", order.Total, order.DiscountTotal())
+	}
+	discount, _ := discountSvc.GetDiscountByOrderID(orderID)
+	if discount == nil || discount.Amount != 20.0 {
+		t.Errorf("Expected the promo discount to be kept, got %+v", discount)
+	}
+	if promo, _ := discountSvc.GetPromoCode("MINUS20"); promo.Redemptions != 1 {
+		t.Errorf("Expected one redemption, got %d", promo.Redemptions)
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 8 {
+		t.Errorf("Expected stock 8, got %d", stock)
+	}
+}
@@ -1,6 +1,7 @@
 package saga
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -10,40 +11,91 @@ import (
 )
 
 type SagaOrchestrator struct {
+	tenantID          string
 	orderService      *service.OrderService
 	billingService    *service.BillingService
 	inventoryService  *service.InventoryService
 	discountService   *service.DiscountService
+	returnService     *service.ReturnService
+	shippingService   *service.ShippingService
+	loyaltyService    *service.LoyaltyService
+	userService       *service.UserService
+	retryPolicy       RetryPolicy
+	lockPolicy        LockPolicy
+	lockTimeout       time.Duration
+	locks             *semanticLocks
+	breakpoints       map[string]bool
+	sagaControls      map[string]*sagaControl
+	stalled           map[string][]StepDefinition
 
 	mu     sync.RWMutex
 	sagas  map[string]*SagaExecution
 }
 
+// SagaExecution is one run of a saga. A sub-saga started by a step of
+// another saga records the parent in ParentID and is listed in the parent's
+// ChildIDs. PivotReached is set once the pivot step has completed; from then
+// on the saga is never compensated. Audit lists operator interventions.
 type SagaExecution struct {
 	ID            string
+	TenantID      string
+	Type          SagaType
+	ParentID      string
+	ChildIDs      []string
 	OrderID       string
 	UserID        string
 	Status        SagaStatus
 	Steps         []SagaStep
 	Compensations []CompensationAction
+	PivotReached  bool
+	Audit         []SagaAuditEntry
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
 
+type SagaType string
+
+const (
+	SagaTypeOrder         SagaType = "order"
+	SagaTypeCancelOrder   SagaType = "cancel_order"
+	SagaTypeReturn        SagaType = "return"
+	SagaTypeModifyOrder   SagaType = "modify_order"
+	SagaTypeSplitShipment SagaType = "split_shipment"
+)
+
+// ErrSagaExists is returned when a saga is started with the ID of a saga the
+// orchestrator already knows.
+var ErrSagaExists = errors.New("saga already exists")
+
 type SagaStatus string
 
+// A saga is stalled when a retriable step past the pivot kept failing: it
+// can neither roll back nor finish on its own until an operator retries it
+// or resolves it by hand. Paused and aborted sagas were stopped by an
+// operator or a breakpoint; an aborted saga is compensated.
 const (
-	SagaStatusInProgress SagaStatus = "in_progress"
-	SagaStatusCompleted  SagaStatus = "completed"
-	SagaStatusFailed     SagaStatus = "failed"
+	SagaStatusInProgress  SagaStatus = "in_progress"
+	SagaStatusCompleted   SagaStatus = "completed"
+	SagaStatusFailed      SagaStatus = "failed"
 	SagaStatusCompensated SagaStatus = "compensated"
+	SagaStatusStalled     SagaStatus = "stalled"
+	SagaStatusResolved    SagaStatus = "resolved"
+	SagaStatusPaused      SagaStatus = "paused"
+	SagaStatusAborted     SagaStatus = "aborted"
 )
 
+// SagaStep records one executed step. StartedAt and CompletedAt are the
+// actual times the step ran, which overlap for steps run concurrently.
+// Attempts counts the tries of a retriable step.
 type SagaStep struct {
-	Name      string
-	Status    StepStatus
-	Error     error
-	Result    interface{}
+	Name        string
+	Kind        StepKind
+	Status      StepStatus
+	Error       error
+	Result      interface{}
+	Attempts    int
+	StartedAt   time.Time
+	CompletedAt time.Time
 }
 
 type StepStatus string
@@ -52,6 +104,7 @@ const (
 	StepStatusPending   StepStatus = "pending"
 	StepStatusCompleted StepStatus = "completed"
 	StepStatusFailed    StepStatus = "failed"
+	StepStatusSkipped   StepStatus = "skipped"
 )
 
 type CompensationAction struct {
@@ -59,32 +112,123 @@ type CompensationAction struct {
 	Action func() error
 }
 
+// OrderSagaOptions are the optional parts of an order request. PromoCode
+// replaces the user's standing discount and RedeemPoints pays part of the
+// order with loyalty points.
+type OrderSagaOptions struct {
+	PromoCode    string
+	RedeemPoints int
+}
+
 type SagaResult struct {
 	Success bool
 	Error   error
 	Execution *SagaExecution
 }
 
+// OrchestratorOption supplies an optional participant service. Services that
+// are not supplied get a fresh in-memory instance.
+type OrchestratorOption func(*SagaOrchestrator)
+
+// WithTenant stamps every saga execution with tenantID. The participant
+// services must belong to the same tenant.
+func WithTenant(tenantID string) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.tenantID = tenantID
+	}
+}
+
+func WithReturnService(returnService *service.ReturnService) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.returnService = returnService
+	}
+}
+
+func WithShippingService(shippingService *service.ShippingService) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.shippingService = shippingService
+	}
+}
+
+func WithLoyaltyService(loyaltyService *service.LoyaltyService) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.loyaltyService = loyaltyService
+	}
+}
+
+// WithUserService makes order sagas check the customer first: orders from
+// unknown or suspended users are rejected before anything is reserved.
+// Discounts then also take the segment of the user's profile into account.
+// Without it any user ID is accepted.
+func WithUserService(userService *service.UserService) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.userService = userService
+	}
+}
+
 func NewSagaOrchestrator(
 	orderService *service.OrderService,
 	billingService *service.BillingService,
 	inventoryService *service.InventoryService,
 	discountService *service.DiscountService,
+	options ...OrchestratorOption,
 ) *SagaOrchestrator {
-	return &SagaOrchestrator{
+	orchestrator := &SagaOrchestrator{
 		orderService:     orderService,
 		billingService:   billingService,
 		inventoryService: inventoryService,
 		discountService:  discountService,
+		retryPolicy:      DefaultRetryPolicy(),
+		lockPolicy:       LockPolicyWait,
+		lockTimeout:      defaultLockTimeout,
+		locks:            newSemanticLocks(),
+		breakpoints:      make(map[string]bool),
+		sagaControls:     make(map[string]*sagaControl),
+		stalled:          make(map[string][]StepDefinition),
 		sagas:            make(map[string]*SagaExecution),
 	}
+
+	for _, option := range options {
+		option(orchestrator)
+	}
+
+	if orchestrator.userService != nil {
+		discountService.SetSegmentSource(orchestrator.userService)
+	}
+	if orchestrator.returnService == nil {
+		orchestrator.returnService = service.NewReturnServiceForTenant(orchestrator.tenantID)
+	}
+	if orchestrator.shippingService == nil {
+		orchestrator.shippingService = service.NewShippingServiceForTenant(orchestrator.tenantID, service.DefaultRateTable())
+	}
+	if orchestrator.loyaltyService == nil {
+		orchestrator.loyaltyService = service.NewLoyaltyServiceForTenant(orchestrator.tenantID, service.DefaultLoyaltyProgram())
+	}
+
+	return orchestrator
 }
 
 func (o *SagaOrchestrator) ExecuteOrderSaga(sagaID, orderID, userID string, items []model.OrderItem) *SagaResult {
+	return o.ExecuteOrderSagaWithPromoCode(sagaID, orderID, userID, items, "")
+}
+
+// ExecuteOrderSagaWithPromoCode places an order that redeems promoCode instead
+// of the user's standing discount. An invalid or exhausted code fails the
+// saga.
+func (o *SagaOrchestrator) ExecuteOrderSagaWithPromoCode(sagaID, orderID, userID string, items []model.OrderItem, promoCode string) *SagaResult {
+	return o.ExecuteOrderSagaWithOptions(sagaID, orderID, userID, items, OrderSagaOptions{PromoCode: promoCode})
+}
+
+// ExecuteOrderSagaWithOptions places an order with the optional parts of the
+// request applied. Redeemed points never exceed the amount due and fail the
+// saga when the user's balance cannot cover them.
+func (o *SagaOrchestrator) ExecuteOrderSagaWithOptions(sagaID, orderID, userID string, items []model.OrderItem, options OrderSagaOptions) *SagaResult {
 	now := time.Now()
 
 	execution := &SagaExecution{
 		ID:            sagaID,
+		TenantID:      o.tenantID,
+		Type:          SagaTypeOrder,
 		OrderID:       orderID,
 		UserID:        userID,
 		Status:        SagaStatusInProgress,
@@ -94,11 +238,11 @@ func (o *SagaOrchestrator) ExecuteOrderSaga(sagaID, orderID, userID string, item
 		UpdatedAt:     now,
 	}
 
-	o.mu.Lock()
-	o.sagas[sagaID] = execution
-	o.mu.Unlock()
+	if err := o.register(execution); err != nil {
+		return &SagaResult{Error: err, Execution: execution}
+	}
 
-	_, err := o.executeSaga(execution, userID, items, false)
+	_, err := o.executeSaga(execution, userID, items, options, false)
 
 	result := &SagaResult{
 		Success:   err == nil && execution.Status == SagaStatusCompleted,
@@ -110,27 +254,48 @@ func (o *SagaOrchestrator) ExecuteOrderSaga(sagaID, orderID, userID string, item
 }
 
 
-func (o *SagaOrchestrator) executeSaga(execution *SagaExecution, userID string, items []model.OrderItem, async bool) (*SagaExecution, error) {
-	step1 := SagaStep{Name: "create_order", Status: StepStatusPending}
+func (o *SagaOrchestrator) executeSaga(execution *SagaExecution, userID string, items []model.OrderItem, options OrderSagaOptions, async bool) (*SagaExecution, error) {
+	defer o.finishSaga(execution)
 	o.updateExecution(execution)
 	if async {
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	order, err := o.orderService.CreateOrder(userID, items)
+	if o.userService != nil {
+		err := o.runStep(execution, "validate_user", func() (interface{}, error) {
+			return o.userService.ValidateCustomer(userID)
+		})
+		if err != nil {
+			return execution, err
+		}
+	}
+
+	var order *model.Order
+	err := o.runStep(execution, "create_order", func() (interface{}, error) {
+		var err error
+		order, err = o.orderService.CreateOrder(userID, items)
+		if err != nil {
+			return nil, err
+		}
+		execution.OrderID = order.ID
+
+		if err := o.lockOrder(execution, order.ID); err != nil {
+			o.orderService.FailOrder(order.ID)
+			return nil, err
+		}
+
+		if options.PromoCode != "" {
+			order, err = o.orderService.SetPromoCode(order.ID, options.PromoCode)
+			if err != nil {
+				o.orderService.FailOrder(execution.OrderID)
+				return nil, err
+			}
+		}
+		return order, nil
+	})
 	if err != nil {
-		step1.Status = StepStatusFailed
-		step1.Error = err
-		execution.Steps = append(execution.Steps, step1)
-		execution.Status = SagaStatusFailed
-		o.updateExecution(execution)
 		return execution, err
 	}
-	step1.Status = StepStatusCompleted
-	step1.Result = order
-	execution.OrderID = order.ID
-	execution.Steps = append(execution.Steps, step1)
-	o.updateExecution(execution)
 
 	execution.Compensations = append(execution.Compensations, CompensationAction{
 		Name: "cancel_order",
@@ -139,122 +304,182 @@ func (o *SagaOrchestrator) executeSaga(execution *SagaExecution, userID string,
 		},
 	})
 
-	step2 := SagaStep{Name: "reserve_inventory", Status: StepStatusPending}
-	o.updateExecution(execution)
 	if async {
 		time.Sleep(150 * time.Millisecond)
 	}
 
-	reservations, err := o.inventoryService.ReserveItems(order.ID, items)
+	// Reserving stock and pricing the discount do not depend on each other,
+	// so they run concurrently.
+	var discount *model.Discount
+	err = o.runGraph(execution, []StepDefinition{
+		{
+			Name: "reserve_inventory",
+			Action: func() (interface{}, error) {
+				return o.inventoryService.ReserveItems(order.ID, items)
+			},
+			Compensation: func() *CompensationAction {
+				return &CompensationAction{
+					Name: "release_inventory",
+					Action: func() error {
+						return o.inventoryService.ReleaseItems(order.ID)
+					},
+				}
+			},
+		},
+		{
+			Name: "apply_discount",
+			Action: func() (interface{}, error) {
+				var err error
+				if order.PromoCode != "" {
+					discount, err = o.discountService.ApplyPromoCode(order.ID, userID, order.PromoCode, order.Items)
+				} else {
+					discount, err = o.discountService.ApplyDiscount(order.ID, userID, order.Items)
+				}
+				if err != nil || discount == nil {
+					return discount, err
+				}
+
+				if _, err := o.orderService.SetLineDiscounts(order.ID, discount.Lines); err != nil {
+					o.discountService.RemoveDiscount(discount.ID)
+					return nil, err
+				}
+				return discount, nil
+			},
+			Compensation: func() *CompensationAction {
+				if discount == nil {
+					return nil
+				}
+				return &CompensationAction{
+					Name: "remove_discount",
+					Action: func() error {
+						return o.discountService.RemoveDiscount(discount.ID)
+					},
+				}
+			},
+		},
+	})
 	if err != nil {
-		step2.Status = StepStatusFailed
-		step2.Error = err
-		execution.Steps = append(execution.Steps, step2)
-		execution.Status = SagaStatusFailed
-		o.updateExecution(execution)
-		o.compensate(execution)
 		return execution, err
 	}
-	step2.Status = StepStatusCompleted
-	step2.Result = reservations
-	execution.Steps = append(execution.Steps, step2)
-	o.updateExecution(execution)
 
-	execution.Compensations = append(execution.Compensations, CompensationAction{
-		Name: "release_inventory",
-		Action: func() error {
-			return o.inventoryService.ReleaseItems(order.ID)
-		},
-	})
-
-	step3 := SagaStep{Name: "apply_discount", Status: StepStatusPending}
-	o.updateExecution(execution)
 	if async {
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	discount, err := o.discountService.ApplyDiscount(order.ID, userID, order.Total)
+	// Shipping runs as a sub-saga: one shipment per warehouse stocking part
+	// of the order.
+	_, err = o.runSubSaga(execution, "create_shipment", "cancel_shipment", execution.ID+"/split_shipment", SagaTypeSplitShipment, func(child *SagaExecution) error {
+		updated, err := o.splitShipment(child, order)
+		if err != nil {
+			return err
+		}
+		order = updated
+		return nil
+	})
 	if err != nil {
-		step3.Status = StepStatusFailed
-		step3.Error = err
-		execution.Steps = append(execution.Steps, step3)
-		execution.Status = SagaStatusFailed
-		o.updateExecution(execution)
-		o.compensate(execution)
 		return execution, err
 	}
-	step3.Status = StepStatusCompleted
-	step3.Result = discount
-	execution.Steps = append(execution.Steps, step3)
-	o.updateExecution(execution)
 
 	finalAmount := order.Total
 	if discount != nil {
 		finalAmount -= discount.Amount
 	}
 
-	if discount != nil {
-		execution.Compensations = append(execution.Compensations, CompensationAction{
-			Name: "remove_discount",
-			Action: func() error {
-				return o.discountService.RemoveDiscount(discount.ID)
-			},
+	if options.RedeemPoints > 0 {
+		var redemption *model.LoyaltyTransaction
+		err = o.runStep(execution, "redeem_points", func() (interface{}, error) {
+			var err error
+			redemption, err = o.loyaltyService.RedeemPoints(userID, order.ID, options.RedeemPoints, finalAmount)
+			return redemption, err
 		})
+		if err != nil {
+			return execution, err
+		}
+
+		if redemption != nil {
+			finalAmount -= redemption.Amount
+			execution.Compensations = append(execution.Compensations, CompensationAction{
+				Name: "restore_points",
+				Action: func() error {
+					_, err := o.loyaltyService.ReverseRedemption(userID, order.ID)
+					return err
+				},
+			})
+		}
 	}
 
-	step4 := SagaStep{Name: "process_payment", Status: StepStatusPending}
-	o.updateExecution(execution)
 	if async {
 		time.Sleep(200 * time.Millisecond)
 	}
 
-	payment, err := o.billingService.ProcessPayment(order.ID, userID, finalAmount)
+	// Capturing the payment is the pivot: once the customer has paid, the
+	// order is completed by retrying the remaining steps, never rolled back.
+	err = o.runStepOfKind(execution, "process_payment", StepKindPivot, func() (interface{}, error) {
+		if err := o.orderService.MarkAwaitingPayment(order.ID); err != nil {
+			return nil, err
+		}
+		return o.billingService.ProcessPayment(order.ID, userID, finalAmount)
+	})
 	if err != nil {
-		step4.Status = StepStatusFailed
-		step4.Error = err
-		execution.Steps = append(execution.Steps, step4)
-		execution.Status = SagaStatusFailed
-		o.updateExecution(execution)
-		o.compensate(execution)
 		return execution, err
 	}
-	step4.Status = StepStatusCompleted
-	step4.Result = payment
-	execution.Steps = append(execution.Steps, step4)
-	o.updateExecution(execution)
-
-	execution.Compensations = append(execution.Compensations, CompensationAction{
-		Name: "refund_payment",
-		Action: func() error {
-			return o.billingService.RefundPaymentByOrderID(order.ID)
-		},
-	})
 
-	step5 := SagaStep{Name: "confirm_order", Status: StepStatusPending}
-	o.updateExecution(execution)
 	if async {
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	err = o.orderService.ConfirmOrder(order.ID)
+	// Retriable steps may run more than once, so both check whether an
+	// earlier attempt already took effect.
+	err = o.runForward(execution, []StepDefinition{
+		{
+			Name: "accrue_points",
+			Action: func() (interface{}, error) {
+				if accrual, err := o.loyaltyService.GetAccrual(userID, order.ID); err == nil {
+					return accrual, nil
+				}
+				return o.loyaltyService.AccruePoints(userID, order.ID, finalAmount)
+			},
+		},
+		{
+			Name: "confirm_order",
+			Action: func() (interface{}, error) {
+				if current, err := o.orderService.GetOrder(order.ID); err == nil && current.Status == model.OrderStatusConfirmed {
+					return nil, nil
+				}
+				return nil, o.orderService.ConfirmOrder(order.ID)
+			},
+		},
+	})
 	if err != nil {
-		step5.Status = StepStatusFailed
-		step5.Error = err
-		execution.Steps = append(execution.Steps, step5)
-		execution.Status = SagaStatusFailed
-		o.updateExecution(execution)
-		o.compensate(execution)
 		return execution, err
 	}
-	step5.Status = StepStatusCompleted
-	execution.Steps = append(execution.Steps, step5)
-	o.updateExecution(execution)
 
 	execution.Status = SagaStatusCompleted
 	o.updateExecution(execution)
 	return execution, nil
 }
 
+// runStep runs a compensatable step. On failure the saga is marked failed and
+// every compensation registered so far is run in reverse order.
+func (o *SagaOrchestrator) runStep(execution *SagaExecution, name string, action func() (interface{}, error)) error {
+	return o.runStepOfKind(execution, name, StepKindCompensatable, action)
+}
+
+// register records a new saga execution. Saga IDs are chosen by callers, so
+// an ID already in use is refused rather than replacing the record, and the
+// audit trail, of another saga.
+func (o *SagaOrchestrator) register(execution *SagaExecution) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, exists := o.sagas[execution.ID]; exists {
+		execution.Status = SagaStatusFailed
+		return fmt.Errorf("%w: %s", ErrSagaExists, execution.ID)
+	}
+	o.sagas[execution.ID] = execution
+	return nil
+}
+
 func (o *SagaOrchestrator) updateExecution(execution *SagaExecution) {
 	execution.UpdatedAt = time.Now()
 	o.mu.Lock()
@@ -285,8 +510,53 @@ func (o *SagaOrchestrator) GetSagaExecution(sagaID string) (*SagaExecution, erro
 	return execution, nil
 }
 
+// GetOrder reads the order through any semantic lock, flagging an order a
+// saga is still working on.
 func (o *SagaOrchestrator) GetOrder(orderID string) (*model.Order, error) {
-	return o.orderService.GetOrder(orderID)
+	order, err := o.orderService.GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if lock, locked := o.locks.owner(orderResource(orderID)); locked {
+		order.LockedBySaga = lock.owner.ID
+	}
+	return order, nil
+}
+
+func (o *SagaOrchestrator) ListOrders(query model.OrderQuery) (*model.OrderPage, error) {
+	return o.orderService.ListOrders(query)
+}
+
+// UpdateShipmentTracking records a carrier update and moves the order along:
+// the first shipment picked up ships the order, and the order is delivered
+// once every shipment that was not cancelled has been delivered.
+func (o *SagaOrchestrator) UpdateShipmentTracking(shipmentID string, status model.ShipmentStatus, location string) (*model.Shipment, error) {
+	shipment, err := o.shippingService.UpdateTracking(shipmentID, status, location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case model.ShipmentStatusPickedUp:
+		order, err := o.orderService.GetOrder(shipment.OrderID)
+		if err != nil {
+			return shipment, err
+		}
+		if order.Status == model.OrderStatusConfirmed {
+			err = o.orderService.ShipOrder(shipment.OrderID)
+		}
+		return shipment, err
+	case model.ShipmentStatusDelivered:
+		for _, other := range o.shippingService.GetShipmentsByOrderID(shipment.OrderID) {
+			if other.Status != model.ShipmentStatusDelivered && other.Status != model.ShipmentStatusCancelled {
+				return shipment, nil
+			}
+		}
+		err = o.orderService.DeliverOrder(shipment.OrderID)
+	}
+
+	return shipment, err
 }
 
 func (o *SagaOrchestrator) GetAllSagas() []*SagaExecution {
@@ -314,7 +584,10 @@ func (o *SagaOrchestrator) WaitForSagaCompletion(sagaID string, timeout time.Dur
 
 		if execution.Status == SagaStatusCompleted ||
 			execution.Status == SagaStatusFailed ||
-			execution.Status == SagaStatusCompensated {
+			execution.Status == SagaStatusCompensated ||
+			execution.Status == SagaStatusStalled ||
+			execution.Status == SagaStatusResolved ||
+			execution.Status == SagaStatusAborted {
 			return execution, nil
 		}
 
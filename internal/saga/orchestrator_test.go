@@ -1,7 +1,9 @@
 package saga
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"homework/internal/model"
 	"homework/internal/service"
@@ -27,7 +29,7 @@ func TestSagaOrchestrator_SuccessfulOrder(t *testing.T) {
 		t.Error("Expected OrderID to be set")
 	}
 
-	expectedSteps := 5
+	expectedSteps := 7
 	if len(result.Execution.Steps) != expectedSteps {
 		t.Errorf("Expected %d steps, got %d", expectedSteps, len(result.Execution.Steps))
 	}
@@ -238,6 +240,370 @@ func TestSagaOrchestrator_CompensationOrder(t *testing.T) {
 		t.Error("Expected 'remove_discount' compensation for user with discount")
 	}
 
+	if !compensationNames["cancel_shipment"] {
+		t.Error("Expected 'cancel_shipment' compensation")
+	}
+
+}
+
+func TestSagaOrchestrator_OrderStatusHistory(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	result := orchestrator.ExecuteOrderSaga("saga-7", "order-7", "user1", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	order, _ := orchestrator.GetOrder(result.Execution.OrderID)
+	expected := []model.OrderStatus{
+		model.OrderStatusPending,
+		model.OrderStatusAwaitingPayment,
+		model.OrderStatusConfirmed,
+	}
+	if len(order.StatusHistory) != len(expected) {
+		t.Fatalf("Expected %d status changes, got %d", len(expected), len(order.StatusHistory))
+	}
+	for i, status := range expected {
+		if order.StatusHistory[i].To != status {
+			t.Errorf("Expected status change %d to be %s, got %s", i, status, order.StatusHistory[i].To)
+		}
+	}
+}
+
+func TestSagaOrchestrator_ShippingCostAndTracking(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	shippingSvc := service.NewShippingService(service.FlatRateTable{
+		"courier": {Base: 10.0, PerUnit: 0.0, EstimatedDays: 3},
+	})
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithShippingService(shippingSvc))
+
+	result := orchestrator.ExecuteOrderSaga("saga-8", "order-8", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	order, _ := orchestrator.GetOrder(result.Execution.OrderID)
+	if order.ShippingCost != 10.0 || order.Total != 110.0 {
+		t.Errorf("Expected shipping 10.00 and total 110.00, got %.2f and %.2f", order.ShippingCost, order.Total)
+	}
+
+	if balance := billingSvc.GetUserBalance("user3"); balance != 890.0 {
+		t.Errorf("Expected balance 890.00, got %.2f", balance)
+	}
+
+	shipments := shippingSvc.GetShipmentsByOrderID(order.ID)
+	if len(shipments) != 1 {
+		t.Fatalf("Expected 1 shipment, got %d", len(shipments))
+	}
+
+	orchestrator.UpdateShipmentTracking(shipments[0].ID, model.ShipmentStatusPickedUp, "Warehouse")
+	order, _ = orchestrator.GetOrder(order.ID)
+	if order.Status != model.OrderStatusShipped {
+		t.Errorf("Expected order status %s, got %s", model.OrderStatusShipped, order.Status)
+	}
+
+	cancelled := orchestrator.ExecuteCancelOrderSaga("cancel-8", order.ID, "too late")
+	if cancelled.Success {
+		t.Error("Expected cancellation of a shipped order to fail")
+	}
+}
+
+func TestSagaOrchestrator_TypedErrors(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+
+	result := orchestrator.ExecuteOrderSaga("saga-9", "order-9", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1000, Price: 100.0},
+	})
+	var stockErr *service.InsufficientStockError
+	if !errors.As(result.Error, &stockErr) {
+		t.Fatalf("Expected InsufficientStockError, got: %v", result.Error)
+	}
+	if stockErr.ProductID != "product1" || stockErr.Requested != 1000 || stockErr.Available != 100 {
+		t.Errorf("Unexpected error details: %+v", stockErr)
+	}
+
+	result = orchestrator.ExecuteOrderSaga("saga-10", "order-10", "user2", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !errors.Is(result.Error, service.ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds, got: %v", result.Error)
+	}
+
+	result = orchestrator.ExecuteOrderSaga("saga-11", "order-11", "user1", []model.OrderItem{
+		{ProductID: "unknown", Quantity: 1, Price: 100.0},
+	})
+	if !errors.Is(result.Error, service.ErrProductNotFound) {
+		t.Errorf("Expected ErrProductNotFound, got: %v", result.Error)
+	}
+
+	result = orchestrator.ExecuteOrderSaga("saga-12", "order-12", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 0, Price: 100.0},
+	})
+	if !errors.Is(result.Error, service.ErrInvalidOrder) {
+		t.Errorf("Expected ErrInvalidOrder, got: %v", result.Error)
+	}
+	if result.Execution.Status != SagaStatusFailed {
+		t.Errorf("Expected status %s, got %s", SagaStatusFailed, result.Execution.Status)
+	}
+}
+
+func TestSagaOrchestrator_PromoCode(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+	shippingSvc := service.NewShippingService(service.FlatRateTable{
+		"courier": {Base: 0.0, PerUnit: 0.0, EstimatedDays: 3},
+	})
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.CreatePromoCode(model.PromoCode{Code: "MINUS30", Type: model.PromoCodeFixed, Value: 30.0, MaxRedemptions: 1})
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc, WithShippingService(shippingSvc))
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	billingSvc.SetShouldFail(true)
+	result := orchestrator.ExecuteOrderSagaWithPromoCode("saga-13", "order-13", "user1", items, "MINUS30")
+	if result.Success {
+		t.Fatal("Expected payment failure")
+	}
+
+	promo, _ := discountSvc.GetPromoCode("MINUS30")
+	if promo.Redemptions != 0 {
+		t.Errorf("Expected compensation to return the redemption, got %d", promo.Redemptions)
+	}
+
+	billingSvc.SetShouldFail(false)
+	result = orchestrator.ExecuteOrderSagaWithPromoCode("saga-14", "order-14", "user1", items, "MINUS30")
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	if balance := billingSvc.GetUserBalance("user1"); balance != 930.0 {
+		t.Errorf("Expected balance 930.00, got %.2f", balance)
+	}
+
+	result = orchestrator.ExecuteOrderSagaWithPromoCode("saga-15", "order-15", "user1", items, "MINUS30")
+	if !errors.Is(result.Error, service.ErrInvalidPromoCode) {
+		t.Errorf("Expected ErrInvalidPromoCode for exhausted code, got: %v", result.Error)
+	}
+}
+
+func TestSagaOrchestrator_CampaignBudgetCompensation(t *testing.T) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	discountSvc.CreateCampaign(model.Campaign{
+		ID:         "spring",
+		StartsAt:   time.Now().Add(-time.Hour),
+		EndsAt:     time.Now().Add(time.Hour),
+		Percentage: 10.0,
+		Budget:     500.0,
+	})
+
+	orchestrator := NewSagaOrchestrator(service.NewOrderService(), billingSvc, inventorySvc, discountSvc)
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	billingSvc.SetShouldFail(true)
+	orchestrator.ExecuteOrderSaga("saga-16", "order-16", "user3", items)
+
+	report, _ := discountSvc.CampaignReport("spring")
+	if report.Spent != 0 || report.Redemptions != 0 {
+		t.Errorf("Expected compensation to return the campaign budget, got %+v", report)
+	}
+
+	billingSvc.SetShouldFail(false)
+	result := orchestrator.ExecuteOrderSaga("saga-17", "order-17", "user3", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	report, _ = discountSvc.CampaignReport("spring")
+	if report.Spent != 10.0 || report.RemainingBudget != 490.0 {
+		t.Errorf("Expected 10.00 spent from the campaign, got %+v", report)
+	}
+}
+
+func TestSagaOrchestrator_LoyaltyPoints(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	loyaltySvc := service.NewLoyaltyService(service.DefaultLoyaltyProgram())
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithShippingService(service.NewShippingService(service.FlatRateTable{
+			"courier": {Base: 0.0, PerUnit: 0.0, EstimatedDays: 3},
+		})),
+		WithLoyaltyService(loyaltySvc))
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	result := orchestrator.ExecuteOrderSaga("saga-17", "order-17", "user3", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+	if balance := loyaltySvc.Balance("user3"); balance != 100 {
+		t.Fatalf("Expected 100 points accrued, got %d", balance)
+	}
+
+	billingSvc.SetShouldFail(true)
+	result = orchestrator.ExecuteOrderSagaWithOptions("saga-18", "order-18", "user3", items, OrderSagaOptions{RedeemPoints: 60})
+	if result.Success {
+		t.Fatal("Expected payment failure")
+	}
+	if balance := loyaltySvc.Balance("user3"); balance != 100 {
+		t.Errorf("Expected compensation to restore redeemed points, got %d", balance)
+	}
+
+	billingSvc.SetShouldFail(false)
+	result = orchestrator.ExecuteOrderSagaWithOptions("saga-19", "order-19", "user3", items, OrderSagaOptions{RedeemPoints: 60})
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	// 100.00 paid, then 99.40 after redeeming 60 points worth 0.60, which
+	// earns 99 new points.
+	if balance := billingSvc.GetUserBalance("user3"); balance != 800.6 {
+		t.Errorf("Expected balance 800.60, got %.2f", balance)
+	}
+	if balance := loyaltySvc.Balance("user3"); balance != 139 {
+		t.Errorf("Expected 139 points, got %d", balance)
+	}
+
+	result = orchestrator.ExecuteOrderSagaWithOptions("saga-20", "order-20", "user3", items, OrderSagaOptions{RedeemPoints: 500})
+	if !errors.Is(result.Error, service.ErrInsufficientPoints) {
+		t.Errorf("Expected ErrInsufficientPoints, got: %v", result.Error)
+	}
+}
+
+func TestSagaOrchestrator_StoresLineDiscounts(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 33.33},
+		{ProductID: "product2", Quantity: 2, Price: 16.67},
+	}
+
+	result := orchestrator.ExecuteOrderSaga("saga-16", "order-16", "user1", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	discount, err := orchestrator.discountService.GetDiscountByOrderID(result.Execution.OrderID)
+	if err != nil {
+		t.Fatalf("Expected discount, got error: %v", err)
+	}
+
+	order, _ := orchestrator.orderService.GetOrder(result.Execution.OrderID)
+	for i, line := range discount.Lines {
+		if order.Items[line.Line].Discount != line.Amount {
+			t.Errorf("Expected line %d discount %.2f, got %.2f", i, line.Amount, order.Items[line.Line].Discount)
+		}
+	}
+	if order.DiscountTotal() != discount.Amount {
+		t.Errorf("Expected line discounts to add up to %.2f, got %.2f", discount.Amount, order.DiscountTotal())
+	}
+}
+
+func TestSagaOrchestrator_RejectsUnknownAndSuspendedUsers(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	userSvc := service.NewUserService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	userSvc.RegisterUser("user1", model.UserProfile{Name: "Dasha", Email: "dasha@example.com"})
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, service.NewDiscountService(),
+		WithUserService(userSvc))
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	}
+
+	result := orchestrator.ExecuteOrderSaga("saga-21", "order-21", "user1", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+	if result.Execution.Steps[0].Name != "validate_user" {
+		t.Errorf("Expected validate_user to run first, got %s", result.Execution.Steps[0].Name)
+	}
+
+	result = orchestrator.ExecuteOrderSaga("saga-22", "order-22", "ghost", items)
+	if !errors.Is(result.Error, service.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got: %v", result.Error)
+	}
+
+	userSvc.SuspendUser("user1")
+	result = orchestrator.ExecuteOrderSaga("saga-23", "order-23", "user1", items)
+	if !errors.Is(result.Error, service.ErrUserSuspended) {
+		t.Errorf("Expected ErrUserSuspended, got: %v", result.Error)
+	}
+
+	if len(result.Execution.Steps) != 1 {
+		t.Errorf("Expected nothing to run after the rejected precondition, got %d steps", len(result.Execution.Steps))
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 9 {
+		t.Errorf("Expected only the first order to reserve stock, got %d", stock)
+	}
+}
+
+func TestSagaOrchestrator_DiscountsUseProfileSegments(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+	userSvc := service.NewUserService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	userSvc.RegisterUser("user1", model.UserProfile{Name: "Dasha", Email: "dasha@example.com", Segment: "vip"})
+	discountSvc.AddRule(model.DiscountRule{
+		ID:         "vip",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{Segments: []string{"vip"}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 20.0},
+	})
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc,
+		WithUserService(userSvc))
+	result := orchestrator.ExecuteOrderSaga("saga-24", "order-24", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	discount, err := discountSvc.GetDiscountByOrderID(result.Execution.OrderID)
+	if err != nil {
+		t.Fatalf("Expected the vip discount to be applied, got: %v", err)
+	}
+	if discount.Amount != 20.0 {
+		t.Errorf("Expected 20.00 off for the profile's segment, got %.2f", discount.Amount)
+	}
 }
 
 func createTestOrchestrator() *SagaOrchestrator {
@@ -0,0 +1,209 @@
+package saga
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"homework/internal/model"
+)
+
+func (o *SagaOrchestrator) RequestReturn(orderID string, items []model.ReturnItem, reason string) (*model.ReturnRequest, error) {
+	order, err := o.orderService.GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.returnService.RequestReturn(order, items, reason)
+}
+
+// ExecuteReturnSaga settles an inspected return: the returned lines are
+// refunded net of their share of the order discount, sellable units go back
+// into stock and the return is completed. An order whose items have all been
+// returned is marked refunded.
+func (o *SagaOrchestrator) ExecuteReturnSaga(sagaID, returnID string) *SagaResult {
+	now := time.Now()
+
+	execution := &SagaExecution{
+		ID:            sagaID,
+		TenantID:      o.tenantID,
+		Type:          SagaTypeReturn,
+		Status:        SagaStatusInProgress,
+		Steps:         make([]SagaStep, 0),
+		Compensations: make([]CompensationAction, 0),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := o.register(execution); err != nil {
+		return &SagaResult{Error: err, Execution: execution}
+	}
+
+	err := o.executeReturnSaga(execution, returnID)
+
+	return &SagaResult{
+		Success:   err == nil && execution.Status == SagaStatusCompleted,
+		Error:     err,
+		Execution: execution,
+	}
+}
+
+func (o *SagaOrchestrator) executeReturnSaga(execution *SagaExecution, returnID string) error {
+	defer o.finishSaga(execution)
+
+	var request *model.ReturnRequest
+	var order *model.Order
+	err := o.runStep(execution, "validate_return", func() (interface{}, error) {
+		requested, err := o.returnService.GetReturn(returnID)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.lockOrder(execution, requested.OrderID); err != nil {
+			return nil, err
+		}
+
+		// Another saga may have settled the return while this one waited
+		// for the lock, so the status is checked under the lock.
+		request, err = o.returnService.GetReturn(returnID)
+		if err != nil {
+			return nil, err
+		}
+		if request.Status != model.ReturnStatusInspected {
+			return nil, fmt.Errorf("return %s cannot be settled in status %s", request.ID, request.Status)
+		}
+
+		order, err = o.orderService.GetOrder(request.OrderID)
+		if err != nil {
+			return nil, err
+		}
+
+		execution.OrderID = order.ID
+		execution.UserID = order.UserID
+		return request, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	discount, _ := o.discountService.GetDiscountByOrderID(order.ID)
+	refundAmount := returnRefundAmount(order, discount, request.Items)
+
+	err = o.runStep(execution, "refund_payment", func() (interface{}, error) {
+		return o.billingService.RefundPartial(order.ID, refundAmount)
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "reverse_refund",
+		Action: func() error {
+			return o.billingService.ReverseRefund(order.ID, refundAmount)
+		},
+	})
+
+	// restocked tracks the units put back per product, so that a restock
+	// failing halfway and a rolled back return take back only those.
+	restocked := make(map[string]int)
+	unrestock := func() error {
+		for productID, quantity := range restocked {
+			if err := o.inventoryService.ReverseRestock(productID, quantity, "reverse customer return "+request.ID, "returns"); err != nil {
+				return err
+			}
+			delete(restocked, productID)
+		}
+		return nil
+	}
+
+	err = o.runStep(execution, "restock_items", func() (interface{}, error) {
+		for _, item := range request.Items {
+			if item.Sellable == 0 {
+				continue
+			}
+			if err := o.inventoryService.Restock(item.ProductID, item.Sellable, "customer return "+request.ID, "returns"); err != nil {
+				if undoErr := unrestock(); undoErr != nil {
+					return nil, fmt.Errorf("%w; undoing restock: %v", err, undoErr)
+				}
+				return nil, err
+			}
+			restocked[item.ProductID] += item.Sellable
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name:   "reverse_restock",
+		Action: unrestock,
+	})
+
+	err = o.runStep(execution, "complete_return", func() (interface{}, error) {
+		return nil, o.returnService.CompleteReturn(request.ID, refundAmount)
+	})
+	if err != nil {
+		return err
+	}
+
+	if fullyReturned(order, o.returnService.ReturnedQuantities(order.ID)) {
+		err = o.runStep(execution, "refund_order", func() (interface{}, error) {
+			return nil, o.orderService.RefundOrder(order.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	execution.Status = SagaStatusCompleted
+	o.updateExecution(execution)
+	return nil
+}
+
+// returnRefundAmount prices the returned lines at the order price and takes
+// off their share of the order discount: the per-unit discount allocated to
+// the product's lines, or for orders without line allocations the same share
+// as the lines have in the subtotal.
+func returnRefundAmount(order *model.Order, discount *model.Discount, items []model.ReturnItem) float64 {
+	prices := make(map[string]float64)
+	ordered := make(map[string]int)
+	allocated := make(map[string]float64)
+	for _, item := range order.Items {
+		if _, exists := prices[item.ProductID]; !exists {
+			prices[item.ProductID] = item.Price
+		}
+		ordered[item.ProductID] += item.Quantity
+		allocated[item.ProductID] += item.Discount
+	}
+
+	amount := 0.0
+	for _, item := range items {
+		amount += prices[item.ProductID] * float64(item.Quantity)
+	}
+
+	if order.DiscountTotal() > 0 {
+		for _, item := range items {
+			if ordered[item.ProductID] > 0 {
+				amount -= allocated[item.ProductID] * float64(item.Quantity) / float64(ordered[item.ProductID])
+			}
+		}
+	} else if subtotal := order.Subtotal(); discount != nil && subtotal > 0 {
+		amount -= amount * discount.Amount / subtotal
+	}
+
+	return math.Round(amount*100) / 100
+}
+
+func fullyReturned(order *model.Order, returned map[string]int) bool {
+	ordered := make(map[string]int)
+	for _, item := range order.Items {
+		ordered[item.ProductID] += item.Quantity
+	}
+
+	for productID, quantity := range ordered {
+		if returned[productID] < quantity {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,277 @@
+package saga
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func TestReturnSaga_PartialRefundAndRestock(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+	returnSvc := service.NewReturnService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc, WithReturnService(returnSvc))
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+		{ProductID: "product2", Quantity: 1, Price: 200.0},
+	}
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+
+	request, err := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 2},
+	}, "too small")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 1})
+
+	result := orchestrator.ExecuteReturnSaga("return-1", request.ID)
+	if !result.Success {
+		t.Fatalf("Expected return to succeed, got error: %v", result.Error)
+	}
+
+	if balance := billingSvc.GetUserBalance("user1"); balance != 1000.0-368.0+180.0 {
+		t.Errorf("Expected balance 812.00, got %.2f", balance)
+	}
+
+	if stock := inventorySvc.GetStock("product1"); stock != 9 {
+		t.Errorf("Expected stock 9, got %d", stock)
+	}
+
+	completed, _ := returnSvc.GetReturn(request.ID)
+	if completed.Status != model.ReturnStatusCompleted || completed.RefundAmount != 180.0 {
+		t.Errorf("Expected completed return refunding 180.00, got %s refunding %.2f", completed.Status, completed.RefundAmount)
+	}
+
+	order, _ := orchestrator.GetOrder(placed.Execution.OrderID)
+	if order.Status != model.OrderStatusConfirmed {
+		t.Errorf("Expected partially returned order to stay %s, got %s", model.OrderStatusConfirmed, order.Status)
+	}
+}
+
+func TestReturnSaga_FullReturnRefundsOrder(t *testing.T) {
+	returnSvc := service.NewReturnService()
+	orchestrator := createTestOrchestrator()
+	orchestrator.returnService = returnSvc
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+
+	request, _ := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+	}, "not needed")
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 1})
+
+	result := orchestrator.ExecuteReturnSaga("return-1", request.ID)
+	if !result.Success {
+		t.Fatalf("Expected return to succeed, got error: %v", result.Error)
+	}
+
+	order, _ := orchestrator.GetOrder(placed.Execution.OrderID)
+	if order.Status != model.OrderStatusRefunded {
+		t.Errorf("Expected order status %s, got %s", model.OrderStatusRefunded, order.Status)
+	}
+}
+
+func TestReturnSaga_RejectsUninspectedReturn(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	request, _ := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+	}, "not needed")
+
+	result := orchestrator.ExecuteReturnSaga("return-1", request.ID)
+	if result.Success {
+		t.Error("Expected return saga to fail before inspection")
+	}
+}
+
+func TestReturnSaga_ConcurrentSettlementRunsOnce(t *testing.T) {
+	returnSvc := service.NewReturnService()
+	orchestrator := createTestOrchestrator()
+	orchestrator.returnService = returnSvc
+
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	request, _ := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+	}, "not needed")
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 1})
+
+	balance := orchestrator.billingService.GetUserBalance("user1")
+	stock := orchestrator.inventoryService.GetStock("product1")
+
+	// All sagas queue up behind a saga holding the order, so they validate
+	// the return while it is still inspected.
+	other := &SagaExecution{ID: "other-saga"}
+	orchestrator.locks.acquire(orderResource(request.OrderID), other, LockPolicyFailFast, 0)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		orchestrator.locks.releaseAll(other)
+	}()
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	results := make(chan *SagaResult, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- orchestrator.ExecuteReturnSaga(fmt.Sprintf("return-%d", i), request.ID)
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("Expected the return to be settled once, settled %d times", succeeded)
+	}
+
+	if refunded := orchestrator.billingService.GetUserBalance("user1") - balance; refunded != 90.0 {
+		t.Errorf("Expected a single refund of 90.00, got %.2f", refunded)
+	}
+	if restocked := orchestrator.inventoryService.GetStock("product1") - stock; restocked != 1 {
+		t.Errorf("Expected a single unit restocked, got %d", restocked)
+	}
+}
+
+func TestReturnSaga_RefundUsesRuleAllocations(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+	returnSvc := service.NewReturnService()
+
+	billingSvc.SetUserBalance("user3", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+	discountSvc.AddRule(model.DiscountRule{
+		ID:         "product2-half-price",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{ProductIDs: []string{"product2"}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 50.0},
+	})
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc, WithReturnService(returnSvc))
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+		{ProductID: "product2", Quantity: 2, Price: 100.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+
+	request, _ := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+		{ProductID: "product2", Quantity: 1},
+	}, "wrong size")
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 1, "product2": 1})
+
+	result := orchestrator.ExecuteReturnSaga("return-1", request.ID)
+	if !result.Success {
+		t.Fatalf("Expected return to succeed, got error: %v", result.Error)
+	}
+
+	completed, _ := returnSvc.GetReturn(request.ID)
+	if completed.RefundAmount != 150.0 {
+		t.Errorf("Expected full price for product1 and half price for product2, got %.2f", completed.RefundAmount)
+	}
+}
+
+func TestReturnSaga_AbortReversesRestock(t *testing.T) {
+	returnSvc := service.NewReturnService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+	inventorySvc.SetStock("product2", 10)
+
+	orchestrator := NewSagaOrchestrator(
+		service.NewOrderService(),
+		billingSvc,
+		inventorySvc,
+		service.NewDiscountService(),
+		WithReturnService(returnSvc),
+		WithPauseBefore("complete_return"),
+	)
+	placed := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+		{ProductID: "product2", Quantity: 1, Price: 50.0},
+	})
+	if !placed.Success {
+		t.Fatalf("Expected order to succeed, got error: %v", placed.Error)
+	}
+
+	request, _ := orchestrator.RequestReturn(placed.Execution.OrderID, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 2},
+		{ProductID: "product2", Quantity: 1},
+	}, "not needed")
+	returnSvc.ApproveReturn(request.ID)
+	returnSvc.MarkReceived(request.ID)
+	returnSvc.RecordInspection(request.ID, map[string]int{"product1": 2, "product2": 1})
+
+	balance := billingSvc.GetUserBalance("user1")
+
+	results := make(chan *SagaResult, 1)
+	go func() {
+		results <- orchestrator.ExecuteReturnSaga("return-1", request.ID)
+	}()
+	waitForPause(t, orchestrator, "return-1", "complete_return")
+
+	if stock := inventorySvc.GetStock("product1"); stock != 10 {
+		t.Errorf("Expected returned units back in stock, got %d", stock)
+	}
+	if err := orchestrator.AbortSaga("return-1", "ops", "inspection was wrong"); err != nil {
+		t.Fatalf("Failed to abort return: %v", err)
+	}
+
+	result := <-results
+	if result.Execution.Status != SagaStatusAborted {
+		t.Fatalf("Expected status %s, got %s", SagaStatusAborted, result.Execution.Status)
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 8 {
+		t.Errorf("Expected restock of product1 to be reversed, got stock %d", stock)
+	}
+	if stock := inventorySvc.GetStock("product2"); stock != 9 {
+		t.Errorf("Expected restock of product2 to be reversed, got stock %d", stock)
+	}
+	if got := billingSvc.GetUserBalance("user1"); got != balance {
+		t.Errorf("Expected refund to be reversed, balance %.2f instead of %.2f", got, balance)
+	}
+}
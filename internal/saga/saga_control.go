@@ -0,0 +1,270 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SagaAuditEntry records an intervention in a running saga: who paused,
+// resumed, skipped or aborted it, before which step and why.
+type SagaAuditEntry struct {
+	Action SagaAuditAction
+	Step   string
+	Actor  string
+	Reason string
+	At     time.Time
+}
+
+type SagaAuditAction string
+
+const (
+	SagaAuditPauseRequested SagaAuditAction = "pause_requested"
+	SagaAuditPaused         SagaAuditAction = "paused"
+	SagaAuditResumed        SagaAuditAction = "resumed"
+	SagaAuditStepSkipped    SagaAuditAction = "step_skipped"
+	SagaAuditAbortRequested SagaAuditAction = "abort_requested"
+	SagaAuditAborted        SagaAuditAction = "aborted"
+	SagaAuditAbortRejected  SagaAuditAction = "abort_rejected"
+	SagaAuditRetried        SagaAuditAction = "retried"
+	SagaAuditResolved       SagaAuditAction = "resolved"
+)
+
+const breakpointActor = "system"
+
+var ErrSagaAborted = errors.New("saga aborted")
+
+// skippableSteps are the steps an order can do without: skipping them leaves
+// nothing for later steps or compensations to trip over.
+var skippableSteps = map[string]bool{
+	"validate_user": true,
+	"redeem_points": true,
+	"accrue_points": true,
+}
+
+// ResumeDecision is an operator's ruling on a paused saga. With SkipStep
+// the step the saga is paused before is recorded as skipped instead of run;
+// Reason is the required justification.
+type ResumeDecision struct {
+	Actor    string
+	Reason   string
+	SkipStep bool
+}
+
+type controlAction int
+
+const (
+	controlRun controlAction = iota
+	controlSkip
+	controlAbort
+)
+
+type controlDecision struct {
+	action controlAction
+	actor  string
+	reason string
+}
+
+// sagaControl holds the operator requests for one saga. It is guarded by the
+// orchestrator's mutex.
+type sagaControl struct {
+	pauseRequested bool
+	pauseBefore    string
+	abort          *controlDecision
+	pausedAt       string
+	decisions      chan controlDecision
+}
+
+// WithPauseBefore makes every saga pause before the named steps until an
+// operator resumes or aborts it, e.g. for a fraud review before
+// process_payment.
+func WithPauseBefore(steps ...string) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		for _, step := range steps {
+			o.breakpoints[step] = true
+		}
+	}
+}
+
+// PauseSaga asks an in-progress saga to pause before step, or before its
+// next step when step is empty. The step running now is not interrupted; in
+// a step graph, the steps already running finish while the saga is paused.
+func (o *SagaOrchestrator) PauseSaga(sagaID, step, actor, reason string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	execution, exists := o.sagas[sagaID]
+	if !exists {
+		return fmt.Errorf("saga execution not found: %s", sagaID)
+	}
+	if execution.Status != SagaStatusInProgress && execution.Status != SagaStatusPaused {
+		return fmt.Errorf("saga %s cannot be paused in status %s", sagaID, execution.Status)
+	}
+
+	control := o.control(sagaID)
+	control.pauseRequested = true
+	control.pauseBefore = step
+	o.audit(execution, SagaAuditPauseRequested, step, actor, reason)
+	return nil
+}
+
+// ResumeSaga lets a paused saga carry on, running or skipping the step it
+// is paused before.
+func (o *SagaOrchestrator) ResumeSaga(sagaID string, decision ResumeDecision) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	control, err := o.pausedControl(sagaID)
+	if err != nil {
+		return err
+	}
+
+	action := controlRun
+	if decision.SkipStep {
+		if decision.Reason == "" {
+			return fmt.Errorf("skipping step %s of saga %s requires a justification", control.pausedAt, sagaID)
+		}
+		if !skippableSteps[control.pausedAt] {
+			return fmt.Errorf("step %s of saga %s cannot be skipped", control.pausedAt, sagaID)
+		}
+		action = controlSkip
+	}
+
+	control.send(controlDecision{action: action, actor: decision.Actor, reason: decision.Reason})
+	return nil
+}
+
+// AbortSaga forces a saga to roll back. A paused saga is compensated at
+// once; a running one at its next step. A saga past its pivot can no longer
+// be rolled back and ignores the request.
+func (o *SagaOrchestrator) AbortSaga(sagaID, actor, reason string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	execution, exists := o.sagas[sagaID]
+	if !exists {
+		return fmt.Errorf("saga execution not found: %s", sagaID)
+	}
+
+	decision := controlDecision{action: controlAbort, actor: actor, reason: reason}
+	if control, err := o.pausedControl(sagaID); err == nil {
+		control.send(decision)
+		return nil
+	}
+	if execution.Status != SagaStatusInProgress {
+		return fmt.Errorf("saga %s cannot be aborted in status %s", sagaID, execution.Status)
+	}
+
+	o.control(sagaID).abort = &decision
+	o.audit(execution, SagaAuditAbortRequested, "", actor, reason)
+	return nil
+}
+
+// checkpoint runs before every step. It pauses the saga when a
+// breakpoint or operator asks for it and returns what to do with the step.
+func (o *SagaOrchestrator) checkpoint(execution *SagaExecution, step string) controlDecision {
+	o.mu.Lock()
+
+	control := o.sagaControls[execution.ID]
+	if control != nil && control.abort != nil {
+		decision := *control.abort
+		control.abort = nil
+		o.mu.Unlock()
+		return o.decide(execution, step, decision, false)
+	}
+
+	requested := control != nil && control.pauseRequested && (control.pauseBefore == "" || control.pauseBefore == step)
+	if !requested && !o.breakpoints[step] {
+		o.mu.Unlock()
+		return controlDecision{action: controlRun}
+	}
+
+	if !requested {
+		o.audit(execution, SagaAuditPauseRequested, step, breakpointActor, "breakpoint")
+	}
+	control = o.control(execution.ID)
+	control.pauseRequested = false
+	control.pausedAt = step
+	control.decisions = make(chan controlDecision, 1)
+	execution.Status = SagaStatusPaused
+	o.audit(execution, SagaAuditPaused, step, "", "")
+	decisions := control.decisions
+	o.mu.Unlock()
+
+	decision := <-decisions
+
+	o.mu.Lock()
+	control.pausedAt = ""
+	execution.Status = SagaStatusInProgress
+	o.mu.Unlock()
+
+	return o.decide(execution, step, decision, true)
+}
+
+// decide audits the decision taken for step. An abort past the pivot is
+// rejected and the step runs.
+func (o *SagaOrchestrator) decide(execution *SagaExecution, step string, decision controlDecision, paused bool) controlDecision {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch decision.action {
+	case controlRun:
+		if paused {
+			o.audit(execution, SagaAuditResumed, step, decision.actor, decision.reason)
+		}
+	case controlSkip:
+		o.audit(execution, SagaAuditStepSkipped, step, decision.actor, decision.reason)
+	case controlAbort:
+		if execution.PivotReached {
+			o.audit(execution, SagaAuditAbortRejected, step, decision.actor, "saga is past its pivot")
+			return controlDecision{action: controlRun}
+		}
+		o.audit(execution, SagaAuditAborted, step, decision.actor, decision.reason)
+	}
+	return decision
+}
+
+// abortSaga compensates a saga an operator aborted before step.
+func (o *SagaOrchestrator) abortSaga(execution *SagaExecution, step string, decision controlDecision) error {
+	execution.Status = SagaStatusFailed
+	o.updateExecution(execution)
+	if len(execution.Compensations) > 0 {
+		o.compensate(execution)
+	}
+	execution.Status = SagaStatusAborted
+	o.updateExecution(execution)
+	return fmt.Errorf("%w by %s before %s: %s", ErrSagaAborted, decision.actor, step, decision.reason)
+}
+
+func (o *SagaOrchestrator) control(sagaID string) *sagaControl {
+	control, exists := o.sagaControls[sagaID]
+	if !exists {
+		control = &sagaControl{}
+		o.sagaControls[sagaID] = control
+	}
+	return control
+}
+
+func (o *SagaOrchestrator) pausedControl(sagaID string) (*sagaControl, error) {
+	control, exists := o.sagaControls[sagaID]
+	if !exists || control.decisions == nil {
+		return nil, fmt.Errorf("saga %s is not paused", sagaID)
+	}
+	return control, nil
+}
+
+func (o *SagaOrchestrator) audit(execution *SagaExecution, action SagaAuditAction, step, actor, reason string) {
+	execution.Audit = append(execution.Audit, SagaAuditEntry{
+		Action: action,
+		Step:   step,
+		Actor:  actor,
+		Reason: reason,
+		At:     time.Now(),
+	})
+}
+
+// send hands the paused saga its decision. Only the first decision counts.
+func (c *sagaControl) send(decision controlDecision) {
+	c.decisions <- decision
+	c.decisions = nil
+}
@@ -0,0 +1,247 @@
+package saga
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func newControlTestOrchestrator(breakpoints ...string) (*SagaOrchestrator, *service.BillingService, *service.InventoryService) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(
+		service.NewOrderService(),
+		billingSvc,
+		inventorySvc,
+		service.NewDiscountService(),
+		WithPauseBefore(breakpoints...),
+	)
+	return orchestrator, billingSvc, inventorySvc
+}
+
+// startOrderSaga runs an order saga in the background; the result arrives on
+// the returned channel.
+func startOrderSaga(orchestrator *SagaOrchestrator, sagaID string) <-chan *SagaResult {
+	results := make(chan *SagaResult, 1)
+	go func() {
+		results <- orchestrator.ExecuteOrderSaga(sagaID, "order-"+sagaID, "user1", []model.OrderItem{
+			{ProductID: "product1", Quantity: 2, Price: 50.0},
+		})
+	}()
+	return results
+}
+
+func waitForPause(t *testing.T, orchestrator *SagaOrchestrator, sagaID, step string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		orchestrator.mu.RLock()
+		control := orchestrator.sagaControls[sagaID]
+		pausedAt := ""
+		if control != nil && control.decisions != nil {
+			pausedAt = control.pausedAt
+		}
+		orchestrator.mu.RUnlock()
+
+		if pausedAt == step {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Saga %s did not pause before %s", sagaID, step)
+}
+
+func auditActions(execution *SagaExecution) []SagaAuditAction {
+	actions := make([]SagaAuditAction, len(execution.Audit))
+	for i, entry := range execution.Audit {
+		actions[i] = entry.Action
+	}
+	return actions
+}
+
+func TestSagaControl_PauseForReviewAndResume(t *testing.T) {
+	orchestrator, billingSvc, _ := newControlTestOrchestrator("process_payment")
+
+	results := startOrderSaga(orchestrator, "saga-1")
+	waitForPause(t, orchestrator, "saga-1", "process_payment")
+
+	execution, _ := orchestrator.GetSagaExecution("saga-1")
+	orchestrator.mu.RLock()
+	status := execution.Status
+	orchestrator.mu.RUnlock()
+	if status != SagaStatusPaused {
+		t.Errorf("Expected status %s, got %s", SagaStatusPaused, status)
+	}
+	if balance := billingSvc.GetUserBalance("user1"); balance != 1000.0 {
+		t.Errorf("Expected no payment while paused, balance %.2f", balance)
+	}
+
+	if err := orchestrator.ResumeSaga("saga-1", ResumeDecision{Actor: "reviewer", Reason: "fraud check passed"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+
+	result := <-results
+	if !result.Success {
+		t.Fatalf("Expected success after resume, got error: %v", result.Error)
+	}
+
+	expected := []SagaAuditAction{SagaAuditPauseRequested, SagaAuditPaused, SagaAuditResumed}
+	actions := auditActions(result.Execution)
+	if len(actions) != len(expected) {
+		t.Fatalf("Expected audit %v, got %v", expected, actions)
+	}
+	for i, action := range expected {
+		if actions[i] != action {
+			t.Errorf("Expected audit %v, got %v", expected, actions)
+			break
+		}
+	}
+	if resumed := result.Execution.Audit[2]; resumed.Actor != "reviewer" || resumed.Step != "process_payment" {
+		t.Errorf("Unexpected resume entry: %+v", resumed)
+	}
+
+	if err := orchestrator.ResumeSaga("saga-1", ResumeDecision{Actor: "reviewer"}); err == nil {
+		t.Error("Expected resuming a finished saga to fail")
+	}
+}
+
+func TestSagaControl_AbortCompensates(t *testing.T) {
+	orchestrator, billingSvc, inventorySvc := newControlTestOrchestrator("process_payment")
+
+	results := startOrderSaga(orchestrator, "saga-2")
+	waitForPause(t, orchestrator, "saga-2", "process_payment")
+
+	if err := orchestrator.AbortSaga("saga-2", "reviewer", "stolen card"); err != nil {
+		t.Fatalf("Failed to abort saga: %v", err)
+	}
+
+	result := <-results
+	if !errors.Is(result.Error, ErrSagaAborted) {
+		t.Fatalf("Expected ErrSagaAborted, got: %v", result.Error)
+	}
+	if result.Execution.Status != SagaStatusAborted {
+		t.Errorf("Expected status %s, got %s", SagaStatusAborted, result.Execution.Status)
+	}
+
+	order, _ := orchestrator.GetOrder(result.Execution.OrderID)
+	if order.Status != model.OrderStatusCancelled {
+		t.Errorf("Expected order to be cancelled, got %s", order.Status)
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 10 {
+		t.Errorf("Expected stock to be released, got %d", stock)
+	}
+	if balance := billingSvc.GetUserBalance("user1"); balance != 1000.0 {
+		t.Errorf("Expected balance to be untouched, got %.2f", balance)
+	}
+}
+
+func TestSagaControl_SkipStep(t *testing.T) {
+	orchestrator, _, _ := newControlTestOrchestrator("process_payment", "accrue_points")
+
+	results := startOrderSaga(orchestrator, "saga-3")
+	waitForPause(t, orchestrator, "saga-3", "process_payment")
+
+	err := orchestrator.ResumeSaga("saga-3", ResumeDecision{Actor: "ops", Reason: "free order", SkipStep: true})
+	if err == nil {
+		t.Fatal("Expected skipping the payment to be refused")
+	}
+	if err := orchestrator.ResumeSaga("saga-3", ResumeDecision{Actor: "ops"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+
+	waitForPause(t, orchestrator, "saga-3", "accrue_points")
+	if err := orchestrator.ResumeSaga("saga-3", ResumeDecision{Actor: "ops", SkipStep: true}); err == nil {
+		t.Fatal("Expected a skip without justification to be refused")
+	}
+	if err := orchestrator.ResumeSaga("saga-3", ResumeDecision{Actor: "ops", Reason: "staff order", SkipStep: true}); err != nil {
+		t.Fatalf("Failed to skip step: %v", err)
+	}
+
+	result := <-results
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	for _, step := range result.Execution.Steps {
+		if step.Name == "accrue_points" && step.Status != StepStatusSkipped {
+			t.Errorf("Expected accrue_points to be skipped, got %s", step.Status)
+		}
+	}
+	if points := orchestrator.loyaltyService.Balance("user1"); points != 0 {
+		t.Errorf("Expected no points for a skipped accrual, got %d", points)
+	}
+}
+
+func TestSagaControl_AbortPastPivotIsRejected(t *testing.T) {
+	orchestrator, _, _ := newControlTestOrchestrator("create_order")
+
+	results := startOrderSaga(orchestrator, "saga-4")
+	waitForPause(t, orchestrator, "saga-4", "create_order")
+
+	if err := orchestrator.PauseSaga("saga-4", "confirm_order", "ops", "check address"); err != nil {
+		t.Fatalf("Failed to request pause: %v", err)
+	}
+	if err := orchestrator.ResumeSaga("saga-4", ResumeDecision{Actor: "ops"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+
+	waitForPause(t, orchestrator, "saga-4", "confirm_order")
+	if err := orchestrator.AbortSaga("saga-4", "ops", "too late"); err != nil {
+		t.Fatalf("Failed to request abort: %v", err)
+	}
+
+	result := <-results
+	if !result.Success {
+		t.Fatalf("Expected saga past its pivot to complete, got error: %v", result.Error)
+	}
+
+	actions := auditActions(result.Execution)
+	if actions[len(actions)-1] != SagaAuditAbortRejected {
+		t.Errorf("Expected the abort to be rejected, got audit %v", actions)
+	}
+}
+
+func TestSagaControl_PauseBeforeGraphStep(t *testing.T) {
+	orchestrator, _, inventorySvc := newControlTestOrchestrator("create_order")
+
+	results := startOrderSaga(orchestrator, "saga-5")
+	waitForPause(t, orchestrator, "saga-5", "create_order")
+
+	if err := orchestrator.PauseSaga("saga-5", "reserve_inventory", "ops", "stock audit"); err != nil {
+		t.Fatalf("Failed to request pause: %v", err)
+	}
+	if err := orchestrator.ResumeSaga("saga-5", ResumeDecision{Actor: "ops"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+
+	waitForPause(t, orchestrator, "saga-5", "reserve_inventory")
+	if level := inventorySvc.GetStockLevel("product1"); level.Stock != 10 || level.Pending != 0 {
+		t.Errorf("Expected nothing reserved while paused, got %+v", level)
+	}
+
+	if err := orchestrator.ResumeSaga("saga-5", ResumeDecision{Actor: "ops", Reason: "audit done"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+	result := <-results
+	if !result.Success {
+		t.Fatalf("Expected success after resume, got error: %v", result.Error)
+	}
+
+	paused := false
+	for _, entry := range result.Execution.Audit {
+		paused = paused || (entry.Action == SagaAuditPaused && entry.Step == "reserve_inventory")
+	}
+	if !paused {
+		t.Errorf("Expected a pause before reserve_inventory in the audit, got %v", auditActions(result.Execution))
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 8 {
+		t.Errorf("Expected stock 8, got %d", stock)
+	}
+}
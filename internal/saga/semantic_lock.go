@@ -0,0 +1,190 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"homework/internal/model"
+)
+
+// LockPolicy says what a saga or reader does on finding an order locked by
+// another saga: wait for it to end, fail fast, or read through the lock and
+// see the pending state.
+type LockPolicy string
+
+const (
+	LockPolicyWait        LockPolicy = "wait"
+	LockPolicyFailFast    LockPolicy = "fail_fast"
+	LockPolicyReadThrough LockPolicy = "read_through"
+)
+
+const defaultLockTimeout = 5 * time.Second
+
+var ErrSagaLocked = errors.New("locked by another saga")
+
+type SagaLockedError struct {
+	Resource string
+	SagaID   string
+}
+
+func (e *SagaLockedError) Error() string {
+	return fmt.Sprintf("%s is locked by saga %s", e.Resource, e.SagaID)
+}
+
+func (e *SagaLockedError) Is(target error) bool {
+	return target == ErrSagaLocked
+}
+
+// WithLockPolicy sets what sagas do on finding their order locked by another
+// saga. Waiting gives up after timeout. The default is to wait five seconds.
+func WithLockPolicy(policy LockPolicy, timeout time.Duration) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.lockPolicy = policy
+		o.lockTimeout = timeout
+	}
+}
+
+// semanticLock is held by a saga execution rather than by its ID, so a saga
+// started with the ID of another can neither share its locks nor release
+// them.
+type semanticLock struct {
+	owner    *SagaExecution
+	released chan struct{}
+}
+
+// semanticLocks flags the records of in-flight sagas. Sagas do not isolate
+// each other, so an order saga holds a semantic lock on its order until it
+// ends, and so do the sagas changing an existing order. Stock and balances
+// are not locked: they only change by commutative updates and carry
+// versions, and pending stock is reported by the inventory service.
+type semanticLocks struct {
+	mu    sync.Mutex
+	locks map[string]*semanticLock
+	held  map[*SagaExecution][]string
+}
+
+func newSemanticLocks() *semanticLocks {
+	return &semanticLocks{
+		locks: make(map[string]*semanticLock),
+		held:  make(map[*SagaExecution][]string),
+	}
+}
+
+// acquire locks resource for owner. A saga may acquire a lock it holds
+// again. The policy only decides what happens when another saga holds the
+// lock: a saga reading through carries on without it.
+func (l *semanticLocks) acquire(resource string, owner *SagaExecution, policy LockPolicy, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	for {
+		l.mu.Lock()
+		lock, locked := l.locks[resource]
+		if !locked {
+			l.locks[resource] = &semanticLock{owner: owner, released: make(chan struct{})}
+			l.held[owner] = append(l.held[owner], resource)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if lock.owner == owner || policy == LockPolicyReadThrough {
+			return nil
+		}
+		if err := l.wait(lock, resource, policy, timeout, &deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// await waits, as policy allows, until resource is no longer locked. It
+// does not take the lock.
+func (l *semanticLocks) await(resource string, policy LockPolicy, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	for {
+		lock, locked := l.owner(resource)
+		if !locked || policy == LockPolicyReadThrough {
+			return nil
+		}
+		if err := l.wait(lock, resource, policy, timeout, &deadline); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *semanticLocks) wait(lock *semanticLock, resource string, policy LockPolicy, timeout time.Duration, deadline *<-chan time.Time) error {
+	lockedErr := &SagaLockedError{Resource: resource, SagaID: lock.owner.ID}
+	if policy == LockPolicyFailFast {
+		return lockedErr
+	}
+
+	if *deadline == nil {
+		*deadline = time.After(timeout)
+	}
+	select {
+	case <-lock.released:
+		return nil
+	case <-*deadline:
+		return fmt.Errorf("timed out after %s: %w", timeout, lockedErr)
+	}
+}
+
+func (l *semanticLocks) owner(resource string) (*semanticLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, locked := l.locks[resource]
+	return lock, locked
+}
+
+// releaseAll releases every lock owner holds and wakes the sagas and
+// readers waiting for them.
+func (l *semanticLocks) releaseAll(owner *SagaExecution) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, resource := range l.held[owner] {
+		if lock := l.locks[resource]; lock != nil && lock.owner == owner {
+			delete(l.locks, resource)
+			close(lock.released)
+		}
+	}
+	delete(l.held, owner)
+}
+
+func orderResource(orderID string) string {
+	return "order:" + orderID
+}
+
+// lockOrder takes the semantic lock on the order for the saga, following
+// the orchestrator's lock policy.
+func (o *SagaOrchestrator) lockOrder(execution *SagaExecution, orderID string) error {
+	return o.locks.acquire(orderResource(orderID), execution, o.lockPolicy, o.lockTimeout)
+}
+
+// finishSaga ends the saga's hold on the records it touched. A completed or
+// resolved saga commits its pending stock reservations. A stalled saga keeps
+// its locks, as its order is still neither completed nor rolled back; they
+// are released once the saga is retried or resolved.
+func (o *SagaOrchestrator) finishSaga(execution *SagaExecution) {
+	o.mu.Lock()
+	delete(o.sagaControls, execution.ID)
+	o.mu.Unlock()
+
+	if execution.Status == SagaStatusStalled {
+		return
+	}
+	if (execution.Status == SagaStatusCompleted || execution.Status == SagaStatusResolved) && execution.OrderID != "" {
+		o.inventoryService.CommitReservations(execution.OrderID)
+	}
+	o.locks.releaseAll(execution)
+}
+
+// GetOrderWithPolicy reads the order, following policy when a saga is still
+// working on it. LockedBySaga is set on an order read through a lock.
+func (o *SagaOrchestrator) GetOrderWithPolicy(orderID string, policy LockPolicy) (*model.Order, error) {
+	if err := o.locks.await(orderResource(orderID), policy, o.lockTimeout); err != nil {
+		return nil, err
+	}
+	return o.GetOrder(orderID)
+}
@@ -0,0 +1,175 @@
+package saga
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func newLockTestOrchestrator(t *testing.T, policy LockPolicy, timeout time.Duration) (*SagaOrchestrator, *service.InventoryService, string) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 10)
+
+	orchestrator := NewSagaOrchestrator(
+		service.NewOrderService(),
+		billingSvc,
+		inventorySvc,
+		service.NewDiscountService(),
+		WithLockPolicy(policy, timeout),
+	)
+
+	result := orchestrator.ExecuteOrderSaga("order-saga", "order-1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 10.0},
+	})
+	if !result.Success {
+		t.Fatalf("Failed to place order: %v", result.Error)
+	}
+	return orchestrator, inventorySvc, result.Execution.OrderID
+}
+
+func TestSemanticLock_CompletedSagaReleasesAndCommits(t *testing.T) {
+	orchestrator, inventorySvc, orderID := newLockTestOrchestrator(t, LockPolicyFailFast, time.Second)
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.LockedBySaga != "" {
+		t.Errorf("Expected completed order to be unlocked, locked by %s", order.LockedBySaga)
+	}
+
+	level := inventorySvc.GetStockLevel("product1")
+	if level.Stock != 8 || level.Pending != 0 {
+		t.Errorf("Expected 8 in stock and none pending, got %+v", level)
+	}
+	for _, reservation := range inventorySvc.GetReservations(orderID) {
+		if reservation.Pending {
+			t.Error("Expected reservation to be committed")
+		}
+	}
+}
+
+func TestSemanticLock_FailFast(t *testing.T) {
+	orchestrator, _, orderID := newLockTestOrchestrator(t, LockPolicyFailFast, time.Second)
+	orchestrator.locks.acquire(orderResource(orderID), &SagaExecution{ID: "other-saga"}, LockPolicyFailFast, 0)
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-1", orderID, "changed my mind")
+	var lockedErr *SagaLockedError
+	if !errors.As(result.Error, &lockedErr) || lockedErr.SagaID != "other-saga" {
+		t.Fatalf("Expected SagaLockedError, got: %v", result.Error)
+	}
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Status != model.OrderStatusConfirmed {
+		t.Errorf("Expected order to stay confirmed, got %s", order.Status)
+	}
+	if order.LockedBySaga != "other-saga" {
+		t.Errorf("Expected order to be flagged as locked by other-saga, got %q", order.LockedBySaga)
+	}
+
+	if _, err := orchestrator.GetOrderWithPolicy(orderID, LockPolicyFailFast); !errors.Is(err, ErrSagaLocked) {
+		t.Errorf("Expected ErrSagaLocked for a fail-fast read, got: %v", err)
+	}
+	if _, err := orchestrator.GetOrderWithPolicy(orderID, LockPolicyReadThrough); err != nil {
+		t.Errorf("Expected read-through to succeed, got: %v", err)
+	}
+}
+
+func TestSemanticLock_WaitsForRelease(t *testing.T) {
+	orchestrator, _, orderID := newLockTestOrchestrator(t, LockPolicyWait, 5*time.Second)
+	other := &SagaExecution{ID: "other-saga"}
+	orchestrator.locks.acquire(orderResource(orderID), other, LockPolicyFailFast, 0)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		orchestrator.locks.releaseAll(other)
+	}()
+
+	result := orchestrator.ExecuteCancelOrderSaga("cancel-2", orderID, "changed my mind")
+	if !result.Success {
+		t.Fatalf("Expected cancellation after the lock was released, got: %v", result.Error)
+	}
+
+	order, err := orchestrator.GetOrderWithPolicy(orderID, LockPolicyWait)
+	if err != nil {
+		t.Fatalf("Failed to read order: %v", err)
+	}
+	if order.Status != model.OrderStatusCancelled || order.LockedBySaga != "" {
+		t.Errorf("Expected unlocked cancelled order, got %s locked by %q", order.Status, order.LockedBySaga)
+	}
+}
+
+func TestSemanticLock_WaitTimesOut(t *testing.T) {
+	orchestrator, _, orderID := newLockTestOrchestrator(t, LockPolicyWait, 20*time.Millisecond)
+	orchestrator.locks.acquire(orderResource(orderID), &SagaExecution{ID: "other-saga"}, LockPolicyFailFast, 0)
+
+	result := orchestrator.ModifyOrder("modify-1", orderID, []model.OrderItem{
+		{ProductID: "product1", Quantity: 3, Price: 10.0},
+	})
+	if !errors.Is(result.Error, ErrSagaLocked) {
+		t.Fatalf("Expected ErrSagaLocked after timing out, got: %v", result.Error)
+	}
+}
+
+func TestSemanticLock_SharedSagaIDDoesNotShareLocks(t *testing.T) {
+	orchestrator, _, orderID := newLockTestOrchestrator(t, LockPolicyFailFast, time.Second)
+	resource := orderResource(orderID)
+	first := &SagaExecution{ID: "shared"}
+	second := &SagaExecution{ID: "shared"}
+
+	if err := orchestrator.locks.acquire(resource, first, LockPolicyFailFast, 0); err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	if err := orchestrator.locks.acquire(resource, second, LockPolicyFailFast, 0); !errors.Is(err, ErrSagaLocked) {
+		t.Errorf("Expected a saga reusing the ID to be locked out, got: %v", err)
+	}
+
+	orchestrator.locks.releaseAll(second)
+	if lock, locked := orchestrator.locks.owner(resource); !locked || lock.owner != first {
+		t.Fatal("Expected the first saga to keep its lock")
+	}
+
+	orchestrator.locks.releaseAll(first)
+	if _, locked := orchestrator.locks.owner(resource); locked {
+		t.Error("Expected the lock to be released by its owner")
+	}
+}
+
+func TestSemanticLock_ReadThroughSagaStillLocks(t *testing.T) {
+	orchestrator, _, _ := newControlTestOrchestrator("process_payment")
+	orchestrator.lockPolicy = LockPolicyReadThrough
+
+	results := startOrderSaga(orchestrator, "saga-rt")
+	waitForPause(t, orchestrator, "saga-rt", "process_payment")
+
+	execution, _ := orchestrator.GetSagaExecution("saga-rt")
+	orchestrator.mu.RLock()
+	orderID := execution.OrderID
+	orchestrator.mu.RUnlock()
+
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.LockedBySaga != "saga-rt" {
+		t.Errorf("Expected the order to be locked by saga-rt, got %q", order.LockedBySaga)
+	}
+	if _, err := orchestrator.GetOrderWithPolicy(orderID, LockPolicyFailFast); !errors.Is(err, ErrSagaLocked) {
+		t.Errorf("Expected ErrSagaLocked for a fail-fast read, got: %v", err)
+	}
+	if err := orchestrator.locks.acquire(orderResource(orderID), &SagaExecution{ID: "other-saga"}, LockPolicyReadThrough, 0); err != nil {
+		t.Errorf("Expected a read-through saga to carry on, got: %v", err)
+	}
+	if lock, _ := orchestrator.locks.owner(orderResource(orderID)); lock.owner.ID != "saga-rt" {
+		t.Errorf("Expected saga-rt to keep the lock, held by %s", lock.owner.ID)
+	}
+
+	if err := orchestrator.ResumeSaga("saga-rt", ResumeDecision{Actor: "ops"}); err != nil {
+		t.Fatalf("Failed to resume saga: %v", err)
+	}
+	if result := <-results; !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+	if order, _ := orchestrator.GetOrder(orderID); order.LockedBySaga != "" {
+		t.Errorf("Expected the completed order to be unlocked, locked by %q", order.LockedBySaga)
+	}
+}
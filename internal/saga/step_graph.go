@@ -0,0 +1,191 @@
+package saga
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepDefinition is one node of a saga step graph. A step starts as soon as
+// every step it depends on has completed, so steps without a path between
+// them run concurrently. Compensation is asked for the undo action once the
+// step has completed; it may return nil when there is nothing to undo.
+//
+// Actions of concurrent steps run on their own goroutines and must not touch
+// the execution or share mutable state with each other.
+type StepDefinition struct {
+	Name         string
+	DependsOn    []string
+	Action       func() (interface{}, error)
+	Compensation func() *CompensationAction
+}
+
+type stepOutcome struct {
+	index int
+	step  SagaStep
+	err   error
+}
+
+// runGraph runs steps in dependency order, concurrently where the graph
+// allows, and records each step as it finishes. Every step passes the
+// checkpoint before it starts, so a saga can be paused, skipped past or
+// aborted inside the graph; the steps already running finish first. When a
+// step fails or the saga is aborted no new steps are started; the
+// orchestrator waits for the running ones, registers the compensations of
+// every completed step, siblings of the failed step included, and
+// compensates the whole saga.
+func (o *SagaOrchestrator) runGraph(execution *SagaExecution, steps []StepDefinition) error {
+	dependents, waiting, err := stepGraph(steps)
+	if err != nil {
+		return o.runStep(execution, "validate_step_graph", func() (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	// Buffered so that running steps can finish while the saga is paused.
+	outcomes := make(chan stepOutcome, len(steps))
+	running := 0
+	start := func(index int) {
+		running++
+		go func() {
+			step := SagaStep{Name: steps[index].Name, Kind: StepKindCompensatable, Attempts: 1, StartedAt: time.Now()}
+			result, err := steps[index].Action()
+			step.CompletedAt = time.Now()
+			if err != nil {
+				step.Status = StepStatusFailed
+				step.Error = err
+			} else {
+				step.Status = StepStatusCompleted
+				step.Result = result
+			}
+			outcomes <- stepOutcome{index: index, step: step, err: err}
+		}()
+	}
+
+	var ready []int
+	for i := range steps {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	release := func(index int) {
+		for _, dependent := range dependents[index] {
+			waiting[dependent]--
+			if waiting[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	completed := make([]bool, len(steps))
+	failedIndex := -1
+	var failure error
+	var abort *controlDecision
+	abortedBefore := ""
+	for {
+		for len(ready) > 0 && failure == nil && abort == nil {
+			index := ready[0]
+			ready = ready[1:]
+
+			switch decision := o.checkpoint(execution, steps[index].Name); decision.action {
+			case controlAbort:
+				abort, abortedBefore = &decision, steps[index].Name
+			case controlSkip:
+				now := time.Now()
+				execution.Steps = append(execution.Steps, SagaStep{Name: steps[index].Name, Kind: StepKindCompensatable, Status: StepStatusSkipped, StartedAt: now, CompletedAt: now})
+				o.updateExecution(execution)
+				release(index)
+			default:
+				start(index)
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		outcome := <-outcomes
+		running--
+
+		execution.Steps = append(execution.Steps, outcome.step)
+		o.updateExecution(execution)
+
+		if outcome.err != nil {
+			if failure == nil || outcome.index < failedIndex {
+				failure, failedIndex = outcome.err, outcome.index
+			}
+			continue
+		}
+
+		completed[outcome.index] = true
+		release(outcome.index)
+	}
+
+	for i, step := range steps {
+		if !completed[i] || step.Compensation == nil {
+			continue
+		}
+		if compensation := step.Compensation(); compensation != nil {
+			execution.Compensations = append(execution.Compensations, *compensation)
+		}
+	}
+
+	if failure != nil {
+		execution.Status = SagaStatusFailed
+		o.updateExecution(execution)
+		if len(execution.Compensations) > 0 {
+			o.compensate(execution)
+		}
+		return failure
+	}
+	if abort != nil {
+		return o.abortSaga(execution, abortedBefore, *abort)
+	}
+
+	return nil
+}
+
+// stepGraph returns, for every step, the steps depending on it and the
+// number of steps it waits for. Duplicate names, unknown dependencies and
+// cycles are rejected.
+func stepGraph(steps []StepDefinition) ([][]int, []int, error) {
+	indexes := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if _, exists := indexes[step.Name]; exists {
+			return nil, nil, fmt.Errorf("duplicate saga step: %s", step.Name)
+		}
+		indexes[step.Name] = i
+	}
+
+	dependents := make([][]int, len(steps))
+	waiting := make([]int, len(steps))
+	for i, step := range steps {
+		for _, dependency := range step.DependsOn {
+			index, exists := indexes[dependency]
+			if !exists {
+				return nil, nil, fmt.Errorf("saga step %s depends on unknown step %s", step.Name, dependency)
+			}
+			dependents[index] = append(dependents[index], i)
+			waiting[i]++
+		}
+	}
+
+	remaining := append([]int(nil), waiting...)
+	ready := make([]int, 0, len(steps))
+	for i := range steps {
+		if remaining[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for visited := 0; visited < len(ready); visited++ {
+		for _, dependent := range dependents[ready[visited]] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(ready) != len(steps) {
+		return nil, nil, fmt.Errorf("saga steps form a cycle")
+	}
+
+	return dependents, waiting, nil
+}
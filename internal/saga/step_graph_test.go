@@ -0,0 +1,214 @@
+package saga
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func newTestExecution(id string) *SagaExecution {
+	return &SagaExecution{
+		ID:            id,
+		Status:        SagaStatusInProgress,
+		Steps:         make([]SagaStep, 0),
+		Compensations: make([]CompensationAction, 0),
+	}
+}
+
+func TestRunGraph_IndependentStepsRunConcurrently(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	execution := newTestExecution("graph-1")
+
+	// Each branch waits until the other has started, so the graph only
+	// completes if both run at the same time.
+	leftStarted := make(chan struct{})
+	rightStarted := make(chan struct{})
+	branch := func(own, other chan struct{}) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			close(own)
+			select {
+			case <-other:
+				time.Sleep(10 * time.Millisecond)
+				return nil, nil
+			case <-time.After(time.Second):
+				return nil, errors.New("sibling did not run concurrently")
+			}
+		}
+	}
+
+	err := orchestrator.runGraph(execution, []StepDefinition{
+		{Name: "left", Action: branch(leftStarted, rightStarted)},
+		{Name: "right", Action: branch(rightStarted, leftStarted)},
+		{Name: "join", DependsOn: []string{"left", "right"}, Action: func() (interface{}, error) {
+			return nil, nil
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	steps := make(map[string]SagaStep)
+	for _, step := range execution.Steps {
+		steps[step.Name] = step
+	}
+	if len(steps) != 3 {
+		t.Fatalf("Expected 3 recorded steps, got %d", len(execution.Steps))
+	}
+
+	left, right, join := steps["left"], steps["right"], steps["join"]
+	if !left.StartedAt.Before(right.CompletedAt) || !right.StartedAt.Before(left.CompletedAt) {
+		t.Errorf("Expected left and right to overlap, got %v-%v and %v-%v",
+			left.StartedAt, left.CompletedAt, right.StartedAt, right.CompletedAt)
+	}
+	if join.StartedAt.Before(left.CompletedAt) || join.StartedAt.Before(right.CompletedAt) {
+		t.Error("Expected join to start after both of its dependencies completed")
+	}
+}
+
+func TestRunGraph_FailedBranchCompensatesSiblings(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	execution := newTestExecution("graph-2")
+
+	var undone []string
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name:   "earlier",
+		Action: func() error { undone = append(undone, "earlier"); return nil },
+	})
+
+	var joined atomic.Bool
+	siblingDone := make(chan struct{})
+	err := orchestrator.runGraph(execution, []StepDefinition{
+		{
+			Name: "sibling",
+			Action: func() (interface{}, error) {
+				close(siblingDone)
+				return nil, nil
+			},
+			Compensation: func() *CompensationAction {
+				return &CompensationAction{
+					Name:   "undo_sibling",
+					Action: func() error { undone = append(undone, "sibling"); return nil },
+				}
+			},
+		},
+		{
+			Name: "failing",
+			Action: func() (interface{}, error) {
+				<-siblingDone
+				return nil, errors.New("branch failed")
+			},
+		},
+		{
+			Name:      "join",
+			DependsOn: []string{"sibling", "failing"},
+			Action: func() (interface{}, error) {
+				joined.Store(true)
+				return nil, nil
+			},
+		},
+	})
+	if err == nil || err.Error() != "branch failed" {
+		t.Fatalf("Expected the branch failure, got: %v", err)
+	}
+
+	if joined.Load() {
+		t.Error("Expected join not to run after a failed dependency")
+	}
+	if execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected status %s, got %s", SagaStatusCompensated, execution.Status)
+	}
+	if len(undone) != 2 || undone[0] != "sibling" || undone[1] != "earlier" {
+		t.Errorf("Expected the sibling and then earlier steps to be undone, got %v", undone)
+	}
+}
+
+func TestRunGraph_InvalidGraph(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+	noop := func() (interface{}, error) { return nil, nil }
+
+	tests := []struct {
+		name  string
+		steps []StepDefinition
+	}{
+		{"unknown dependency", []StepDefinition{{Name: "a", DependsOn: []string{"b"}, Action: noop}}},
+		{"duplicate step", []StepDefinition{{Name: "a", Action: noop}, {Name: "a", Action: noop}}},
+		{"cycle", []StepDefinition{
+			{Name: "a", DependsOn: []string{"b"}, Action: noop},
+			{Name: "b", DependsOn: []string{"a"}, Action: noop},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execution := newTestExecution("graph-invalid")
+			if err := orchestrator.runGraph(execution, tt.steps); err == nil {
+				t.Fatal("Expected an invalid graph to be rejected")
+			}
+			if execution.Status != SagaStatusFailed {
+				t.Errorf("Expected status %s, got %s", SagaStatusFailed, execution.Status)
+			}
+		})
+	}
+}
+
+func TestSagaOrchestrator_ReservationFailureRemovesParallelDiscount(t *testing.T) {
+	orderSvc := service.NewOrderService()
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	discountSvc := service.NewDiscountService()
+
+	billingSvc.SetUserBalance("user1", 1000.0)
+	inventorySvc.SetStock("product1", 1)
+	discountSvc.SetUserDiscount("user1", 10.0)
+
+	orchestrator := NewSagaOrchestrator(orderSvc, billingSvc, inventorySvc, discountSvc)
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 5, Price: 100.0},
+	}
+
+	result := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	if !errors.Is(result.Error, service.ErrInsufficientStock) {
+		t.Fatalf("Expected ErrInsufficientStock, got: %v", result.Error)
+	}
+
+	for _, step := range result.Execution.Steps {
+		if step.StartedAt.IsZero() || step.CompletedAt.Before(step.StartedAt) {
+			t.Errorf("Expected step %s to record its start and end, got %v-%v", step.Name, step.StartedAt, step.CompletedAt)
+		}
+	}
+
+	if _, err := discountSvc.GetDiscountByOrderID(result.Execution.OrderID); err == nil {
+		t.Error("Expected the discount applied alongside the reservation to be removed")
+	}
+}
+
+func TestRunGraph_BreakpointAndAbort(t *testing.T) {
+	orchestrator, billingSvc, inventorySvc := newControlTestOrchestrator("apply_discount")
+
+	results := startOrderSaga(orchestrator, "saga-graph")
+	waitForPause(t, orchestrator, "saga-graph", "apply_discount")
+
+	if err := orchestrator.AbortSaga("saga-graph", "ops", "duplicate order"); err != nil {
+		t.Fatalf("Failed to abort saga: %v", err)
+	}
+
+	result := <-results
+	if !errors.Is(result.Error, ErrSagaAborted) {
+		t.Fatalf("Expected ErrSagaAborted, got: %v", result.Error)
+	}
+	for _, step := range result.Execution.Steps {
+		if step.Name == "apply_discount" {
+			t.Error("Expected apply_discount not to run after the abort")
+		}
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 10 {
+		t.Errorf("Expected the parallel reservation to be released, got stock %d", stock)
+	}
+	if balance := billingSvc.GetUserBalance("user1"); balance != 1000.0 {
+		t.Errorf("Expected balance to be untouched, got %.2f", balance)
+	}
+}
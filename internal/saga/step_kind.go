@@ -0,0 +1,176 @@
+package saga
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepKind says how a step recovers from failure. Compensatable steps are
+// undone when the saga rolls back. The pivot is the point of no return: once
+// it has completed the saga can only move forward, so every later step is
+// retriable and is retried instead of rolling the saga back.
+type StepKind string
+
+const (
+	StepKindCompensatable StepKind = "compensatable"
+	StepKindPivot         StepKind = "pivot"
+	StepKindRetriable     StepKind = "retriable"
+)
+
+// RetryPolicy bounds how often a retriable step is attempted. The wait
+// before each retry doubles, starting at Backoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, Backoff: 20 * time.Millisecond}
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.Backoff << (attempt - 1)
+}
+
+// WithRetryPolicy sets how retriable steps are retried.
+func WithRetryPolicy(policy RetryPolicy) OrchestratorOption {
+	return func(o *SagaOrchestrator) {
+		o.retryPolicy = policy
+	}
+}
+
+// runStepOfKind runs a step of the given kind. A failing compensatable or
+// pivot step fails the saga and compensates it. A retriable step is retried
+// under the retry policy first; past the pivot nothing is compensated, and a
+// step that still fails leaves the saga stalled for an operator to finish.
+func (o *SagaOrchestrator) runStepOfKind(execution *SagaExecution, name string, kind StepKind, action func() (interface{}, error)) error {
+	if execution.PivotReached {
+		kind = StepKindRetriable
+	}
+
+	switch decision := o.checkpoint(execution, name); decision.action {
+	case controlAbort:
+		return o.abortSaga(execution, name, decision)
+	case controlSkip:
+		now := time.Now()
+		execution.Steps = append(execution.Steps, SagaStep{Name: name, Kind: kind, Status: StepStatusSkipped, StartedAt: now, CompletedAt: now})
+		o.updateExecution(execution)
+		return nil
+	}
+
+	step := SagaStep{Name: name, Kind: kind, Status: StepStatusPending, StartedAt: time.Now()}
+
+	maxAttempts := 1
+	if kind == StepKindRetriable {
+		maxAttempts = o.retryPolicy.MaxAttempts
+	}
+
+	result, err := action()
+	step.Attempts = 1
+	for err != nil && step.Attempts < maxAttempts {
+		time.Sleep(o.retryPolicy.delay(step.Attempts))
+		result, err = action()
+		step.Attempts++
+	}
+	step.CompletedAt = time.Now()
+
+	if err != nil {
+		step.Status = StepStatusFailed
+		step.Error = err
+		execution.Steps = append(execution.Steps, step)
+		if execution.PivotReached {
+			execution.Status = SagaStatusStalled
+			o.updateExecution(execution)
+			return err
+		}
+
+		execution.Status = SagaStatusFailed
+		o.updateExecution(execution)
+		if len(execution.Compensations) > 0 {
+			o.compensate(execution)
+		}
+		return err
+	}
+
+	step.Status = StepStatusCompleted
+	step.Result = result
+	execution.Steps = append(execution.Steps, step)
+	if kind == StepKindPivot {
+		execution.PivotReached = true
+	}
+	o.updateExecution(execution)
+	return nil
+}
+
+// runForward runs the retriable steps past the pivot in order. When one of
+// them stalls the saga, it and the steps after it are kept so that
+// RetryStalledSaga can carry on where the saga stopped.
+func (o *SagaOrchestrator) runForward(execution *SagaExecution, steps []StepDefinition) error {
+	for i, step := range steps {
+		err := o.runStepOfKind(execution, step.Name, StepKindRetriable, step.Action)
+		if err == nil {
+			continue
+		}
+
+		if execution.Status == SagaStatusStalled {
+			o.mu.Lock()
+			o.stalled[execution.ID] = steps[i:]
+			o.mu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+// RetryStalledSaga runs the remaining steps of a stalled saga again, e.g.
+// once the participant that kept failing is back. A saga that completes
+// releases its locks; one that fails again stays stalled.
+func (o *SagaOrchestrator) RetryStalledSaga(sagaID, actor, reason string) error {
+	execution, steps, err := o.takeStalled(sagaID, SagaAuditRetried, actor, reason)
+	if err != nil {
+		return err
+	}
+
+	execution.Status = SagaStatusInProgress
+	o.updateExecution(execution)
+	if err := o.runForward(execution, steps); err != nil {
+		return err
+	}
+
+	execution.Status = SagaStatusCompleted
+	o.updateExecution(execution)
+	o.finishSaga(execution)
+	return nil
+}
+
+// ResolveStalledSaga records that an operator finished a stalled saga by
+// hand. The remaining steps are dropped and the saga's locks released.
+func (o *SagaOrchestrator) ResolveStalledSaga(sagaID, actor, reason string) error {
+	execution, _, err := o.takeStalled(sagaID, SagaAuditResolved, actor, reason)
+	if err != nil {
+		return err
+	}
+
+	execution.Status = SagaStatusResolved
+	o.updateExecution(execution)
+	o.finishSaga(execution)
+	return nil
+}
+
+func (o *SagaOrchestrator) takeStalled(sagaID string, action SagaAuditAction, actor, reason string) (*SagaExecution, []StepDefinition, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	execution, exists := o.sagas[sagaID]
+	if !exists {
+		return nil, nil, fmt.Errorf("saga execution not found: %s", sagaID)
+	}
+	steps, stalled := o.stalled[sagaID]
+	if !stalled {
+		return nil, nil, fmt.Errorf("saga %s is not stalled: status %s", sagaID, execution.Status)
+	}
+
+	delete(o.stalled, sagaID)
+	o.audit(execution, action, steps[0].Name, actor, reason)
+	return execution, steps, nil
+}
@@ -0,0 +1,253 @@
+package saga
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+func newRetryTestOrchestrator() *SagaOrchestrator {
+	return NewSagaOrchestrator(
+		service.NewOrderService(),
+		service.NewBillingService(),
+		service.NewInventoryService(),
+		service.NewDiscountService(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}),
+	)
+}
+
+// flakyAction fails the first failures calls.
+func flakyAction(failures int) func() (interface{}, error) {
+	calls := 0
+	return func() (interface{}, error) {
+		calls++
+		if calls <= failures {
+			return nil, errors.New("temporary failure")
+		}
+		return calls, nil
+	}
+}
+
+func runPivot(t *testing.T, orchestrator *SagaOrchestrator, execution *SagaExecution, compensated *bool) {
+	t.Helper()
+
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "undo",
+		Action: func() error {
+			*compensated = true
+			return nil
+		},
+	})
+	if err := orchestrator.runStepOfKind(execution, "pivot", StepKindPivot, flakyAction(0)); err != nil {
+		t.Fatalf("Pivot failed: %v", err)
+	}
+	if !execution.PivotReached {
+		t.Fatal("Expected pivot to be reached")
+	}
+}
+
+func TestStepKind_RetriableStepRetriesForward(t *testing.T) {
+	orchestrator := newRetryTestOrchestrator()
+	execution := newTestExecution("saga-1")
+	compensated := false
+	runPivot(t, orchestrator, execution, &compensated)
+
+	if err := orchestrator.runStepOfKind(execution, "confirm", StepKindRetriable, flakyAction(2)); err != nil {
+		t.Fatalf("Expected retriable step to succeed, got: %v", err)
+	}
+
+	step := execution.Steps[1]
+	if step.Status != StepStatusCompleted || step.Attempts != 3 {
+		t.Errorf("Expected completed step after 3 attempts, got %s after %d", step.Status, step.Attempts)
+	}
+	if compensated {
+		t.Error("Expected no compensation past the pivot")
+	}
+}
+
+func TestStepKind_ExhaustedRetriesStallSaga(t *testing.T) {
+	orchestrator := newRetryTestOrchestrator()
+	execution := newTestExecution("saga-2")
+	compensated := false
+	runPivot(t, orchestrator, execution, &compensated)
+
+	if err := orchestrator.runStepOfKind(execution, "confirm", StepKindRetriable, flakyAction(10)); err == nil {
+		t.Fatal("Expected retriable step to give up")
+	}
+
+	if execution.Status != SagaStatusStalled {
+		t.Errorf("Expected status %s, got %s", SagaStatusStalled, execution.Status)
+	}
+	if execution.Steps[1].Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", execution.Steps[1].Attempts)
+	}
+	if compensated {
+		t.Error("Expected no compensation past the pivot")
+	}
+}
+
+func TestStepKind_CompensatableStepPastPivotIsRetried(t *testing.T) {
+	orchestrator := newRetryTestOrchestrator()
+	execution := newTestExecution("saga-3")
+	compensated := false
+	runPivot(t, orchestrator, execution, &compensated)
+
+	if err := orchestrator.runStep(execution, "notify", flakyAction(1)); err != nil {
+		t.Fatalf("Expected step past the pivot to be retried, got: %v", err)
+	}
+	if step := execution.Steps[1]; step.Kind != StepKindRetriable || step.Attempts != 2 {
+		t.Errorf("Expected retriable step after 2 attempts, got %s after %d", step.Kind, step.Attempts)
+	}
+	if compensated {
+		t.Error("Expected no compensation past the pivot")
+	}
+}
+
+func TestStepKind_PivotFailureCompensates(t *testing.T) {
+	orchestrator := newRetryTestOrchestrator()
+	execution := newTestExecution("saga-4")
+	compensated := false
+	execution.Compensations = append(execution.Compensations, CompensationAction{
+		Name: "undo",
+		Action: func() error {
+			compensated = true
+			return nil
+		},
+	})
+
+	if err := orchestrator.runStepOfKind(execution, "pivot", StepKindPivot, flakyAction(1)); err == nil {
+		t.Fatal("Expected pivot to fail")
+	}
+	if execution.PivotReached {
+		t.Error("Expected failed pivot not to be reached")
+	}
+	if execution.Steps[0].Attempts != 1 {
+		t.Errorf("Expected a pivot to be tried once, got %d attempts", execution.Steps[0].Attempts)
+	}
+	if !compensated || execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected saga to be compensated, got status %s", execution.Status)
+	}
+}
+
+func TestSagaOrchestrator_PaymentIsPivot(t *testing.T) {
+	orchestrator := createTestOrchestrator()
+
+	result := orchestrator.ExecuteOrderSaga("saga-5", "order-5", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+	if !result.Execution.PivotReached {
+		t.Error("Expected the pivot to be reached")
+	}
+
+	kinds := make(map[string]StepKind)
+	for _, step := range result.Execution.Steps {
+		kinds[step.Name] = step.Kind
+	}
+	expected := map[string]StepKind{
+		"create_order":    StepKindCompensatable,
+		"process_payment": StepKindPivot,
+		"accrue_points":   StepKindRetriable,
+		"confirm_order":   StepKindRetriable,
+	}
+	for name, kind := range expected {
+		if kinds[name] != kind {
+			t.Errorf("Expected %s to be %s, got %s", name, kind, kinds[name])
+		}
+	}
+}
+
+func stallOrderSaga(t *testing.T, sagaID string) (*SagaOrchestrator, *SagaExecution) {
+	t.Helper()
+
+	inventorySvc := service.NewInventoryService()
+	inventorySvc.SetStock("product1", 10)
+	billingSvc := service.NewBillingService()
+	billingSvc.SetUserBalance("user1", 1000.0)
+	orchestrator := NewSagaOrchestrator(
+		service.NewOrderService(),
+		billingSvc,
+		inventorySvc,
+		service.NewDiscountService(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}),
+		WithLockPolicy(LockPolicyFailFast, 0),
+	)
+	orchestrator.loyaltyService.SetShouldFail(true)
+
+	result := orchestrator.ExecuteOrderSaga(sagaID, "order-"+sagaID, "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 50.0},
+	})
+	if result.Success || result.Execution.Status != SagaStatusStalled {
+		t.Fatalf("Expected the saga to stall, got status %s", result.Execution.Status)
+	}
+	return orchestrator, result.Execution
+}
+
+func TestStepKind_RetryStalledSaga(t *testing.T) {
+	orchestrator, execution := stallOrderSaga(t, "saga-6")
+	orderID := execution.OrderID
+
+	cancel := orchestrator.ExecuteCancelOrderSaga("cancel-6a", orderID, "changed my mind")
+	if !errors.Is(cancel.Error, ErrSagaLocked) {
+		t.Fatalf("Expected the stalled order to stay locked, got: %v", cancel.Error)
+	}
+
+	if err := orchestrator.RetryStalledSaga("saga-6", "ops", "loyalty is back"); err == nil {
+		t.Fatal("Expected the retry to fail while loyalty is down")
+	}
+	if execution.Status != SagaStatusStalled {
+		t.Fatalf("Expected the saga to stall again, got %s", execution.Status)
+	}
+
+	orchestrator.loyaltyService.SetShouldFail(false)
+	if err := orchestrator.RetryStalledSaga("saga-6", "ops", "loyalty is back"); err != nil {
+		t.Fatalf("Failed to retry stalled saga: %v", err)
+	}
+	if execution.Status != SagaStatusCompleted {
+		t.Errorf("Expected status %s, got %s", SagaStatusCompleted, execution.Status)
+	}
+	if order, _ := orchestrator.GetOrder(orderID); order.Status != model.OrderStatusConfirmed || order.LockedBySaga != "" {
+		t.Fatalf("Expected an unlocked confirmed order, got %s locked by %q", order.Status, order.LockedBySaga)
+	}
+	if points := orchestrator.loyaltyService.Balance("user1"); points == 0 {
+		t.Error("Expected points to be accrued on retry")
+	}
+
+	cancel = orchestrator.ExecuteCancelOrderSaga("cancel-6b", orderID, "changed my mind")
+	if !cancel.Success {
+		t.Fatalf("Expected the recovered order to be cancelled, got: %v", cancel.Error)
+	}
+	if err := orchestrator.RetryStalledSaga("saga-6", "ops", ""); err == nil {
+		t.Error("Expected retrying a completed saga to fail")
+	}
+}
+
+func TestStepKind_ResolveStalledSaga(t *testing.T) {
+	orchestrator, execution := stallOrderSaga(t, "saga-7")
+
+	if err := orchestrator.ResolveStalledSaga("saga-7", "ops", "points credited by hand"); err != nil {
+		t.Fatalf("Failed to resolve stalled saga: %v", err)
+	}
+	if execution.Status != SagaStatusResolved {
+		t.Errorf("Expected status %s, got %s", SagaStatusResolved, execution.Status)
+	}
+	if actions := auditActions(execution); actions[len(actions)-1] != SagaAuditResolved {
+		t.Errorf("Expected the resolution to be audited, got %v", actions)
+	}
+
+	order, err := orchestrator.GetOrderWithPolicy(execution.OrderID, LockPolicyFailFast)
+	if err != nil {
+		t.Fatalf("Expected the resolved order to be unlocked, got: %v", err)
+	}
+	if order.LockedBySaga != "" {
+		t.Errorf("Expected no lock on the resolved order, locked by %q", order.LockedBySaga)
+	}
+	if level := orchestrator.inventoryService.GetStockLevel("product1"); level.Pending != 0 {
+		t.Errorf("Expected the reservation to be committed, got %+v", level)
+	}
+}
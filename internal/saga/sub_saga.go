@@ -0,0 +1,141 @@
+package saga
+
+import (
+	"fmt"
+	"time"
+
+	"homework/internal/model"
+)
+
+// SagaTree is a saga execution together with the sub-sagas it started.
+type SagaTree struct {
+	Execution *SagaExecution
+	Children  []*SagaTree
+}
+
+// runSubSaga runs a child saga as the step name of parent. The child is a
+// saga execution of its own, linked to the parent and queryable by childID.
+// It compensates its own steps when it fails, and the failure fails the
+// parent step. Once the child has completed, the parent registers the
+// compensation named compensation, which compensates the child.
+func (o *SagaOrchestrator) runSubSaga(parent *SagaExecution, name, compensation, childID string, sagaType SagaType, run func(child *SagaExecution) error) (*SagaExecution, error) {
+	now := time.Now()
+
+	child := &SagaExecution{
+		ID:            childID,
+		TenantID:      o.tenantID,
+		Type:          sagaType,
+		ParentID:      parent.ID,
+		OrderID:       parent.OrderID,
+		UserID:        parent.UserID,
+		Status:        SagaStatusInProgress,
+		Steps:         make([]SagaStep, 0),
+		Compensations: make([]CompensationAction, 0),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	o.mu.Lock()
+	_, taken := o.sagas[childID]
+	if !taken {
+		o.sagas[childID] = child
+		parent.ChildIDs = append(parent.ChildIDs, childID)
+	}
+	o.mu.Unlock()
+
+	err := o.runStep(parent, name, func() (interface{}, error) {
+		if taken {
+			return nil, fmt.Errorf("%w: %s", ErrSagaExists, childID)
+		}
+		if err := run(child); err != nil {
+			return nil, fmt.Errorf("sub-saga %s failed: %w", childID, err)
+		}
+		child.Status = SagaStatusCompleted
+		o.updateExecution(child)
+		return child, nil
+	})
+	if err != nil {
+		return child, err
+	}
+
+	parent.Compensations = append(parent.Compensations, CompensationAction{
+		Name: compensation,
+		Action: func() error {
+			o.compensate(child)
+			o.updateExecution(child)
+			return nil
+		},
+	})
+
+	return child, nil
+}
+
+// GetSagaTree returns the saga and, recursively, every sub-saga it started.
+func (o *SagaOrchestrator) GetSagaTree(sagaID string) (*SagaTree, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.sagaTree(sagaID)
+}
+
+func (o *SagaOrchestrator) sagaTree(sagaID string) (*SagaTree, error) {
+	execution, exists := o.sagas[sagaID]
+	if !exists {
+		return nil, fmt.Errorf("saga execution not found: %s", sagaID)
+	}
+
+	tree := &SagaTree{Execution: execution, Children: make([]*SagaTree, 0, len(execution.ChildIDs))}
+	for _, childID := range execution.ChildIDs {
+		child, err := o.sagaTree(childID)
+		if err != nil {
+			return nil, err
+		}
+		tree.Children = append(tree.Children, child)
+	}
+	return tree, nil
+}
+
+// splitShipment is the sub-saga shipping an order from every warehouse that
+// stocks part of it: one shipment per warehouse, then the order is charged
+// the combined shipping cost. A failed shipment cancels those already
+// created.
+func (o *SagaOrchestrator) splitShipment(child *SagaExecution, order *model.Order) (*model.Order, error) {
+	shipments := make([]*model.Shipment, 0)
+	for _, group := range o.shippingService.SplitByWarehouse(order.Items) {
+		group := group
+		var shipment *model.Shipment
+		err := o.runStep(child, "ship_from_"+group.Warehouse, func() (interface{}, error) {
+			var err error
+			shipment, err = o.shippingService.CreateWarehouseShipment(order.ID, order.UserID, group.Warehouse, group.Items)
+			return shipment, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		shipments = append(shipments, shipment)
+		child.Compensations = append(child.Compensations, CompensationAction{
+			Name: "cancel_shipment_" + group.Warehouse,
+			Action: func() error {
+				return o.shippingService.CancelShipment(shipment.ID)
+			},
+		})
+	}
+
+	var updated *model.Order
+	err := o.runStep(child, "set_shipping_cost", func() (interface{}, error) {
+		cost := 0.0
+		for _, shipment := range shipments {
+			cost += shipment.Cost
+		}
+
+		var err error
+		updated, err = o.orderService.SetShippingCost(order.ID, cost)
+		return updated, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
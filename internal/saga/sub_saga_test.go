@@ -0,0 +1,188 @@
+package saga
+
+import (
+	"testing"
+
+	"homework/internal/model"
+	"homework/internal/service"
+)
+
+// failingRateTable quotes no carrier for shipments containing product.
+type failingRateTable struct {
+	product string
+}
+
+func (t failingRateTable) Quote(items []model.OrderItem) []model.ShippingQuote {
+	for _, item := range items {
+		if item.ProductID == t.product {
+			return nil
+		}
+	}
+	return service.DefaultRateTable().Quote(items)
+}
+
+func newSplitShipmentOrchestrator(rates service.RateTable) (*SagaOrchestrator, *service.ShippingService, *service.InventoryService) {
+	billingSvc := service.NewBillingService()
+	inventorySvc := service.NewInventoryService()
+	shippingSvc := service.NewShippingService(rates)
+
+	billingSvc.SetUserBalance("user1", 10000.0)
+	billingSvc.SetUserBalance("user2", 50.0)
+	inventorySvc.SetStock("product1", 100)
+	inventorySvc.SetStock("product2", 100)
+	shippingSvc.SetProductWarehouse("product1", "east")
+	shippingSvc.SetProductWarehouse("product2", "west")
+
+	orchestrator := NewSagaOrchestrator(
+		service.NewOrderService(),
+		billingSvc,
+		inventorySvc,
+		service.NewDiscountService(),
+		WithShippingService(shippingSvc),
+	)
+	return orchestrator, shippingSvc, inventorySvc
+}
+
+var splitItems = []model.OrderItem{
+	{ProductID: "product1", Quantity: 1, Price: 10.0},
+	{ProductID: "product2", Quantity: 1, Price: 10.0},
+}
+
+func TestSubSaga_SplitShipmentTree(t *testing.T) {
+	orchestrator, shippingSvc, _ := newSplitShipmentOrchestrator(service.DefaultRateTable())
+
+	result := orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", splitItems)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	tree, err := orchestrator.GetSagaTree("saga-1")
+	if err != nil {
+		t.Fatalf("Failed to get saga tree: %v", err)
+	}
+	if len(tree.Children) != 1 {
+		t.Fatalf("Expected 1 sub-saga, got %d", len(tree.Children))
+	}
+
+	child := tree.Children[0].Execution
+	if child.Type != SagaTypeSplitShipment || child.ParentID != "saga-1" {
+		t.Errorf("Unexpected sub-saga: type %s, parent %s", child.Type, child.ParentID)
+	}
+	if child.Status != SagaStatusCompleted {
+		t.Errorf("Expected sub-saga status %s, got %s", SagaStatusCompleted, child.Status)
+	}
+
+	expectedSteps := []string{"ship_from_east", "ship_from_west", "set_shipping_cost"}
+	if len(child.Steps) != len(expectedSteps) {
+		t.Fatalf("Expected %d sub-saga steps, got %d", len(expectedSteps), len(child.Steps))
+	}
+	for i, name := range expectedSteps {
+		if child.Steps[i].Name != name {
+			t.Errorf("Expected step %d to be %s, got %s", i, name, child.Steps[i].Name)
+		}
+	}
+
+	if _, err := orchestrator.GetSagaExecution(child.ID); err != nil {
+		t.Errorf("Expected sub-saga to be queryable by ID: %v", err)
+	}
+
+	shipments := shippingSvc.GetShipmentsByOrderID(result.Execution.OrderID)
+	if len(shipments) != 2 {
+		t.Fatalf("Expected 2 shipments, got %d", len(shipments))
+	}
+
+	order, _ := orchestrator.GetOrder(result.Execution.OrderID)
+	if order.ShippingCost != 12.0 {
+		t.Errorf("Expected combined shipping cost 12.0, got %.2f", order.ShippingCost)
+	}
+}
+
+func TestSubSaga_ChildFailureFailsParent(t *testing.T) {
+	orchestrator, shippingSvc, inventorySvc := newSplitShipmentOrchestrator(failingRateTable{product: "product2"})
+
+	result := orchestrator.ExecuteOrderSaga("saga-2", "order-2", "user1", splitItems)
+	if result.Success {
+		t.Fatal("Expected saga to fail when a warehouse cannot ship")
+	}
+	if result.Execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected parent status %s, got %s", SagaStatusCompensated, result.Execution.Status)
+	}
+
+	tree, _ := orchestrator.GetSagaTree("saga-2")
+	if tree.Children[0].Execution.Status != SagaStatusCompensated {
+		t.Errorf("Expected sub-saga status %s, got %s", SagaStatusCompensated, tree.Children[0].Execution.Status)
+	}
+
+	for _, shipment := range shippingSvc.GetShipmentsByOrderID(result.Execution.OrderID) {
+		if shipment.Status != model.ShipmentStatusCancelled {
+			t.Errorf("Expected shipment from %s to be cancelled, got %s", shipment.Warehouse, shipment.Status)
+		}
+	}
+	if stock := inventorySvc.GetStock("product1"); stock != 100 {
+		t.Errorf("Expected stock to be released, got %d", stock)
+	}
+}
+
+func TestSubSaga_ParentCompensationCompensatesChild(t *testing.T) {
+	orchestrator, shippingSvc, _ := newSplitShipmentOrchestrator(service.DefaultRateTable())
+
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+		{ProductID: "product2", Quantity: 1, Price: 100.0},
+	}
+	result := orchestrator.ExecuteOrderSaga("saga-3", "order-3", "user2", items)
+	if result.Success {
+		t.Fatal("Expected payment failure")
+	}
+
+	tree, _ := orchestrator.GetSagaTree("saga-3")
+	child := tree.Children[0].Execution
+	if child.Status != SagaStatusCompensated {
+		t.Errorf("Expected completed sub-saga to be compensated, got %s", child.Status)
+	}
+
+	shipments := shippingSvc.GetShipmentsByOrderID(result.Execution.OrderID)
+	if len(shipments) != 2 {
+		t.Fatalf("Expected 2 shipments, got %d", len(shipments))
+	}
+	for _, shipment := range shipments {
+		if shipment.Status != model.ShipmentStatusCancelled {
+			t.Errorf("Expected shipment from %s to be cancelled, got %s", shipment.Warehouse, shipment.Status)
+		}
+	}
+}
+
+func TestSubSaga_OrderDeliveredWithLastShipment(t *testing.T) {
+	orchestrator, shippingSvc, _ := newSplitShipmentOrchestrator(service.DefaultRateTable())
+
+	result := orchestrator.ExecuteOrderSaga("saga-4", "order-4", "user1", splitItems)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+	orderID := result.Execution.OrderID
+
+	shipments := shippingSvc.GetShipmentsByOrderID(orderID)
+	for _, shipment := range shipments {
+		for _, status := range []model.ShipmentStatus{model.ShipmentStatusPickedUp, model.ShipmentStatusInTransit} {
+			if _, err := orchestrator.UpdateShipmentTracking(shipment.ID, status, ""); err != nil {
+				t.Fatalf("Failed to update shipment from %s: %v", shipment.Warehouse, err)
+			}
+		}
+	}
+
+	if _, err := orchestrator.UpdateShipmentTracking(shipments[0].ID, model.ShipmentStatusDelivered, ""); err != nil {
+		t.Fatalf("Failed to deliver first shipment: %v", err)
+	}
+	order, _ := orchestrator.GetOrder(orderID)
+	if order.Status != model.OrderStatusShipped {
+		t.Errorf("Expected order to stay %s until every shipment arrives, got %s", model.OrderStatusShipped, order.Status)
+	}
+
+	if _, err := orchestrator.UpdateShipmentTracking(shipments[1].ID, model.ShipmentStatusDelivered, ""); err != nil {
+		t.Fatalf("Failed to deliver second shipment: %v", err)
+	}
+	order, _ = orchestrator.GetOrder(orderID)
+	if order.Status != model.OrderStatusDelivered {
+		t.Errorf("Expected order status %s, got %s", model.OrderStatusDelivered, order.Status)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"homework/internal/model"
+)
+
+// Run with -cpu 1,2,4,8 to compare throughput as cores are added; each
+// operation touches keys spread over many shards. The single_lock variants
+// run the same operations behind one global mutex, as the services did
+// before they were sharded, to give a baseline.
+
+const benchmarkKeys = 1024
+
+// runParallel runs op from parallel goroutines with increasing operation
+// numbers, serialising the calls through a single mutex when singleLock is
+// set.
+func runParallel(b *testing.B, singleLock bool, op func(n int64)) {
+	var mu sync.Mutex
+	var counter atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := counter.Add(1)
+			if singleLock {
+				mu.Lock()
+			}
+			op(n)
+			if singleLock {
+				mu.Unlock()
+			}
+		}
+	})
+}
+
+// benchmarkVariants runs the benchmark once sharded and once behind a single
+// lock.
+func benchmarkVariants(b *testing.B, run func(b *testing.B, singleLock bool)) {
+	b.Run("sharded", func(b *testing.B) { run(b, false) })
+	b.Run("single_lock", func(b *testing.B) { run(b, true) })
+}
+
+func BenchmarkInventoryService_ReserveRelease(b *testing.B) {
+	benchmarkVariants(b, func(b *testing.B, singleLock bool) {
+		service := NewInventoryService()
+		for i := 0; i < benchmarkKeys; i++ {
+			service.SetStock(fmt.Sprintf("product%d", i), 1<<30)
+		}
+
+		runParallel(b, singleLock, func(n int64) {
+			orderID := fmt.Sprintf("order%d", n)
+			items := []model.OrderItem{
+				{ProductID: fmt.Sprintf("product%d", n%benchmarkKeys), Quantity: 1, Price: 100.0},
+			}
+
+			if _, err := service.ReserveItems(orderID, items); err != nil {
+				b.Error(err)
+				return
+			}
+			service.ReleaseItems(orderID)
+		})
+	})
+}
+
+func BenchmarkBillingService_PaymentRefund(b *testing.B) {
+	benchmarkVariants(b, func(b *testing.B, singleLock bool) {
+		service := NewBillingService()
+		for i := 0; i < benchmarkKeys; i++ {
+			service.SetUserBalance(fmt.Sprintf("user%d", i), 1e12)
+		}
+
+		runParallel(b, singleLock, func(n int64) {
+			orderID := fmt.Sprintf("order%d", n)
+
+			if _, err := service.ProcessPayment(orderID, fmt.Sprintf("user%d", n%benchmarkKeys), 1.0); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := service.RefundPaymentByOrderID(orderID); err != nil {
+				b.Error(err)
+			}
+		})
+	})
+}
+
+func BenchmarkOrderService_CreateConfirm(b *testing.B) {
+	benchmarkVariants(b, func(b *testing.B, singleLock bool) {
+		service := NewOrderService()
+		items := []model.OrderItem{
+			{ProductID: "product1", Quantity: 1, Price: 100.0},
+		}
+
+		runParallel(b, singleLock, func(n int64) {
+			order, err := service.CreateOrder(fmt.Sprintf("user%d", n%benchmarkKeys), items)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			service.ConfirmOrder(order.ID)
+		})
+	})
+}
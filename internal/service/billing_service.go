@@ -3,108 +3,421 @@ package service
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"homework/internal/model"
 )
 
+const refundTolerance = 0.005
+
+// BillingService keeps balances in shards keyed by user ID. A payment's
+// mutable fields are guarded by the shard of its user; the payment ID and
+// order ID indexes only guard their own maps.
+//
+// Sagas change balances only by commutative deltas, so payments and refunds
+// of concurrent sagas never overwrite each other. Every change bumps the
+// balance version, which callers setting an absolute balance check first.
 type BillingService struct {
-	mu         sync.RWMutex
-	payments   map[string]*model.Payment
-	userBalances map[string]float64
-	shouldFail bool
+	tenantID        string
+	accounts        [shardCount]*billingShard
+	paymentsByID    [shardCount]*paymentIndex
+	paymentsByOrder [shardCount]*orderPaymentIndex
+	shouldFail      atomic.Bool
+	currency        atomic.Value
+}
+
+type billingShard struct {
+	mu              sync.RWMutex
+	userBalances    map[string]float64
+	balanceVersions map[string]int64
+}
+
+type paymentIndex struct {
+	mu       sync.RWMutex
+	payments map[string]*model.Payment
+}
+
+type orderPaymentIndex struct {
+	mu       sync.RWMutex
+	payments map[string][]*model.Payment
 }
 
 func NewBillingService() *BillingService {
-	return &BillingService{
-		payments:     make(map[string]*model.Payment),
-		userBalances: make(map[string]float64),
+	return NewBillingServiceForTenant("")
+}
+
+// NewBillingServiceForTenant returns a service holding the balances and
+// payments of one tenant.
+func NewBillingServiceForTenant(tenantID string) *BillingService {
+	service := &BillingService{tenantID: tenantID}
+
+	for i := 0; i < shardCount; i++ {
+		service.accounts[i] = &billingShard{
+			userBalances:    make(map[string]float64),
+			balanceVersions: make(map[string]int64),
+		}
+		service.paymentsByID[i] = &paymentIndex{payments: make(map[string]*model.Payment)}
+		service.paymentsByOrder[i] = &orderPaymentIndex{payments: make(map[string][]*model.Payment)}
 	}
+
+	return service
 }
 
 func (s *BillingService) SetShouldFail(shouldFail bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.shouldFail = shouldFail
+	s.shouldFail.Store(shouldFail)
+}
+
+// SetCurrency sets the currency new payments are recorded in.
+func (s *BillingService) SetCurrency(currency string) {
+	s.currency.Store(currency)
 }
 
 func (s *BillingService) SetUserBalance(userID string, balance float64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.userBalances[userID] = balance
+	shard := s.account(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.set(userID, balance)
+}
+
+// SetUserBalanceIfVersion sets the balance only if it is still at
+// expectedVersion and returns the new version.
+func (s *BillingService) SetUserBalanceIfVersion(userID string, balance float64, expectedVersion int64) (int64, error) {
+	shard := s.account(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if version := shard.balanceVersions[userID]; version != expectedVersion {
+		return version, &ConcurrentModificationError{
+			Entity:          "balance",
+			ID:              userID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   version,
+		}
+	}
+
+	shard.set(userID, balance)
+	return shard.balanceVersions[userID], nil
+}
+
+// AdjustUserBalance adds delta to the balance. Adjustments commute, so
+// concurrent ones need no version check; a debit beyond the balance fails.
+func (s *BillingService) AdjustUserBalance(userID string, delta float64) (float64, error) {
+	shard := s.account(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	balance := shard.userBalances[userID]
+	if balance+delta < 0 {
+		return balance, &InsufficientFundsError{UserID: userID, Balance: balance, Required: -delta}
+	}
+
+	shard.adjust(userID, delta)
+	return shard.userBalances[userID], nil
 }
 
 func (s *BillingService) GetUserBalance(userID string) float64 {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.userBalances[userID]
+	shard := s.account(userID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.userBalances[userID]
 }
 
-func (s *BillingService) ProcessPayment(orderID, userID string, amount float64) (*model.Payment, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// GetUserBalanceVersion returns the balance together with its version.
+func (s *BillingService) GetUserBalanceVersion(userID string) (float64, int64) {
+	shard := s.account(userID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.userBalances[userID], shard.balanceVersions[userID]
+}
 
-	if s.shouldFail {
-		return nil, fmt.Errorf("payment processing failed: insufficient funds")
+func (s *BillingService) ProcessPayment(orderID, userID string, amount float64) (*model.Payment, error) {
+	if s.shouldFail.Load() {
+		return nil, fmt.Errorf("payment processing failed: %w", ErrInsufficientFunds)
 	}
 
-	balance := s.userBalances[userID]
+	shard := s.account(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	balance := shard.userBalances[userID]
 	if balance < amount {
-		return nil, fmt.Errorf("insufficient funds: balance %.2f, required %.2f", balance, amount)
+		return nil, &InsufficientFundsError{UserID: userID, Balance: balance, Required: amount}
 	}
 
-	s.userBalances[userID] = balance - amount
+	shard.adjust(userID, -amount)
 
 	payment := &model.Payment{
 		ID:        uuid.New().String(),
+		TenantID:  s.tenantID,
 		OrderID:   orderID,
 		UserID:    userID,
 		Amount:    amount,
+		Currency:  loadCurrency(&s.currency),
 		Status:    model.PaymentStatusCompleted,
+		Version:   1,
 		CreatedAt: time.Now(),
 	}
 
-	s.payments[payment.ID] = payment
-	return payment, nil
+	s.indexPayment(payment)
+	return payment.Clone(), nil
 }
 
 func (s *BillingService) RefundPayment(paymentID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	payment, exists := s.payments[paymentID]
+	payment, exists := s.lookupPayment(paymentID)
 	if !exists {
 		return fmt.Errorf("payment not found: %s", paymentID)
 	}
 
+	shard := s.account(payment.UserID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
 	payment.Status = model.PaymentStatusRefunded
+	payment.Version++
 	return nil
 }
 
+// RefundPaymentByOrderID refunds what is left of the order's payment. A
+// payment of nothing, as for an order paid entirely with points, is marked
+// refunded without touching the balance.
 func (s *BillingService) RefundPaymentByOrderID(orderID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for _, payment := range s.payments {
-		if payment.OrderID == orderID && payment.Status == model.PaymentStatusCompleted {
+	for _, payment := range s.orderPayments(orderID) {
+		shard := s.account(payment.UserID)
+		shard.mu.Lock()
+		refundable := payment.Refundable()
+		if refundable > 0 || (payment.Status == model.PaymentStatusCompleted && payment.Amount == 0) {
+			if refundable > 0 {
+				shard.adjust(payment.UserID, refundable)
+			}
+			payment.RefundedAmount = payment.Amount
 			payment.Status = model.PaymentStatusRefunded
-			s.userBalances[payment.UserID] += payment.Amount
+			payment.Version++
+			shard.mu.Unlock()
 			return nil
 		}
+		shard.mu.Unlock()
 	}
 
 	return fmt.Errorf("payment not found for order: %s", orderID)
 }
 
+// RefundPartial returns amount of the order's payment to the user. The payment
+// becomes refunded once nothing is left to refund.
+func (s *BillingService) RefundPartial(orderID string, amount float64) (*model.Payment, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid refund amount for order %s: %.2f", orderID, amount)
+	}
+
+	for _, payment := range s.orderPayments(orderID) {
+		shard := s.account(payment.UserID)
+		shard.mu.Lock()
+
+		refundable := payment.Refundable()
+		if refundable <= 0 {
+			shard.mu.Unlock()
+			continue
+		}
+
+		if amount > refundable+refundTolerance {
+			shard.mu.Unlock()
+			return nil, fmt.Errorf("refund exceeds payment for order %s: refund %.2f, refundable %.2f",
+				orderID, amount, refundable)
+		}
+
+		shard.adjust(payment.UserID, amount)
+		payment.RefundedAmount += amount
+		payment.Status = model.PaymentStatusPartiallyRefunded
+		if payment.Refundable() <= refundTolerance {
+			payment.Status = model.PaymentStatusRefunded
+		}
+		payment.Version++
+
+		result := payment.Clone()
+		shard.mu.Unlock()
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("payment not found for order: %s", orderID)
+}
+
+// ReverseRefund takes back a partial refund, e.g. when the saga that issued it
+// is compensated.
+func (s *BillingService) ReverseRefund(orderID string, amount float64) error {
+	for _, payment := range s.orderPayments(orderID) {
+		shard := s.account(payment.UserID)
+		shard.mu.Lock()
+
+		if payment.RefundedAmount+refundTolerance < amount {
+			shard.mu.Unlock()
+			continue
+		}
+
+		balance := shard.userBalances[payment.UserID]
+		if balance < amount {
+			shard.mu.Unlock()
+			return &InsufficientFundsError{UserID: payment.UserID, Balance: balance, Required: amount}
+		}
+
+		shard.adjust(payment.UserID, -amount)
+		payment.RefundedAmount -= amount
+		payment.Status = model.PaymentStatusPartiallyRefunded
+		if payment.RefundedAmount <= refundTolerance {
+			payment.RefundedAmount = 0
+			payment.Status = model.PaymentStatusCompleted
+		}
+		payment.Version++
+		shard.mu.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("refund not found for order: %s", orderID)
+}
+
+// AdjustPayment changes the amount charged for the order's payment, taking
+// the difference from the user's balance or giving it back. Refunds already
+// issued against the payment are kept.
+func (s *BillingService) AdjustPayment(orderID string, amount float64) (*model.Payment, error) {
+	if s.shouldFail.Load() {
+		return nil, fmt.Errorf("payment adjustment failed: %w", ErrInsufficientFunds)
+	}
+
+	for _, payment := range s.orderPayments(orderID) {
+		shard := s.account(payment.UserID)
+		shard.mu.Lock()
+
+		if payment.Status != model.PaymentStatusCompleted && payment.Status != model.PaymentStatusPartiallyRefunded {
+			shard.mu.Unlock()
+			continue
+		}
+
+		if amount < payment.RefundedAmount {
+			shard.mu.Unlock()
+			return nil, fmt.Errorf("payment for order %s cannot be reduced below refunded %.2f: requested %.2f",
+				orderID, payment.RefundedAmount, amount)
+		}
+
+		delta := amount - payment.Amount
+		balance := shard.userBalances[payment.UserID]
+		if balance < delta {
+			shard.mu.Unlock()
+			return nil, &InsufficientFundsError{UserID: payment.UserID, Balance: balance, Required: delta}
+		}
+
+		shard.adjust(payment.UserID, -delta)
+		payment.Amount = amount
+		payment.Version++
+
+		result := payment.Clone()
+		shard.mu.Unlock()
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("payment not found for order: %s", orderID)
+}
+
 func (s *BillingService) GetPayment(paymentID string) (*model.Payment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	payment, exists := s.lookupPayment(paymentID)
+	if !exists {
+		return nil, fmt.Errorf("payment not found: %s", paymentID)
+	}
+
+	shard := s.account(payment.UserID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	payment, exists := s.payments[paymentID]
+	return payment.Clone(), nil
+}
+
+// UpdatePaymentStatus sets the payment status only if the payment is still at
+// expectedVersion. Balances are not touched; use the refund methods to return
+// funds.
+func (s *BillingService) UpdatePaymentStatus(paymentID string, expectedVersion int64, status model.PaymentStatus) (*model.Payment, error) {
+	payment, exists := s.lookupPayment(paymentID)
 	if !exists {
 		return nil, fmt.Errorf("payment not found: %s", paymentID)
 	}
 
-	return payment, nil
+	shard := s.account(payment.UserID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if payment.Version != expectedVersion {
+		return nil, &ConcurrentModificationError{
+			Entity:          "payment",
+			ID:              paymentID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   payment.Version,
+		}
+	}
+
+	payment.Status = status
+	payment.Version++
+	return payment.Clone(), nil
+}
+
+// GetPaymentByOrderID returns the completed payment of the order, or its most
+// recent payment when none is completed.
+func (s *BillingService) GetPaymentByOrderID(orderID string) (*model.Payment, error) {
+	payments := s.orderPayments(orderID)
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("payment not found for order: %s", orderID)
+	}
+
+	var latest *model.Payment
+	for _, payment := range payments {
+		shard := s.account(payment.UserID)
+		shard.mu.RLock()
+		snapshot := payment.Clone()
+		shard.mu.RUnlock()
+
+		if snapshot.Status == model.PaymentStatusCompleted {
+			return snapshot, nil
+		}
+		latest = snapshot
+	}
+
+	return latest, nil
+}
+
+func (s *billingShard) set(userID string, balance float64) {
+	s.userBalances[userID] = balance
+	s.balanceVersions[userID]++
+}
+
+func (s *billingShard) adjust(userID string, delta float64) {
+	s.userBalances[userID] += delta
+	s.balanceVersions[userID]++
+}
+
+func (s *BillingService) account(userID string) *billingShard {
+	return s.accounts[shardIndex(userID)]
+}
+
+func (s *BillingService) indexPayment(payment *model.Payment) {
+	byID := s.paymentsByID[shardIndex(payment.ID)]
+	byID.mu.Lock()
+	byID.payments[payment.ID] = payment
+	byID.mu.Unlock()
+
+	byOrder := s.paymentsByOrder[shardIndex(payment.OrderID)]
+	byOrder.mu.Lock()
+	byOrder.payments[payment.OrderID] = append(byOrder.payments[payment.OrderID], payment)
+	byOrder.mu.Unlock()
+}
+
+func (s *BillingService) lookupPayment(paymentID string) (*model.Payment, bool) {
+	index := s.paymentsByID[shardIndex(paymentID)]
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	payment, exists := index.payments[paymentID]
+	return payment, exists
+}
+
+func (s *BillingService) orderPayments(orderID string) []*model.Payment {
+	index := s.paymentsByOrder[shardIndex(orderID)]
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return append([]*model.Payment(nil), index.payments[orderID]...)
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"testing"
 
 	"homework/internal/model"
@@ -74,3 +75,110 @@ func TestBillingService_RefundPaymentByOrderID(t *testing.T) {
 		t.Errorf("Expected balance 1000.0, got %.2f", balance)
 	}
 }
+
+func TestBillingService_UpdatePaymentStatus_VersionConflict(t *testing.T) {
+	service := NewBillingService()
+	service.SetUserBalance("user1", 1000.0)
+	payment, _ := service.ProcessPayment("order1", "user1", 100.0)
+
+	service.RefundPaymentByOrderID("order1")
+
+	_, err := service.UpdatePaymentStatus(payment.ID, payment.Version, model.PaymentStatusFailed)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("Expected ErrConcurrentModification, got: %v", err)
+	}
+
+	stored, _ := service.GetPayment(payment.ID)
+	if stored.Status != model.PaymentStatusRefunded {
+		t.Errorf("Expected status %s, got %s", model.PaymentStatusRefunded, stored.Status)
+	}
+}
+
+func TestBillingService_RefundPartial(t *testing.T) {
+	service := NewBillingService()
+	service.SetUserBalance("user1", 1000.0)
+	service.ProcessPayment("order1", "user1", 100.0)
+
+	payment, err := service.RefundPartial("order1", 40.0)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if payment.Status != model.PaymentStatusPartiallyRefunded || payment.RefundedAmount != 40.0 {
+		t.Errorf("Expected partially refunded 40.00, got %s %.2f", payment.Status, payment.RefundedAmount)
+	}
+
+	if _, err := service.RefundPartial("order1", 80.0); err == nil {
+		t.Error("Expected error when refunding more than remains")
+	}
+
+	if err := service.RefundPaymentByOrderID("order1"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if balance := service.GetUserBalance("user1"); balance != 1000.0 {
+		t.Errorf("Expected balance 1000.00, got %.2f", balance)
+	}
+}
+
+func TestBillingService_AdjustPayment(t *testing.T) {
+	service := NewBillingService()
+	service.SetUserBalance("user1", 500.0)
+	service.ProcessPayment("order1", "user1", 200.0)
+
+	payment, err := service.AdjustPayment("order1", 250.0)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if payment.Amount != 250.0 {
+		t.Errorf("Expected amount 250.00, got %.2f", payment.Amount)
+	}
+
+	if balance := service.GetUserBalance("user1"); balance != 250.0 {
+		t.Errorf("Expected balance 250.00, got %.2f", balance)
+	}
+
+	service.AdjustPayment("order1", 100.0)
+	if balance := service.GetUserBalance("user1"); balance != 400.0 {
+		t.Errorf("Expected balance 400.00, got %.2f", balance)
+	}
+
+	if _, err := service.AdjustPayment("order1", 600.0); err == nil {
+		t.Error("Expected error for insufficient funds")
+	}
+}
+
+func TestBillingService_BalanceVersions(t *testing.T) {
+	service := NewBillingService()
+	service.SetUserBalance("user1", 100.0)
+
+	balance, version := service.GetUserBalanceVersion("user1")
+	if balance != 100.0 || version != 1 {
+		t.Fatalf("Expected balance 100.0 at version 1, got %.2f at %d", balance, version)
+	}
+
+	if _, err := service.AdjustUserBalance("user1", 25.0); err != nil {
+		t.Fatalf("Failed to credit balance: %v", err)
+	}
+	if _, err := service.ProcessPayment("order1", "user1", 50.0); err != nil {
+		t.Fatalf("Failed to process payment: %v", err)
+	}
+
+	_, err := service.SetUserBalanceIfVersion("user1", 0, version)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Expected ErrConcurrentModification, got: %v", err)
+	}
+
+	balance, version = service.GetUserBalanceVersion("user1")
+	if balance != 75.0 || version != 3 {
+		t.Errorf("Expected balance 75.0 at version 3, got %.2f at %d", balance, version)
+	}
+	if _, err := service.SetUserBalanceIfVersion("user1", 0, version); err != nil {
+		t.Errorf("Expected set at current version to succeed, got: %v", err)
+	}
+
+	if _, err := service.AdjustUserBalance("user1", -1.0); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds for an overdraft, got: %v", err)
+	}
+}
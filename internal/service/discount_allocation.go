@@ -0,0 +1,134 @@
+package service
+
+import (
+	"math"
+	"sort"
+
+	"homework/internal/model"
+)
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// floorCents rounds amount down to a cent.
+func floorCents(amount float64) float64 {
+	return math.Floor(amount*100+1e-9) / 100
+}
+
+// distributeCents splits total, rounded to cents, over weights. Each share is
+// rounded down to a cent and the leftover cents go to the largest remainders,
+// ties to the lower index, so the shares always add up to the rounded total
+// and the same input always gives the same split.
+func distributeCents(total float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+
+	sum := 0.0
+	for _, weight := range weights {
+		sum += weight
+	}
+	if sum <= 0 {
+		return shares
+	}
+
+	totalCents := int64(math.Round(total * 100))
+	cents := make([]int64, len(weights))
+	remainders := make([]float64, len(weights))
+	assigned := int64(0)
+	for i, weight := range weights {
+		exact := float64(totalCents) * weight / sum
+		cents[i] = int64(math.Floor(exact + 1e-9))
+		remainders[i] = exact - float64(cents[i])
+		assigned += cents[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+
+	for i := 0; assigned < totalCents && i < len(order); i++ {
+		if weights[order[i]] <= 0 {
+			continue
+		}
+		cents[order[i]]++
+		assigned++
+	}
+
+	for i := range shares {
+		shares[i] = float64(cents[i]) / 100
+	}
+	return shares
+}
+
+// roundAllocations rounds a rule's allocations to cents without changing
+// their rounded total.
+func roundAllocations(allocations []model.LineAllocation) []model.LineAllocation {
+	weights := make([]float64, len(allocations))
+	total := 0.0
+	for i, allocation := range allocations {
+		weights[i] = allocation.Amount
+		total += allocation.Amount
+	}
+
+	shares := distributeCents(total, weights)
+	rounded := make([]model.LineAllocation, 0, len(allocations))
+	for i, allocation := range allocations {
+		if shares[i] <= 0 {
+			continue
+		}
+		allocation.Amount = shares[i]
+		rounded = append(rounded, allocation)
+	}
+	return rounded
+}
+
+// proportionalAllocations spreads amount over the order lines in proportion
+// to their value.
+func proportionalAllocations(items []model.OrderItem, amount float64) []model.LineAllocation {
+	weights := make([]float64, len(items))
+	for i, item := range items {
+		weights[i] = item.Price * float64(item.Quantity)
+	}
+
+	shares := distributeCents(amount, weights)
+	var allocations []model.LineAllocation
+	for i, share := range shares {
+		if share <= 0 {
+			continue
+		}
+		allocations = append(allocations, model.LineAllocation{
+			Line:      i,
+			ProductID: items[i].ProductID,
+			Amount:    share,
+		})
+	}
+	return allocations
+}
+
+// sumByLine adds up the allocations of all applied rules per order line.
+func sumByLine(applied []model.AppliedRule) []model.LineAllocation {
+	byLine := make(map[int]*model.LineAllocation)
+	for _, rule := range applied {
+		for _, allocation := range rule.Allocations {
+			line, exists := byLine[allocation.Line]
+			if !exists {
+				line = &model.LineAllocation{Line: allocation.Line, ProductID: allocation.ProductID}
+				byLine[allocation.Line] = line
+			}
+			line.Amount = roundCents(line.Amount + allocation.Amount)
+		}
+	}
+
+	lines := make([]model.LineAllocation, 0, len(byLine))
+	for _, line := range byLine {
+		lines = append(lines, *line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Line < lines[j].Line
+	})
+	return lines
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"homework/internal/model"
+)
+
+func (s *DiscountService) CreateCampaign(campaign model.Campaign) error {
+	if campaign.ID == "" {
+		return fmt.Errorf("campaign ID is required")
+	}
+	if !campaign.EndsAt.After(campaign.StartsAt) {
+		return fmt.Errorf("campaign %s must end after it starts", campaign.ID)
+	}
+	if campaign.Budget <= 0 {
+		return fmt.Errorf("invalid budget for campaign %s: %.2f", campaign.ID, campaign.Budget)
+	}
+	if (campaign.Percentage <= 0) == (campaign.Amount <= 0) {
+		return fmt.Errorf("campaign %s needs either a percentage or an amount", campaign.ID)
+	}
+	if campaign.Percentage > 100 {
+		return fmt.Errorf("invalid percentage for campaign %s: %.2f", campaign.ID, campaign.Percentage)
+	}
+
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	if _, exists := s.campaigns[campaign.ID]; exists {
+		return fmt.Errorf("campaign already exists: %s", campaign.ID)
+	}
+
+	stored := campaign.Clone()
+	stored.TenantID = s.tenantID
+	stored.Spent = 0
+	stored.Redemptions = 0
+	s.campaigns[campaign.ID] = stored
+	return nil
+}
+
+func (s *DiscountService) GetCampaign(campaignID string) (*model.Campaign, error) {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	campaign, exists := s.campaigns[campaignID]
+	if !exists {
+		return nil, fmt.Errorf("campaign not found: %s", campaignID)
+	}
+
+	return campaign.Clone(), nil
+}
+
+func (s *DiscountService) CampaignReport(campaignID string) (*model.CampaignReport, error) {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	campaign, exists := s.campaigns[campaignID]
+	if !exists {
+		return nil, fmt.Errorf("campaign not found: %s", campaignID)
+	}
+
+	report := campaignReport(campaign, time.Now())
+	return &report, nil
+}
+
+// CampaignReports returns a report for every campaign, ordered by ID.
+func (s *DiscountService) CampaignReports() []model.CampaignReport {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	now := time.Now()
+	reports := make([]model.CampaignReport, 0, len(s.campaigns))
+	for _, campaign := range s.campaigns {
+		reports = append(reports, campaignReport(campaign, now))
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CampaignID < reports[j].CampaignID
+	})
+
+	return reports
+}
+
+// drawCampaign picks the active campaign giving the user the largest
+// discount on amount that its remaining budget still covers, and charges
+// the budget in the same critical section. The discount is limited to
+// headroom, what the cap leaves, and only that much is charged. Campaigns
+// whose budget cannot cover the discount are refused.
+func (s *DiscountService) drawCampaign(userID string, segments []string, amount, headroom float64) (*model.Campaign, float64) {
+	headroom = floorCents(headroom)
+	if amount <= 0 || headroom <= 0 {
+		return nil, 0
+	}
+
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	now := time.Now()
+	var best *model.Campaign
+	bestAmount := 0.0
+	for _, campaign := range s.campaigns {
+		if !campaign.IsActive(now) || !campaignEligible(campaign, userID, segments) {
+			continue
+		}
+
+		discount := math.Min(roundCents(campaign.DiscountFor(amount)), headroom)
+		if discount <= 0 || discount > campaign.RemainingBudget()+refundTolerance {
+			continue
+		}
+
+		if best == nil || discount > bestAmount || (discount == bestAmount && campaign.ID < best.ID) {
+			best, bestAmount = campaign, discount
+		}
+	}
+
+	if best == nil {
+		return nil, 0
+	}
+
+	best.Spent += bestAmount
+	best.Redemptions++
+	return best.Clone(), bestAmount
+}
+
+// redrawCampaign reprices a campaign's share of a modified order, charging
+// or returning the difference to the budget. The share is limited to
+// headroom and to what the budget still covers; a share that drops to zero
+// gives the redemption back.
+func (s *DiscountService) redrawCampaign(campaignID string, previous, amount, headroom float64) (*model.Campaign, float64) {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	campaign, exists := s.campaigns[campaignID]
+	if !exists {
+		return nil, 0
+	}
+
+	discount := math.Min(roundCents(campaign.DiscountFor(amount)), floorCents(headroom))
+	discount = math.Min(discount, floorCents(campaign.RemainingBudget()+previous))
+	if amount <= 0 || discount <= 0 {
+		campaign.Spent -= previous
+		campaign.Redemptions--
+		return nil, 0
+	}
+
+	campaign.Spent += discount - previous
+	return campaign.Clone(), discount
+}
+
+// returnToCampaign gives a removed discount's share back to the budget, or
+// takes it again when the discount is restored.
+func (s *DiscountService) returnToCampaign(campaignID string, amount float64, redemptions int) {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+
+	campaign, exists := s.campaigns[campaignID]
+	if !exists {
+		return
+	}
+
+	campaign.Spent -= amount
+	campaign.Redemptions -= redemptions
+}
+
+func campaignEligible(campaign *model.Campaign, userID string, segments []string) bool {
+	if len(campaign.EligibleUsers) == 0 && len(campaign.Segments) == 0 {
+		return true
+	}
+
+	for _, eligible := range campaign.EligibleUsers {
+		if eligible == userID {
+			return true
+		}
+	}
+	for _, segment := range campaign.Segments {
+		for _, userSegment := range segments {
+			if segment == userSegment {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func campaignReport(campaign *model.Campaign, now time.Time) model.CampaignReport {
+	return model.CampaignReport{
+		CampaignID:      campaign.ID,
+		Name:            campaign.Name,
+		Active:          campaign.IsActive(now),
+		Redemptions:     campaign.Redemptions,
+		Spent:           campaign.Spent,
+		RemainingBudget: campaign.RemainingBudget(),
+	}
+}
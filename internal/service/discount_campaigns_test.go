@@ -0,0 +1,145 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+)
+
+func TestDiscountService_CampaignBudget(t *testing.T) {
+	service := NewDiscountService()
+	err := service.CreateCampaign(model.Campaign{
+		ID:       "black-friday",
+		Name:     "Black Friday",
+		StartsAt: time.Now().Add(-time.Hour),
+		EndsAt:   time.Now().Add(time.Hour),
+		Amount:   30.0,
+		Budget:   70.0,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	first, _ := service.ApplyDiscount("order1", "user3", itemsWorth(100.0))
+	if first == nil || first.CampaignID != "black-friday" || first.Amount != 30.0 {
+		t.Fatalf("Expected 30.00 from the campaign, got %+v", first)
+	}
+
+	service.ApplyDiscount("order2", "user3", itemsWorth(100.0))
+	third, _ := service.ApplyDiscount("order3", "user3", itemsWorth(100.0))
+	if third != nil {
+		t.Errorf("Expected campaign to be refused once its budget is exhausted, got %.2f", third.Amount)
+	}
+
+	report, _ := service.CampaignReport("black-friday")
+	if report.Redemptions != 2 || report.Spent != 60.0 || report.RemainingBudget != 10.0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	service.RemoveDiscount(first.ID)
+	report, _ = service.CampaignReport("black-friday")
+	if report.Redemptions != 1 || report.RemainingBudget != 40.0 {
+		t.Errorf("Expected removal to return the amount to the budget, got %+v", report)
+	}
+}
+
+func TestDiscountService_CampaignEligibility(t *testing.T) {
+	service := NewDiscountService()
+	service.SetUserSegments("user4", "students")
+	service.CreateCampaign(model.Campaign{
+		ID:            "back-to-school",
+		StartsAt:      time.Now().Add(-time.Hour),
+		EndsAt:        time.Now().Add(time.Hour),
+		EligibleUsers: []string{"user3"},
+		Segments:      []string{"students"},
+		Percentage:    10.0,
+		Budget:        1000.0,
+	})
+	service.CreateCampaign(model.Campaign{
+		ID:         "next-week",
+		StartsAt:   time.Now().Add(7 * 24 * time.Hour),
+		EndsAt:     time.Now().Add(8 * 24 * time.Hour),
+		Percentage: 50.0,
+		Budget:     1000.0,
+	})
+
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 200.0}}
+
+	for _, userID := range []string{"user3", "user4"} {
+		discount, _ := service.ApplyDiscount("order-"+userID, userID, items)
+		if discount == nil || discount.CampaignAmount != 20.0 {
+			t.Errorf("Expected %s to get 20.00 from the campaign, got %+v", userID, discount)
+		}
+	}
+
+	if discount, _ := service.ApplyDiscount("order-user5", "user5", items); discount != nil {
+		t.Errorf("Expected no campaign for ineligible user, got %.2f", discount.Amount)
+	}
+
+	reports := service.CampaignReports()
+	if len(reports) != 2 || reports[0].CampaignID != "back-to-school" || reports[1].Active {
+		t.Errorf("Unexpected reports: %+v", reports)
+	}
+}
+
+func TestDiscountService_CampaignConcurrentDraw(t *testing.T) {
+	service := NewDiscountService()
+	service.CreateCampaign(model.Campaign{
+		ID:       "flash",
+		StartsAt: time.Now().Add(-time.Hour),
+		EndsAt:   time.Now().Add(time.Hour),
+		Amount:   10.0,
+		Budget:   100.0,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			service.ApplyDiscount(fmt.Sprintf("order%d", index), "user3", itemsWorth(50.0))
+		}(i)
+	}
+	wg.Wait()
+
+	campaign, _ := service.GetCampaign("flash")
+	if campaign.Redemptions != 10 || campaign.Spent != 100.0 {
+		t.Errorf("Expected exactly 10 redemptions within budget, got %d spending %.2f", campaign.Redemptions, campaign.Spent)
+	}
+}
+
+func TestDiscountService_CampaignWithinCap(t *testing.T) {
+	service := NewDiscountService()
+	service.SetDiscountCap(model.DiscountCap{Amount: 20.0})
+	service.AddRule(model.DiscountRule{
+		ID:       "ten-off",
+		Stacking: model.StackingCumulative,
+		Action:   model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 10.0},
+	})
+	service.CreateCampaign(model.Campaign{
+		ID:       "flash",
+		StartsAt: time.Now().Add(-time.Hour),
+		EndsAt:   time.Now().Add(time.Hour),
+		Amount:   30.0,
+		Budget:   100.0,
+	})
+
+	discount, _ := service.ApplyDiscount("order1", "user3", itemsWorth(100.0))
+	if discount == nil || discount.Amount != 20.0 || discount.CampaignAmount != 10.0 {
+		t.Fatalf("Expected the campaign to fill the cap with 10.00 for 20.00 in total, got %+v", discount)
+	}
+
+	service.SetDiscountCap(model.DiscountCap{Percentage: 10.0})
+	discount, _ = service.ApplyDiscount("order2", "user3", itemsWorth(100.0))
+	if discount == nil || discount.Amount != 10.0 || discount.CampaignID != "" {
+		t.Fatalf("Expected no campaign once the rules reach the cap, got %+v", discount)
+	}
+
+	campaign, _ := service.GetCampaign("flash")
+	if campaign.Redemptions != 1 || campaign.Spent != 10.0 {
+		t.Errorf("Expected the budget to be charged the capped 10.00 once, got %d spending %.2f", campaign.Redemptions, campaign.Spent)
+	}
+}
@@ -0,0 +1,511 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"homework/internal/model"
+)
+
+const userDiscountRuleID = "user_discount"
+
+// SegmentSource tells which segments a user belongs to beyond those set on
+// the discount service, e.g. from the user's profile.
+type SegmentSource interface {
+	UserSegments(userID string) []string
+}
+
+// evaluation is what the discount rules grant an order before any campaign:
+// the applied rules, the discount rate, what is left of each line and of
+// the subtotal, and how much more the cap allows.
+type evaluation struct {
+	ctx       *ruleContext
+	segments  []string
+	applied   []model.AppliedRule
+	rate      float64
+	remaining []float64
+	left      float64
+	headroom  float64
+}
+
+// ruleContext is what rule conditions are evaluated against.
+type ruleContext struct {
+	items      []model.OrderItem
+	lineTotals []float64
+	subtotal   float64
+	segments   map[string]bool
+	categories map[string]string
+	now        time.Time
+}
+
+func (s *DiscountService) AddRule(rule model.DiscountRule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("discount rule ID is required")
+	}
+
+	switch rule.Stacking {
+	case model.StackingExclusive, model.StackingBestOf, model.StackingCumulative:
+	default:
+		return fmt.Errorf("unknown stacking policy for rule %s: %s", rule.ID, rule.Stacking)
+	}
+
+	action := rule.Action
+	switch action.Type {
+	case model.RuleActionPercentOff:
+		if action.Percentage <= 0 || action.Percentage > 100 {
+			return fmt.Errorf("invalid percentage for rule %s: %.2f", rule.ID, action.Percentage)
+		}
+	case model.RuleActionFixedOff:
+		if action.Amount <= 0 {
+			return fmt.Errorf("invalid amount for rule %s: %.2f", rule.ID, action.Amount)
+		}
+	case model.RuleActionBuyXGetY:
+		if action.BuyQuantity <= 0 || action.GetQuantity <= 0 {
+			return fmt.Errorf("invalid buy %d get %d for rule %s", action.BuyQuantity, action.GetQuantity, rule.ID)
+		}
+	case model.RuleActionFreeItem:
+		if action.FreeProductID == "" || action.FreeQuantity <= 0 {
+			return fmt.Errorf("invalid free item for rule %s", rule.ID)
+		}
+	default:
+		return fmt.Errorf("unknown action for rule %s: %s", rule.ID, action.Type)
+	}
+
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+
+	if _, exists := s.rules[rule.ID]; exists {
+		return fmt.Errorf("discount rule already exists: %s", rule.ID)
+	}
+
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *DiscountService) RemoveRule(ruleID string) error {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+
+	if _, exists := s.rules[ruleID]; !exists {
+		return fmt.Errorf("discount rule not found: %s", ruleID)
+	}
+
+	delete(s.rules, ruleID)
+	return nil
+}
+
+func (s *DiscountService) SetDiscountCap(discountCap model.DiscountCap) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	s.discountCap = discountCap
+}
+
+// SetPromoCodesDisabled turns promo code redemption off or back on.
+func (s *DiscountService) SetPromoCodesDisabled(disabled bool) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	s.promosDisabled = disabled
+}
+
+func (s *DiscountService) SetProductCategory(productID, category string) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	s.categories[productID] = category
+}
+
+// SetSegmentSource makes discount evaluation also use the segments source
+// reports for the user.
+func (s *DiscountService) SetSegmentSource(source SegmentSource) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+	s.segmentSource = source
+}
+
+func (s *DiscountService) SetUserSegments(userID string, segments ...string) {
+	shard := s.users[shardIndex(userID)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.segments[userID] = append([]string(nil), segments...)
+}
+
+// ApplyDiscount evaluates the discount rules against the order lines and
+// stores the resulting discount, allocated per line in cents. The user's
+// standing percentage takes part as a cumulative rule of priority zero, and
+// the best eligible campaign is applied last to what the rules left, up to
+// what the cap still allows. Orders nothing applies to get nil.
+func (s *DiscountService) ApplyDiscount(orderID, userID string, items []model.OrderItem) (*model.Discount, error) {
+	eval := s.evaluate(userID, items)
+
+	discount := &model.Discount{
+		ID:      uuid.New().String(),
+		UserID:  userID,
+		OrderID: orderID,
+	}
+
+	if campaign, amount := s.drawCampaign(userID, eval.segments, eval.left, eval.headroom); campaign != nil {
+		eval.addCampaign(campaign, amount)
+		discount.CampaignID = campaign.ID
+		discount.CampaignAmount = amount
+	}
+
+	if len(eval.applied) == 0 {
+		return nil, nil
+	}
+
+	eval.apply(discount)
+	s.storeDiscount(discount)
+	return discount.Clone(), nil
+}
+
+// evaluate runs the discount rules, with the user's standing percentage as a
+// cumulative rule of priority zero, against the order lines.
+func (s *DiscountService) evaluate(userID string, items []model.OrderItem) *evaluation {
+	users := s.users[shardIndex(userID)]
+	users.mu.RLock()
+	percentage, hasDiscount := users.userDiscounts[userID]
+	segments := users.segments[userID]
+	users.mu.RUnlock()
+
+	s.rulesMu.RLock()
+	rules := make([]model.DiscountRule, 0, len(s.rules)+1)
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	categories := make(map[string]string, len(s.categories))
+	for productID, category := range s.categories {
+		categories[productID] = category
+	}
+	discountCap := s.discountCap
+	source := s.segmentSource
+	s.rulesMu.RUnlock()
+
+	if source != nil {
+		segments = append(append([]string(nil), segments...), source.UserSegments(userID)...)
+	}
+
+	if hasDiscount && percentage > 0 {
+		rules = append(rules, model.DiscountRule{
+			ID:       userDiscountRuleID,
+			Name:     "user discount",
+			Stacking: model.StackingCumulative,
+			Action:   model.RuleAction{Type: model.RuleActionPercentOff, Percentage: percentage},
+		})
+	}
+
+	ctx := newRuleContext(items, segments, categories, time.Now())
+	applied, rate := evaluateRules(rules, ctx, discountCap)
+
+	eval := &evaluation{
+		ctx:       ctx,
+		segments:  segments,
+		applied:   applied,
+		rate:      rate,
+		remaining: append([]float64(nil), ctx.lineTotals...),
+		left:      ctx.subtotal,
+	}
+	for _, rule := range applied {
+		for _, allocation := range rule.Allocations {
+			eval.remaining[allocation.Line] -= allocation.Amount
+			eval.left -= allocation.Amount
+		}
+	}
+	eval.headroom = capLimit(discountCap, ctx.subtotal) - (ctx.subtotal - eval.left)
+
+	return eval
+}
+
+// addCampaign applies amount from the campaign to what the rules left of
+// the order lines.
+func (e *evaluation) addCampaign(campaign *model.Campaign, amount float64) {
+	allLines := make([]int, len(e.ctx.items))
+	for i := range allLines {
+		allLines[i] = i
+	}
+
+	allocations := roundAllocations(e.ctx.allocate(model.RuleAction{Type: model.RuleActionFixedOff, Amount: amount}, allLines, e.remaining))
+	e.applied = append(e.applied, model.AppliedRule{
+		RuleID:      campaign.ID,
+		Name:        campaign.Name,
+		Amount:      amount,
+		Allocations: allocations,
+	})
+}
+
+// apply sets the discount's amount, applied rules and line allocations.
+func (e *evaluation) apply(discount *model.Discount) {
+	discount.Percentage = e.rate
+	discount.AppliedRules = e.applied
+	discount.Lines = sumByLine(e.applied)
+	discount.Amount = 0
+	for _, rule := range e.applied {
+		discount.Amount = roundCents(discount.Amount + rule.Amount)
+	}
+}
+
+func newRuleContext(items []model.OrderItem, segments []string, categories map[string]string, now time.Time) *ruleContext {
+	ctx := &ruleContext{
+		items:      items,
+		lineTotals: make([]float64, len(items)),
+		segments:   make(map[string]bool, len(segments)),
+		categories: categories,
+		now:        now,
+	}
+
+	for i, item := range items {
+		ctx.lineTotals[i] = item.Price * float64(item.Quantity)
+		ctx.subtotal += ctx.lineTotals[i]
+	}
+	for _, segment := range segments {
+		ctx.segments[segment] = true
+	}
+
+	return ctx
+}
+
+// evaluateRules picks the rules to apply according to their stacking
+// policies, allocates each over the order lines without discounting a line
+// below zero, and scales everything down to the cap. It also returns the
+// discount rate over the subtotal.
+func evaluateRules(rules []model.DiscountRule, ctx *ruleContext, discountCap model.DiscountCap) ([]model.AppliedRule, float64) {
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+
+	var matching []model.DiscountRule
+	lines := make(map[string][]int)
+	for _, rule := range rules {
+		if eligible, ok := ctx.match(rule.Conditions); ok {
+			matching = append(matching, rule)
+			lines[rule.ID] = eligible
+		}
+	}
+	if len(matching) == 0 {
+		return nil, 0
+	}
+
+	var selected []model.DiscountRule
+	if matching[0].Stacking == model.StackingExclusive {
+		selected = matching[:1]
+	} else {
+		var best *model.DiscountRule
+		bestAmount := 0.0
+		for i, rule := range matching {
+			switch rule.Stacking {
+			case model.StackingCumulative:
+				selected = append(selected, rule)
+			case model.StackingBestOf:
+				amount := sumAllocations(ctx.allocate(rule.Action, lines[rule.ID], append([]float64(nil), ctx.lineTotals...)))
+				if best == nil || amount > bestAmount {
+					best, bestAmount = &matching[i], amount
+				}
+			}
+		}
+		if best != nil {
+			selected = append(selected, *best)
+			sort.SliceStable(selected, func(i, j int) bool {
+				return selected[i].Priority > selected[j].Priority
+			})
+		}
+	}
+
+	remaining := append([]float64(nil), ctx.lineTotals...)
+	var applied []model.AppliedRule
+	total := 0.0
+	uniform := true
+	rate := 0.0
+	for _, rule := range selected {
+		allocations := ctx.allocate(rule.Action, lines[rule.ID], remaining)
+		amount := sumAllocations(allocations)
+		if amount <= 0 {
+			continue
+		}
+
+		applied = append(applied, model.AppliedRule{
+			RuleID:      rule.ID,
+			Name:        rule.Name,
+			Amount:      amount,
+			Allocations: allocations,
+		})
+		total += amount
+
+		if rule.Action.Type == model.RuleActionPercentOff && len(lines[rule.ID]) == len(ctx.items) {
+			rate += rule.Action.Percentage
+		} else {
+			uniform = false
+		}
+	}
+
+	if limit := capLimit(discountCap, ctx.subtotal); limit < total {
+		scale := limit / total
+		for i := range applied {
+			applied[i].Amount = 0
+			for j := range applied[i].Allocations {
+				applied[i].Allocations[j].Amount *= scale
+				applied[i].Amount += applied[i].Allocations[j].Amount
+			}
+		}
+		uniform = false
+	}
+
+	total = 0
+	for i := range applied {
+		applied[i].Allocations = roundAllocations(applied[i].Allocations)
+		applied[i].Amount = roundCents(sumAllocations(applied[i].Allocations))
+		total += applied[i].Amount
+	}
+
+	if !uniform && ctx.subtotal > 0 {
+		rate = total / ctx.subtotal * 100.0
+	}
+
+	return applied, rate
+}
+
+// capLimit is the most the cap allows off an order of subtotal.
+func capLimit(discountCap model.DiscountCap, subtotal float64) float64 {
+	limit := subtotal
+	if discountCap.Amount > 0 && discountCap.Amount < limit {
+		limit = discountCap.Amount
+	}
+	if discountCap.Percentage > 0 && subtotal*discountCap.Percentage/100.0 < limit {
+		limit = subtotal * discountCap.Percentage / 100.0
+	}
+	return limit
+}
+
+// match reports whether the conditions hold and which lines they select.
+func (c *ruleContext) match(conditions model.RuleConditions) ([]int, bool) {
+	if len(conditions.Segments) > 0 {
+		member := false
+		for _, segment := range conditions.Segments {
+			member = member || c.segments[segment]
+		}
+		if !member {
+			return nil, false
+		}
+	}
+
+	if len(conditions.Weekdays) > 0 {
+		today := false
+		for _, weekday := range conditions.Weekdays {
+			today = today || c.now.Weekday() == weekday
+		}
+		if !today {
+			return nil, false
+		}
+	}
+
+	if c.subtotal < conditions.MinOrderTotal {
+		return nil, false
+	}
+
+	var eligible []int
+	quantity := 0
+	for i, item := range c.items {
+		if !lineSelected(conditions, item.ProductID, c.categories[item.ProductID]) {
+			continue
+		}
+		eligible = append(eligible, i)
+		quantity += item.Quantity
+	}
+
+	if len(eligible) == 0 || quantity < conditions.MinQuantity {
+		return nil, false
+	}
+
+	return eligible, true
+}
+
+func lineSelected(conditions model.RuleConditions, productID, category string) bool {
+	if len(conditions.ProductIDs) == 0 && len(conditions.Categories) == 0 {
+		return true
+	}
+	for _, id := range conditions.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	for _, c := range conditions.Categories {
+		if category != "" && c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate computes the action's discount on each eligible line, limited to
+// what is left of the line in remaining, and takes it off remaining.
+func (c *ruleContext) allocate(action model.RuleAction, lines []int, remaining []float64) []model.LineAllocation {
+	amounts := make(map[int]float64, len(lines))
+
+	switch action.Type {
+	case model.RuleActionPercentOff:
+		for _, line := range lines {
+			amounts[line] = c.lineTotals[line] * action.Percentage / 100.0
+		}
+	case model.RuleActionFixedOff:
+		available := 0.0
+		for _, line := range lines {
+			available += remaining[line]
+		}
+		if available > 0 {
+			for _, line := range lines {
+				amounts[line] = action.Amount * remaining[line] / available
+			}
+		}
+	case model.RuleActionBuyXGetY:
+		group := action.BuyQuantity + action.GetQuantity
+		for _, line := range lines {
+			free := c.items[line].Quantity / group * action.GetQuantity
+			amounts[line] = float64(free) * c.items[line].Price
+		}
+	case model.RuleActionFreeItem:
+		left := action.FreeQuantity
+		for _, line := range lines {
+			if left == 0 {
+				break
+			}
+			if c.items[line].ProductID != action.FreeProductID {
+				continue
+			}
+			free := c.items[line].Quantity
+			if free > left {
+				free = left
+			}
+			amounts[line] = float64(free) * c.items[line].Price
+			left -= free
+		}
+	}
+
+	var allocations []model.LineAllocation
+	for _, line := range lines {
+		amount := amounts[line]
+		if amount > remaining[line] {
+			amount = remaining[line]
+		}
+		if amount <= 0 {
+			continue
+		}
+		remaining[line] -= amount
+		allocations = append(allocations, model.LineAllocation{
+			Line:      line,
+			ProductID: c.items[line].ProductID,
+			Amount:    amount,
+		})
+	}
+
+	return allocations
+}
+
+func sumAllocations(allocations []model.LineAllocation) float64 {
+	total := 0.0
+	for _, allocation := range allocations {
+		total += allocation.Amount
+	}
+	return total
+}
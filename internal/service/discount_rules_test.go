@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+)
+
+func TestDiscountService_ApplyDiscount_CumulativeAllocations(t *testing.T) {
+	service := NewDiscountService()
+	service.SetUserDiscount("user1", 10.0)
+	service.SetProductCategory("book1", "books")
+	service.AddRule(model.DiscountRule{
+		ID:         "books-20",
+		Name:       "20% off books",
+		Priority:   10,
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{Categories: []string{"books"}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 20.0},
+	})
+
+	items := []model.OrderItem{
+		{ProductID: "book1", Quantity: 2, Price: 50.0},
+		{ProductID: "pen1", Quantity: 1, Price: 100.0},
+	}
+
+	discount, err := service.ApplyDiscount("order1", "user1", items)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(discount.AppliedRules) != 2 {
+		t.Fatalf("Expected books rule and user discount, got %d rules", len(discount.AppliedRules))
+	}
+
+	// 20.00 off the books line, then the user's 10% of each line.
+	if discount.Amount != 40.0 {
+		t.Errorf("Expected discount 40.00, got %.2f", discount.Amount)
+	}
+
+	if len(discount.Lines) != 2 || discount.Lines[0].Amount != 30.0 || discount.Lines[1].Amount != 10.0 {
+		t.Errorf("Unexpected line allocations: %v", discount.Lines)
+	}
+
+	stored, _ := service.GetDiscountByOrderID("order1")
+	if len(stored.AppliedRules) != 2 {
+		t.Errorf("Expected applied rules to be stored, got %d", len(stored.AppliedRules))
+	}
+}
+
+func TestDiscountService_ApplyDiscount_StackingPolicies(t *testing.T) {
+	service := NewDiscountService()
+	service.AddRule(model.DiscountRule{
+		ID:       "fixed-15",
+		Priority: 5,
+		Stacking: model.StackingBestOf,
+		Action:   model.RuleAction{Type: model.RuleActionFixedOff, Amount: 15.0},
+	})
+	service.AddRule(model.DiscountRule{
+		ID:       "percent-5",
+		Priority: 5,
+		Stacking: model.StackingBestOf,
+		Action:   model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 5.0},
+	})
+
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 2, Price: 100.0}}
+
+	discount, _ := service.ApplyDiscount("order1", "user3", items)
+	if len(discount.AppliedRules) != 1 || discount.AppliedRules[0].RuleID != "fixed-15" {
+		t.Fatalf("Expected only the best rule to apply, got %+v", discount.AppliedRules)
+	}
+
+	service.AddRule(model.DiscountRule{
+		ID:         "vip",
+		Priority:   100,
+		Stacking:   model.StackingExclusive,
+		Conditions: model.RuleConditions{Segments: []string{"vip"}, MinOrderTotal: 150.0},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 30.0},
+	})
+
+	discount, _ = service.ApplyDiscount("order2", "user3", items)
+	if discount.AppliedRules[0].RuleID == "vip" {
+		t.Error("Expected vip rule not to apply outside the segment")
+	}
+
+	service.SetUserSegments("user3", "vip")
+	discount, _ = service.ApplyDiscount("order3", "user3", items)
+	if len(discount.AppliedRules) != 1 || discount.Amount != 60.0 {
+		t.Errorf("Expected exclusive vip rule alone for 60.00, got %.2f from %d rules",
+			discount.Amount, len(discount.AppliedRules))
+	}
+}
+
+func TestDiscountService_ApplyDiscount_ProfileSegments(t *testing.T) {
+	users := NewUserService()
+	users.RegisterUser("user3", model.UserProfile{Name: "Dasha", Email: "dasha@example.com", Segment: "vip"})
+	users.RegisterUser("user4", model.UserProfile{Name: "Nastya", Email: "nastya@example.com"})
+
+	service := NewDiscountService()
+	service.SetSegmentSource(users)
+	service.AddRule(model.DiscountRule{
+		ID:         "vip",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{Segments: []string{"vip"}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 30.0},
+	})
+
+	discount, _ := service.ApplyDiscount("order1", "user3", itemsWorth(200.0))
+	if discount == nil || discount.Amount != 60.0 {
+		t.Fatalf("Expected the profile segment to earn 60.00, got %+v", discount)
+	}
+
+	if discount, _ := service.ApplyDiscount("order2", "user4", itemsWorth(200.0)); discount != nil {
+		t.Errorf("Expected no discount outside the segment, got %.2f", discount.Amount)
+	}
+
+	users.UpdateProfile("user4", model.UserProfile{Name: "Nastya", Email: "nastya@example.com", Segment: "vip"})
+	if discount, _ := service.ApplyDiscount("order3", "user4", itemsWorth(200.0)); discount == nil || discount.Amount != 60.0 {
+		t.Errorf("Expected the updated profile segment to earn 60.00, got %+v", discount)
+	}
+}
+
+func TestDiscountService_ApplyDiscount_ItemActionsAndCap(t *testing.T) {
+	service := NewDiscountService()
+	service.AddRule(model.DiscountRule{
+		ID:         "socks-3-for-2",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{ProductIDs: []string{"socks"}, MinQuantity: 3},
+		Action:     model.RuleAction{Type: model.RuleActionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+	})
+	service.AddRule(model.DiscountRule{
+		ID:       "free-sticker",
+		Stacking: model.StackingCumulative,
+		Action:   model.RuleAction{Type: model.RuleActionFreeItem, FreeProductID: "sticker", FreeQuantity: 1},
+	})
+
+	items := []model.OrderItem{
+		{ProductID: "socks", Quantity: 7, Price: 10.0},
+		{ProductID: "sticker", Quantity: 3, Price: 2.0},
+	}
+
+	discount, _ := service.ApplyDiscount("order1", "user3", items)
+	if discount.Amount != 22.0 {
+		t.Errorf("Expected 2 free socks and 1 free sticker worth 22.00, got %.2f", discount.Amount)
+	}
+
+	service.SetDiscountCap(model.DiscountCap{Amount: 11.0})
+	discount, _ = service.ApplyDiscount("order2", "user3", items)
+	if discount.Amount != 11.0 {
+		t.Errorf("Expected discount capped at 11.00, got %.2f", discount.Amount)
+	}
+
+	if len(discount.Lines) != 2 || discount.Lines[0].Amount != 10.0 || discount.Lines[1].Amount != 1.0 {
+		t.Errorf("Expected cap to scale allocations proportionally, got %v", discount.Lines)
+	}
+}
+
+func TestDiscountService_ApplyDiscount_Weekday(t *testing.T) {
+	service := NewDiscountService()
+	tomorrow := time.Now().Add(24 * time.Hour).Weekday()
+	service.AddRule(model.DiscountRule{
+		ID:         "tomorrow-only",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{Weekdays: []time.Weekday{tomorrow}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 50.0},
+	})
+
+	discount, err := service.ApplyDiscount("order1", "user3", []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 100.0}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if discount != nil {
+		t.Errorf("Expected no discount on another weekday, got %.2f", discount.Amount)
+	}
+}
+
+func TestDiscountService_ApplyDiscount_RoundsLinesDeterministically(t *testing.T) {
+	service := NewDiscountService()
+	service.AddRule(model.DiscountRule{
+		ID:       "fixed-10",
+		Stacking: model.StackingCumulative,
+		Action:   model.RuleAction{Type: model.RuleActionFixedOff, Amount: 10.0},
+	})
+
+	items := []model.OrderItem{
+		{ProductID: "a", Quantity: 1, Price: 30.0},
+		{ProductID: "b", Quantity: 1, Price: 30.0},
+		{ProductID: "c", Quantity: 1, Price: 30.0},
+	}
+
+	for i := 0; i < 5; i++ {
+		discount, err := service.ApplyDiscount(fmt.Sprintf("order%d", i), "user3", items)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []float64{3.34, 3.33, 3.33}
+		if len(discount.Lines) != len(expected) {
+			t.Fatalf("Expected %d lines, got %v", len(expected), discount.Lines)
+		}
+		for j, line := range discount.Lines {
+			if line.Line != j || line.Amount != expected[j] {
+				t.Errorf("Expected line %d to get %.2f, got %v", j, expected[j], line)
+			}
+		}
+		if discount.Amount != 10.0 {
+			t.Errorf("Expected lines to add up to 10.00, got %.2f", discount.Amount)
+		}
+	}
+}
+
+func TestDiscountService_RecalculateDiscount_KeepsRuleAllocations(t *testing.T) {
+	service := NewDiscountService()
+	service.AddRule(model.DiscountRule{
+		ID:         "product2-half-price",
+		Stacking:   model.StackingCumulative,
+		Conditions: model.RuleConditions{ProductIDs: []string{"product2"}},
+		Action:     model.RuleAction{Type: model.RuleActionPercentOff, Percentage: 50.0},
+	})
+
+	service.ApplyDiscount("order1", "user3", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+		{ProductID: "product2", Quantity: 1, Price: 100.0},
+	})
+
+	discount, err := service.RecalculateDiscount("order1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 3, Price: 100.0},
+		{ProductID: "product2", Quantity: 1, Price: 100.0},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The rule only covers product2, so product1 gets nothing however much
+	// of the subtotal it makes up.
+	if discount.Amount != 50.0 || discount.Percentage != 12.5 {
+		t.Errorf("Expected 50.00 off, 12.5%% of the subtotal, got %.2f at %.2f%%", discount.Amount, discount.Percentage)
+	}
+	if len(discount.Lines) != 1 || discount.Lines[0].Line != 1 || discount.Lines[0].Amount != 50.0 {
+		t.Errorf("Expected the discount on the product2 line only, got %v", discount.Lines)
+	}
+}
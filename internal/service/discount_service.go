@@ -3,79 +3,378 @@ package service
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"homework/internal/model"
 )
 
 type DiscountService struct {
+	tenantID  string
+	users     [shardCount]*userDiscountShard
+	discounts [shardCount]*discountShard
+	byOrder   [shardCount]*orderDiscountIndex
+	promos    [shardCount]*promoShard
+
+	rulesMu        sync.RWMutex
+	rules          map[string]model.DiscountRule
+	categories     map[string]string
+	discountCap    model.DiscountCap
+	promosDisabled bool
+	segmentSource  SegmentSource
+
+	campaignMu sync.Mutex
+	campaigns  map[string]*model.Campaign
+}
+
+type userDiscountShard struct {
+	mu            sync.RWMutex
+	userDiscounts map[string]float64
+	segments      map[string][]string
+}
+
+type discountShard struct {
 	mu        sync.RWMutex
 	discounts map[string]*model.Discount
-	userDiscounts map[string]float64
+}
+
+type orderDiscountIndex struct {
+	mu        sync.RWMutex
+	discounts map[string]string
+}
+
+type promoShard struct {
+	mu    sync.RWMutex
+	codes map[string]*model.PromoCode
 }
 
 func NewDiscountService() *DiscountService {
+	return NewDiscountServiceForTenant("")
+}
+
+// NewDiscountServiceForTenant returns a service holding the discounts, promo
+// codes, rules and campaigns of one tenant. It starts with no user discounts.
+func NewDiscountServiceForTenant(tenantID string) *DiscountService {
 	service := &DiscountService{
-		discounts:     make(map[string]*model.Discount),
-		userDiscounts: make(map[string]float64),
+		tenantID:   tenantID,
+		rules:      make(map[string]model.DiscountRule),
+		categories: make(map[string]string),
+		campaigns:  make(map[string]*model.Campaign),
 	}
 
-	service.userDiscounts["user1"] = 10.0
-	service.userDiscounts["user2"] = 15.0
+	for i := 0; i < shardCount; i++ {
+		service.users[i] = &userDiscountShard{
+			userDiscounts: make(map[string]float64),
+			segments:      make(map[string][]string),
+		}
+		service.discounts[i] = &discountShard{discounts: make(map[string]*model.Discount)}
+		service.byOrder[i] = &orderDiscountIndex{discounts: make(map[string]string)}
+		service.promos[i] = &promoShard{codes: make(map[string]*model.PromoCode)}
+	}
 
 	return service
 }
 
 func (s *DiscountService) SetUserDiscount(userID string, percentage float64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.userDiscounts[userID] = percentage
+	shard := s.users[shardIndex(userID)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.userDiscounts[userID] = percentage
 }
 
-func (s *DiscountService) ApplyDiscount(orderID, userID string, totalAmount float64) (*model.Discount, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *DiscountService) CreatePromoCode(promo model.PromoCode) error {
+	if promo.Code == "" {
+		return fmt.Errorf("promo code is required")
+	}
 
-	discountPercentage, hasDiscount := s.userDiscounts[userID]
-	if !hasDiscount {
-		return nil, nil
+	switch promo.Type {
+	case model.PromoCodePercentage:
+		if promo.Value <= 0 || promo.Value > 100 {
+			return fmt.Errorf("invalid percentage for promo code %s: %.2f", promo.Code, promo.Value)
+		}
+	case model.PromoCodeFixed:
+		if promo.Value <= 0 {
+			return fmt.Errorf("invalid amount for promo code %s: %.2f", promo.Code, promo.Value)
+		}
+	default:
+		return fmt.Errorf("unknown promo code type: %s", promo.Type)
+	}
+
+	shard := s.promos[shardIndex(promo.Code)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, exists := shard.codes[promo.Code]; exists {
+		return fmt.Errorf("promo code already exists: %s", promo.Code)
+	}
+
+	stored := promo.Clone()
+	stored.TenantID = s.tenantID
+	stored.Redemptions = 0
+	stored.RedemptionsByUser = make(map[string]int)
+	shard.codes[promo.Code] = stored
+	return nil
+}
+
+func (s *DiscountService) GetPromoCode(code string) (*model.PromoCode, error) {
+	shard := s.promos[shardIndex(code)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	promo, exists := shard.codes[code]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalidPromoCode, code)
+	}
+
+	return promo.Clone(), nil
+}
+
+// ApplyPromoCode redeems code for the order. The checks and the redemption
+// happen under the code's lock, so concurrent orders cannot exceed its
+// limits. The discount stays within the discount cap. Removing the discount
+// gives the redemption back.
+func (s *DiscountService) ApplyPromoCode(orderID, userID, code string, items []model.OrderItem) (*model.Discount, error) {
+	s.rulesMu.RLock()
+	disabled := s.promosDisabled
+	discountCap := s.discountCap
+	s.rulesMu.RUnlock()
+	if disabled {
+		return nil, fmt.Errorf("%w: promo codes are disabled", ErrInvalidPromoCode)
+	}
+
+	totalAmount := 0.0
+	for _, item := range items {
+		totalAmount += item.Price * float64(item.Quantity)
+	}
+
+	shard := s.promos[shardIndex(code)]
+	shard.mu.Lock()
+
+	promo, exists := shard.codes[code]
+	if !exists {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalidPromoCode, code)
+	}
+
+	if !promo.IsActive(time.Now()) {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s is not active", ErrInvalidPromoCode, code)
+	}
+
+	if totalAmount < promo.MinOrderTotal {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s requires an order total of at least %.2f, got %.2f",
+			ErrInvalidPromoCode, code, promo.MinOrderTotal, totalAmount)
+	}
+
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s has been fully redeemed", ErrInvalidPromoCode, code)
+	}
+
+	if promo.MaxRedemptionsPerUser > 0 && promo.RedemptionsByUser[userID] >= promo.MaxRedemptionsPerUser {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s already redeemed %d times by user %s",
+			ErrInvalidPromoCode, code, promo.RedemptionsByUser[userID], userID)
 	}
 
-	discountAmount := totalAmount * discountPercentage / 100.0
+	promo.Redemptions++
+	promo.RedemptionsByUser[userID]++
 
 	discount := &model.Discount{
-		ID:         uuid.New().String(),
-		UserID:     userID,
-		OrderID:    orderID,
-		Amount:     discountAmount,
-		Percentage: discountPercentage,
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		OrderID:   orderID,
+		Amount:    promoAmount(promo, discountCap, totalAmount),
+		PromoCode: code,
 	}
+	if promo.Type == model.PromoCodePercentage {
+		discount.Percentage = promo.Value
+	}
+	shard.mu.Unlock()
+
+	discount.Lines = proportionalAllocations(items, discount.Amount)
 
-	s.discounts[discount.ID] = discount
-	return discount, nil
+	s.storeDiscount(discount)
+	return discount.Clone(), nil
+}
+
+// RestoreDiscount puts back a removed discount, redeeming its promo code
+// again without re-checking the limits it passed originally.
+func (s *DiscountService) RestoreDiscount(discount *model.Discount) error {
+	if discount.TenantID != s.tenantID {
+		return fmt.Errorf("discount %s belongs to another tenant", discount.ID)
+	}
+	if _, err := s.GetDiscount(discount.ID); err == nil {
+		return fmt.Errorf("discount already exists: %s", discount.ID)
+	}
+
+	if discount.PromoCode != "" {
+		s.adjustRedemptions(discount.PromoCode, discount.UserID, 1)
+	}
+	if discount.CampaignID != "" {
+		s.returnToCampaign(discount.CampaignID, -discount.CampaignAmount, -1)
+	}
+
+	s.storeDiscount(discount.Clone())
+	return nil
 }
 
 func (s *DiscountService) RemoveDiscount(discountID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	shard := s.discounts[shardIndex(discountID)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	_, exists := s.discounts[discountID]
+	discount, exists := shard.discounts[discountID]
 	if !exists {
 		return fmt.Errorf("discount not found: %s", discountID)
 	}
 
-	delete(s.discounts, discountID)
+	delete(shard.discounts, discountID)
+
+	if discount.PromoCode != "" {
+		s.adjustRedemptions(discount.PromoCode, discount.UserID, -1)
+	}
+	if discount.CampaignID != "" {
+		s.returnToCampaign(discount.CampaignID, discount.CampaignAmount, 1)
+	}
+
+	index := s.byOrder[shardIndex(discount.OrderID)]
+	index.mu.Lock()
+	if index.discounts[discount.OrderID] == discountID {
+		delete(index.discounts, discount.OrderID)
+	}
+	index.mu.Unlock()
+
 	return nil
 }
 
 func (s *DiscountService) GetDiscount(discountID string) (*model.Discount, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	shard := s.discounts[shardIndex(discountID)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	discount, exists := s.discounts[discountID]
+	discount, exists := shard.discounts[discountID]
 	if !exists {
 		return nil, fmt.Errorf("discount not found: %s", discountID)
 	}
 
-	return discount, nil
+	return discount.Clone(), nil
+}
+
+func (s *DiscountService) GetDiscountByOrderID(orderID string) (*model.Discount, error) {
+	index := s.byOrder[shardIndex(orderID)]
+	index.mu.RLock()
+	discountID, exists := index.discounts[orderID]
+	index.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("discount not found for order: %s", orderID)
+	}
+
+	return s.GetDiscount(discountID)
+}
+
+// RecalculateDiscount reprices the order's discount for new order lines. A
+// promo code discount is repriced by its code, which the new total must
+// still qualify for. Any other discount is re-evaluated like ApplyDiscount,
+// against the current rules and cap; its campaign, if any, is charged or
+// refunded the change in its share. Orders without a discount yield nil.
+func (s *DiscountService) RecalculateDiscount(orderID string, items []model.OrderItem) (*model.Discount, error) {
+	totalAmount := 0.0
+	for _, item := range items {
+		totalAmount += item.Price * float64(item.Quantity)
+	}
+
+	index := s.byOrder[shardIndex(orderID)]
+	index.mu.RLock()
+	discountID, exists := index.discounts[orderID]
+	index.mu.RUnlock()
+
+	if !exists {
+		return nil, nil
+	}
+
+	shard := s.discounts[shardIndex(discountID)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	discount, exists := shard.discounts[discountID]
+	if !exists {
+		return nil, fmt.Errorf("discount not found: %s", discountID)
+	}
+
+	if discount.PromoCode != "" {
+		promo, err := s.GetPromoCode(discount.PromoCode)
+		if err != nil {
+			return nil, err
+		}
+		if totalAmount < promo.MinOrderTotal {
+			return nil, fmt.Errorf("%w: %s requires an order total of at least %.2f, got %.2f",
+				ErrInvalidPromoCode, promo.Code, promo.MinOrderTotal, totalAmount)
+		}
+
+		s.rulesMu.RLock()
+		discountCap := s.discountCap
+		s.rulesMu.RUnlock()
+
+		discount.Amount = promoAmount(promo, discountCap, totalAmount)
+		discount.Lines = proportionalAllocations(items, discount.Amount)
+		return discount.Clone(), nil
+	}
+
+	eval := s.evaluate(discount.UserID, items)
+	if discount.CampaignID != "" {
+		campaign, amount := s.redrawCampaign(discount.CampaignID, discount.CampaignAmount, eval.left, eval.headroom)
+		if campaign != nil {
+			eval.addCampaign(campaign, amount)
+		} else {
+			discount.CampaignID = ""
+		}
+		discount.CampaignAmount = amount
+	}
+
+	eval.apply(discount)
+	return discount.Clone(), nil
+}
+
+// promoAmount is what promo takes off an order of totalAmount, bounded by the
+// discount cap.
+func promoAmount(promo *model.PromoCode, discountCap model.DiscountCap, totalAmount float64) float64 {
+	amount := roundCents(promo.DiscountFor(totalAmount))
+	if limit := floorCents(capLimit(discountCap, totalAmount)); limit < amount {
+		amount = limit
+	}
+	return amount
+}
+
+func (s *DiscountService) adjustRedemptions(code, userID string, delta int) {
+	shard := s.promos[shardIndex(code)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	promo, exists := shard.codes[code]
+	if !exists {
+		return
+	}
+
+	promo.Redemptions += delta
+	promo.RedemptionsByUser[userID] += delta
+	if promo.RedemptionsByUser[userID] <= 0 {
+		delete(promo.RedemptionsByUser, userID)
+	}
+}
+
+func (s *DiscountService) storeDiscount(discount *model.Discount) {
+	discount.TenantID = s.tenantID
+
+	shard := s.discounts[shardIndex(discount.ID)]
+	shard.mu.Lock()
+	shard.discounts[discount.ID] = discount
+	shard.mu.Unlock()
+
+	index := s.byOrder[shardIndex(discount.OrderID)]
+	index.mu.Lock()
+	index.discounts[discount.OrderID] = discount.ID
+	index.mu.Unlock()
 }
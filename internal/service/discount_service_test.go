@@ -1,14 +1,19 @@
 package service
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	"homework/internal/model"
 )
 
 func TestDiscountService_ApplyDiscount(t *testing.T) {
 	service := NewDiscountService()
+	service.SetUserDiscount("user1", 10.0)
 	totalAmount := 200.0
 
-	discount, err := service.ApplyDiscount("order1", "user1", totalAmount)
+	discount, err := service.ApplyDiscount("order1", "user1", itemsWorth(totalAmount))
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -31,7 +36,7 @@ func TestDiscountService_ApplyDiscount_NoDiscount(t *testing.T) {
 	service := NewDiscountService()
 	totalAmount := 200.0
 
-	discount, err := service.ApplyDiscount("order1", "user3", totalAmount)
+	discount, err := service.ApplyDiscount("order1", "user3", itemsWorth(totalAmount))
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -43,7 +48,8 @@ func TestDiscountService_ApplyDiscount_NoDiscount(t *testing.T) {
 
 func TestDiscountService_RemoveDiscount(t *testing.T) {
 	service := NewDiscountService()
-	discount, _ := service.ApplyDiscount("order1", "user1", 200.0)
+	service.SetUserDiscount("user1", 10.0)
+	discount, _ := service.ApplyDiscount("order1", "user1", itemsWorth(200.0))
 
 	err := service.RemoveDiscount(discount.ID)
 	if err != nil {
@@ -55,3 +61,149 @@ func TestDiscountService_RemoveDiscount(t *testing.T) {
 		t.Error("Expected discount to be removed")
 	}
 }
+
+func TestDiscountService_GetDiscountByOrderID(t *testing.T) {
+	service := NewDiscountService()
+	service.SetUserDiscount("user1", 10.0)
+	discount, _ := service.ApplyDiscount("order1", "user1", itemsWorth(200.0))
+
+	found, err := service.GetDiscountByOrderID("order1")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if found.ID != discount.ID {
+		t.Errorf("Expected discount %s, got %s", discount.ID, found.ID)
+	}
+
+	service.RemoveDiscount(discount.ID)
+	if _, err := service.GetDiscountByOrderID("order1"); err == nil {
+		t.Error("Expected removed discount to be dropped from the order index")
+	}
+
+	if err := service.RestoreDiscount(discount); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := service.GetDiscountByOrderID("order1"); err != nil {
+		t.Errorf("Expected restored discount to be found, got: %v", err)
+	}
+}
+
+func TestDiscountService_ApplyPromoCode(t *testing.T) {
+	service := NewDiscountService()
+	service.CreatePromoCode(model.PromoCode{Code: "SPRING20", Type: model.PromoCodePercentage, Value: 20.0})
+	service.CreatePromoCode(model.PromoCode{Code: "MINUS50", Type: model.PromoCodeFixed, Value: 50.0, MinOrderTotal: 100.0})
+
+	discount, err := service.ApplyPromoCode("order1", "user3", "SPRING20", itemsWorth(200.0))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if discount.Amount != 40.0 || discount.PromoCode != "SPRING20" {
+		t.Errorf("Expected 40.00 off with SPRING20, got %.2f with %q", discount.Amount, discount.PromoCode)
+	}
+
+	discount, _ = service.ApplyPromoCode("order2", "user3", "MINUS50", itemsWorth(120.0))
+	if discount.Amount != 50.0 {
+		t.Errorf("Expected 50.00 off, got %.2f", discount.Amount)
+	}
+
+	if _, err := service.ApplyPromoCode("order3", "user3", "MINUS50", itemsWorth(80.0)); !errors.Is(err, ErrInvalidPromoCode) {
+		t.Errorf("Expected ErrInvalidPromoCode below minimum total, got: %v", err)
+	}
+
+	if _, err := service.ApplyPromoCode("order4", "user3", "UNKNOWN", itemsWorth(200.0)); !errors.Is(err, ErrInvalidPromoCode) {
+		t.Errorf("Expected ErrInvalidPromoCode for unknown code, got: %v", err)
+	}
+}
+
+func TestDiscountService_PromoCodesDisabled(t *testing.T) {
+	service := NewDiscountService()
+	service.CreatePromoCode(model.PromoCode{Code: "SPRING20", Type: model.PromoCodePercentage, Value: 20.0})
+	service.SetPromoCodesDisabled(true)
+
+	if _, err := service.ApplyPromoCode("order1", "user3", "SPRING20", itemsWorth(200.0)); !errors.Is(err, ErrInvalidPromoCode) {
+		t.Errorf("Expected ErrInvalidPromoCode while promo codes are disabled, got: %v", err)
+	}
+	if promo, _ := service.GetPromoCode("SPRING20"); promo.Redemptions != 0 {
+		t.Errorf("Expected no redemption while promo codes are disabled, got %d", promo.Redemptions)
+	}
+
+	service.SetPromoCodesDisabled(false)
+	if _, err := service.ApplyPromoCode("order2", "user3", "SPRING20", itemsWorth(200.0)); err != nil {
+		t.Errorf("Expected the code to apply once re-enabled, got: %v", err)
+	}
+}
+
+func TestDiscountService_PromoCodeWithinCap(t *testing.T) {
+	service := NewDiscountService()
+	service.CreatePromoCode(model.PromoCode{Code: "HALF", Type: model.PromoCodePercentage, Value: 50.0})
+	service.SetDiscountCap(model.DiscountCap{Percentage: 10.0})
+
+	discount, err := service.ApplyPromoCode("order1", "user3", "HALF", itemsWorth(100.0))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if discount.Amount != 10.0 {
+		t.Errorf("Expected the cap to hold the code to 10.00, got %.2f", discount.Amount)
+	}
+
+	discount, err = service.RecalculateDiscount("order1", itemsWorth(300.0))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if discount.Amount != 30.0 || sumAllocations(discount.Lines) != 30.0 {
+		t.Errorf("Expected the repriced code to stay at the cap of 30.00, got %.2f", discount.Amount)
+	}
+}
+
+func TestDiscountService_PromoCodeLimits(t *testing.T) {
+	service := NewDiscountService()
+	service.CreatePromoCode(model.PromoCode{
+		Code:                  "ONCE",
+		Type:                  model.PromoCodeFixed,
+		Value:                 10.0,
+		MaxRedemptions:        2,
+		MaxRedemptionsPerUser: 1,
+	})
+	service.CreatePromoCode(model.PromoCode{
+		Code:       "EXPIRED",
+		Type:       model.PromoCodeFixed,
+		Value:      10.0,
+		ValidUntil: time.Now().Add(-time.Hour),
+	})
+
+	first, err := service.ApplyPromoCode("order1", "user1", "ONCE", itemsWorth(100.0))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := service.ApplyPromoCode("order2", "user1", "ONCE", itemsWorth(100.0)); err == nil {
+		t.Error("Expected per-user limit to be enforced")
+	}
+
+	service.ApplyPromoCode("order3", "user2", "ONCE", itemsWorth(100.0))
+	if _, err := service.ApplyPromoCode("order4", "user3", "ONCE", itemsWorth(100.0)); err == nil {
+		t.Error("Expected global limit to be enforced")
+	}
+
+	service.RemoveDiscount(first.ID)
+	promo, _ := service.GetPromoCode("ONCE")
+	if promo.Redemptions != 1 || promo.RedemptionsByUser["user1"] != 0 {
+		t.Errorf("Expected redemption to be returned, got %d total and %d for user1",
+			promo.Redemptions, promo.RedemptionsByUser["user1"])
+	}
+
+	if _, err := service.ApplyPromoCode("order5", "user1", "ONCE", itemsWorth(100.0)); err != nil {
+		t.Errorf("Expected code to be redeemable again, got: %v", err)
+	}
+
+	if _, err := service.ApplyPromoCode("order6", "user1", "EXPIRED", itemsWorth(100.0)); !errors.Is(err, ErrInvalidPromoCode) {
+		t.Errorf("Expected ErrInvalidPromoCode for expired code, got: %v", err)
+	}
+}
+
+// itemsWorth returns a single order line priced at amount.
+func itemsWorth(amount float64) []model.OrderItem {
+	return []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: amount}}
+}
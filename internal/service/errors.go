@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"homework/internal/model"
+)
+
+var ErrConcurrentModification = errors.New("concurrent modification")
+
+type ConcurrentModificationError struct {
+	Entity          string
+	ID              string
+	ExpectedVersion int64
+	ActualVersion   int64
+}
+
+func (e *ConcurrentModificationError) Error() string {
+	return fmt.Sprintf("concurrent modification of %s %s: expected version %d, actual %d",
+		e.Entity, e.ID, e.ExpectedVersion, e.ActualVersion)
+}
+
+func (e *ConcurrentModificationError) Is(target error) bool {
+	return target == ErrConcurrentModification
+}
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+type InvalidTransitionError struct {
+	OrderID string
+	From    model.OrderStatus
+	To      model.OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid transition for order %s: %s -> %s", e.OrderID, e.From, e.To)
+}
+
+func (e *InvalidTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInvalidOrder      = errors.New("invalid order")
+	ErrInvalidPromoCode  = errors.New("invalid promo code")
+)
+
+type InsufficientStockError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for product %s: requested %d, available %d",
+		e.ProductID, e.Requested, e.Available)
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+type InsufficientFundsError struct {
+	UserID   string
+	Balance  float64
+	Required float64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient funds for user %s: balance %.2f, required %.2f", e.UserID, e.Balance, e.Required)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// OrderValidationError reports the first invalid field of an order. Item
+// fields are named with their index, e.g. "items[1].quantity".
+type OrderValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *OrderValidationError) Error() string {
+	return fmt.Sprintf("invalid order: %s %s", e.Field, e.Reason)
+}
+
+func (e *OrderValidationError) Is(target error) bool {
+	return target == ErrInvalidOrder
+}
+
+var ErrInsufficientPoints = errors.New("insufficient loyalty points")
+
+type InsufficientPointsError struct {
+	UserID    string
+	Balance   int
+	Requested int
+}
+
+func (e *InsufficientPointsError) Error() string {
+	return fmt.Sprintf("insufficient loyalty points for user %s: balance %d, requested %d", e.UserID, e.Balance, e.Requested)
+}
+
+func (e *InsufficientPointsError) Is(target error) bool {
+	return target == ErrInsufficientPoints
+}
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUserSuspended = errors.New("user suspended")
+	ErrInvalidUser   = errors.New("invalid user")
+)
@@ -2,111 +2,475 @@ package service
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"homework/internal/model"
 )
 
+const (
+	systemActor = "system"
+
+	defaultVelocityWindow = 7 * 24 * time.Hour
+)
+
+// InventoryService keeps product state in shards keyed by product ID, so
+// orders touching different products never contend for the same lock.
+// Reservations are additionally indexed by order ID; their mutable fields
+// are guarded by the lock of the shard owning their product.
 type InventoryService struct {
-	mu            sync.RWMutex
-	products      map[string]*model.Product
-	reservations  map[string]*model.InventoryReservation
-	shouldFail bool
+	tenantID     string
+	shards       [shardCount]*inventoryShard
+	reservations [shardCount]*reservationIndex
+
+	mu          sync.RWMutex
+	subscribers []func(model.InventoryEvent)
+	shouldFail  atomic.Bool
+}
+
+type inventoryShard struct {
+	mu         sync.RWMutex
+	products   map[string]*model.Product
+	backorders map[string][]*model.InventoryReservation
+	movements  map[string][]model.StockMovement
+	pending    map[string]int
+}
+
+type reservationIndex struct {
+	mu      sync.RWMutex
+	byOrder map[string][]*model.InventoryReservation
 }
 
 func NewInventoryService() *InventoryService {
-	service := &InventoryService{
-		products:     make(map[string]*model.Product),
-		reservations: make(map[string]*model.InventoryReservation),
+	return NewInventoryServiceForTenant("")
+}
+
+// NewInventoryServiceForTenant returns a service holding the stock of one
+// tenant.
+func NewInventoryServiceForTenant(tenantID string) *InventoryService {
+	service := &InventoryService{tenantID: tenantID}
+
+	for i := range service.shards {
+		service.shards[i] = &inventoryShard{
+			products:   make(map[string]*model.Product),
+			backorders: make(map[string][]*model.InventoryReservation),
+			movements:  make(map[string][]model.StockMovement),
+			pending:    make(map[string]int),
+		}
+		service.reservations[i] = &reservationIndex{
+			byOrder: make(map[string][]*model.InventoryReservation),
+		}
 	}
 
 	return service
 }
 
 func (s *InventoryService) SetShouldFail(shouldFail bool) {
+	s.shouldFail.Store(shouldFail)
+}
+
+func (s *InventoryService) Subscribe(handler func(model.InventoryEvent)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.shouldFail = shouldFail
+	s.subscribers = append(s.subscribers, handler)
+}
+
+func (s *InventoryService) SetBackorderLimit(productID string, limit int) error {
+	return s.updateProduct(productID, func(product *model.Product) {
+		product.Backorder.Limit = limit
+	})
+}
+
+func (s *InventoryService) SetPreorder(productID string, releaseDate time.Time, limit int) error {
+	return s.updateProduct(productID, func(product *model.Product) {
+		product.Backorder = model.BackorderPolicy{
+			Limit:       limit,
+			ReleaseDate: releaseDate,
+		}
+	})
+}
+
+func (s *InventoryService) SetReorderPolicy(productID string, policy model.ReorderPolicy) error {
+	return s.updateProduct(productID, func(product *model.Product) {
+		product.Reorder = policy
+	})
 }
 
 func (s *InventoryService) ReserveItems(orderID string, items []model.OrderItem) ([]*model.InventoryReservation, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.shouldFail.Load() {
+		return nil, fmt.Errorf("inventory reservation failed: %w", ErrInsufficientStock)
+	}
 
-	if s.shouldFail {
-		return nil, fmt.Errorf("inventory reservation failed: insufficient stock")
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
 	}
 
-	var reservations []*model.InventoryReservation
+	unlock := s.lockProducts(productIDs)
 
-	for _, item := range items {
-		product, exists := s.products[item.ProductID]
+	now := time.Now()
+	stockBefore := make(map[string]int)
+	available := make(map[string]int)
+	backordered := make(map[string]int)
+	backorder := make([]bool, len(items))
+
+	for i, item := range items {
+		shard := s.shard(item.ProductID)
+		product, exists := shard.products[item.ProductID]
 		if !exists {
-			return nil, fmt.Errorf("product not found: %s", item.ProductID)
+			unlock()
+			return nil, fmt.Errorf("%w: %s", ErrProductNotFound, item.ProductID)
+		}
+
+		if _, seen := available[product.ID]; !seen {
+			stockBefore[product.ID] = product.Stock
+			available[product.ID] = product.Stock
+			backordered[product.ID] = shard.backorderedQuantity(product.ID)
 		}
 
-		if product.Stock < item.Quantity {
-			return nil, fmt.Errorf("insufficient stock for product %s: requested %d, available %d",
-				item.ProductID, item.Quantity, product.Stock)
+		if !product.Backorder.IsPreorder(now) && available[product.ID] >= item.Quantity {
+			available[product.ID] -= item.Quantity
+			continue
 		}
 
-		product.Stock -= item.Quantity
+		if backordered[product.ID]+item.Quantity > product.Backorder.Limit {
+			unlock()
+			return nil, &InsufficientStockError{
+				ProductID: item.ProductID,
+				Requested: item.Quantity,
+				Available: available[product.ID],
+			}
+		}
+
+		backordered[product.ID] += item.Quantity
+		backorder[i] = true
+	}
+
+	var reservations []*model.InventoryReservation
+	var events []model.InventoryEvent
 
+	for i, item := range items {
+		shard := s.shard(item.ProductID)
 		reservation := &model.InventoryReservation{
 			ID:        uuid.New().String(),
+			TenantID:  s.tenantID,
 			OrderID:   orderID,
 			ProductID: item.ProductID,
 			Quantity:  item.Quantity,
 			Status:    model.ReservationStatusReserved,
+			CreatedAt: now,
+		}
+
+		if backorder[i] {
+			reservation.Status = model.ReservationStatusBackordered
+			shard.backorders[item.ProductID] = append(shard.backorders[item.ProductID], reservation)
+			events = append(events, newInventoryEvent(model.InventoryEventBackordered, reservation))
+		} else {
+			shard.applyStockChange(shard.products[item.ProductID], model.StockMovementReserve, -item.Quantity, orderID, "order reservation", systemActor)
+			reservation.Pending = true
+			shard.pending[item.ProductID] += item.Quantity
 		}
 
-		s.reservations[reservation.ID] = reservation
 		reservations = append(reservations, reservation)
 	}
 
-	return reservations, nil
+	for productID, stock := range stockBefore {
+		shard := s.shard(productID)
+		if event, crossed := shard.lowStockEvent(shard.products[productID], stock); crossed {
+			events = append(events, event)
+		}
+	}
+
+	s.indexReservations(orderID, reservations)
+
+	copies := make([]*model.InventoryReservation, len(reservations))
+	for i, reservation := range reservations {
+		copies[i] = reservation.Clone()
+	}
+
+	unlock()
+	s.publish(events)
+
+	return copies, nil
 }
 
 func (s *InventoryService) ReleaseItems(orderID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	reservations := s.orderReservations(orderID)
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	productIDs := make([]string, len(reservations))
+	for i, reservation := range reservations {
+		productIDs[i] = reservation.ProductID
+	}
 
-	for _, reservation := range s.reservations {
-		if reservation.OrderID == orderID && reservation.Status == model.ReservationStatusReserved {
-			product, exists := s.products[reservation.ProductID]
+	unlock := s.lockProducts(productIDs)
+
+	restocked := make(map[string]bool)
+	for _, reservation := range reservations {
+		shard := s.shard(reservation.ProductID)
+
+		switch reservation.Status {
+		case model.ReservationStatusReserved:
+			product, exists := shard.products[reservation.ProductID]
 			if exists {
-				product.Stock += reservation.Quantity
+				shard.applyStockChange(product, model.StockMovementRelease, reservation.Quantity, orderID, "reservation released", systemActor)
+				restocked[product.ID] = true
 			}
+			shard.settlePending(reservation, reservation.Quantity)
+		case model.ReservationStatusBackordered:
+			shard.removeBackorder(reservation)
+		default:
+			continue
+		}
+		reservation.Status = model.ReservationStatusReleased
+	}
+
+	var events []model.InventoryEvent
+	for productID := range restocked {
+		shard := s.shard(productID)
+		events = append(events, shard.fulfillBackorders(shard.products[productID])...)
+	}
+
+	unlock()
+	s.publish(events)
+
+	return nil
+}
+
+// ReleaseQuantities gives back part of an order's reservations, newest first,
+// so that a later ReserveItems for the same quantities is undone exactly.
+// Backordered units leave the queue; reserved units go back on the shelf.
+func (s *InventoryService) ReleaseQuantities(orderID string, items []model.OrderItem) error {
+	reservations := s.orderReservations(orderID)
+
+	// Every reservation of the order is read below, so the shards of all
+	// its products are locked, not only those of the items released.
+	productIDs := make([]string, 0, len(reservations)+len(items))
+	for _, reservation := range reservations {
+		productIDs = append(productIDs, reservation.ProductID)
+	}
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	unlock := s.lockProducts(productIDs)
+
+	held := make(map[string]int)
+	for _, reservation := range reservations {
+		if reservation.Status == model.ReservationStatusReserved || reservation.Status == model.ReservationStatusBackordered {
+			held[reservation.ProductID] += reservation.Quantity
+		}
+	}
+
+	requested := make(map[string]int)
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			unlock()
+			return fmt.Errorf("invalid release quantity for product %s: %d", item.ProductID, item.Quantity)
+		}
+		requested[item.ProductID] += item.Quantity
+		if requested[item.ProductID] > held[item.ProductID] {
+			unlock()
+			return fmt.Errorf("order %s holds %d of product %s, cannot release %d",
+				orderID, held[item.ProductID], item.ProductID, requested[item.ProductID])
+		}
+	}
+
+	restocked := make(map[string]bool)
+	for i := len(reservations) - 1; i >= 0; i-- {
+		reservation := reservations[i]
+		units := requested[reservation.ProductID]
+		if units == 0 {
+			continue
+		}
+		if units > reservation.Quantity {
+			units = reservation.Quantity
+		}
+
+		shard := s.shard(reservation.ProductID)
+		switch reservation.Status {
+		case model.ReservationStatusReserved:
+			if product, exists := shard.products[reservation.ProductID]; exists {
+				shard.applyStockChange(product, model.StockMovementRelease, units, orderID, "order modified", systemActor)
+				restocked[product.ID] = true
+			}
+			shard.settlePending(reservation, units)
+		case model.ReservationStatusBackordered:
+			if units == reservation.Quantity {
+				shard.removeBackorder(reservation)
+			}
+		default:
+			continue
+		}
+
+		if units == reservation.Quantity {
 			reservation.Status = model.ReservationStatusReleased
+		} else {
+			reservation.Quantity -= units
 		}
+		requested[reservation.ProductID] -= units
 	}
 
+	var events []model.InventoryEvent
+	for productID := range restocked {
+		shard := s.shard(productID)
+		events = append(events, shard.fulfillBackorders(shard.products[productID])...)
+	}
+
+	unlock()
+	s.publish(events)
+
+	return nil
+}
+
+// CommitReservations clears the pending flag of the order's reservations
+// once the saga that made them has completed.
+func (s *InventoryService) CommitReservations(orderID string) error {
+	reservations := s.orderReservations(orderID)
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	productIDs := make([]string, len(reservations))
+	for i, reservation := range reservations {
+		productIDs[i] = reservation.ProductID
+	}
+
+	unlock := s.lockProducts(productIDs)
+	defer unlock()
+
+	for _, reservation := range reservations {
+		s.shard(reservation.ProductID).settlePending(reservation, reservation.Quantity)
+	}
 	return nil
 }
 
 func (s *InventoryService) SetStock(productID string, stock int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.AdjustStock(productID, stock, "stock set", systemActor)
+}
+
+func (s *InventoryService) AdjustStock(productID string, stock int, reason, actor string) {
+	shard := s.shard(productID)
+	shard.mu.Lock()
 
-	product, exists := s.products[productID]
+	product, exists := shard.products[productID]
 	if !exists {
 		product = &model.Product{
-			ID:    productID,
-			Price: 0.0,
-			Stock: stock,
+			ID:       productID,
+			TenantID: s.tenantID,
+			Price:    0.0,
 		}
-		s.products[productID] = product
-	} else {
-		product.Stock = stock
+		shard.products[productID] = product
+	}
+
+	shard.applyStockChange(product, model.StockMovementAdjustment, stock-product.Stock, "", reason, actor)
+
+	events := shard.fulfillBackorders(product)
+	shard.mu.Unlock()
+	s.publish(events)
+}
+
+func (s *InventoryService) Restock(productID string, quantity int, reason, actor string) error {
+	if quantity <= 0 {
+		return fmt.Errorf("invalid restock quantity for product %s: %d", productID, quantity)
+	}
+
+	shard := s.shard(productID)
+	shard.mu.Lock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		shard.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	shard.applyStockChange(product, model.StockMovementRestock, quantity, "", reason, actor)
+
+	events := shard.fulfillBackorders(product)
+	shard.mu.Unlock()
+	s.publish(events)
+
+	return nil
+}
+
+// ReverseRestock takes back units added by Restock, e.g. when the return
+// that brought them back is rolled back. Units already sold again cannot be
+// taken back.
+func (s *InventoryService) ReverseRestock(productID string, quantity int, reason, actor string) error {
+	if quantity <= 0 {
+		return fmt.Errorf("invalid restock reversal quantity for product %s: %d", productID, quantity)
 	}
+
+	shard := s.shard(productID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	if product.Stock < quantity {
+		return fmt.Errorf("restock reversal exceeds stock for product %s: reversal %d, available %d",
+			productID, quantity, product.Stock)
+	}
+
+	shard.applyStockChange(product, model.StockMovementAdjustment, -quantity, "", reason, actor)
+	return nil
+}
+
+func (s *InventoryService) RecordShrinkage(productID string, quantity int, reason, actor string) error {
+	if quantity <= 0 {
+		return fmt.Errorf("invalid shrinkage quantity for product %s: %d", productID, quantity)
+	}
+
+	shard := s.shard(productID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	if product.Stock < quantity {
+		return fmt.Errorf("shrinkage exceeds stock for product %s: shrinkage %d, available %d",
+			productID, quantity, product.Stock)
+	}
+
+	shard.applyStockChange(product, model.StockMovementShrinkage, -quantity, "", reason, actor)
+	return nil
+}
+
+func (s *InventoryService) FulfillBackorders(productID string) error {
+	shard := s.shard(productID)
+	shard.mu.Lock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		shard.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	events := shard.fulfillBackorders(product)
+	shard.mu.Unlock()
+	s.publish(events)
+
+	return nil
 }
 
 func (s *InventoryService) GetStock(productID string) int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	product, exists := s.products[productID]
+	product, exists := shard.products[productID]
 	if !exists {
 		return 0
 	}
@@ -114,14 +478,393 @@ func (s *InventoryService) GetStock(productID string) int {
 	return product.Stock
 }
 
+func (s *InventoryService) GetStockLevel(productID string) model.StockLevel {
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	level := model.StockLevel{ProductID: productID, Pending: shard.pending[productID]}
+	if product, exists := shard.products[productID]; exists {
+		level.Stock = product.Stock
+	}
+	return level
+}
+
+func (s *InventoryService) GetBackorderedQuantity(productID string) int {
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.backorderedQuantity(productID)
+}
+
+func (s *InventoryService) GetReservations(orderID string) []*model.InventoryReservation {
+	reservations := s.orderReservations(orderID)
+
+	productIDs := make([]string, len(reservations))
+	for i, reservation := range reservations {
+		productIDs[i] = reservation.ProductID
+	}
+
+	unlock := s.rlockProducts(productIDs)
+	defer unlock()
+
+	copies := make([]*model.InventoryReservation, len(reservations))
+	for i, reservation := range reservations {
+		copies[i] = reservation.Clone()
+	}
+
+	return copies
+}
+
 func (s *InventoryService) GetProduct(productID string) (*model.Product, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	return product.Clone(), nil
+}
+
+// UpdateProduct applies update to a copy of the product and stores it only if
+// the product is still at expectedVersion. A stock change is journaled as an
+// adjustment by actor.
+func (s *InventoryService) UpdateProduct(productID string, expectedVersion int64, actor string, update func(*model.Product) error) (*model.Product, error) {
+	shard := s.shard(productID)
+	shard.mu.Lock()
 
-	product, exists := s.products[productID]
+	product, exists := shard.products[productID]
 	if !exists {
-		return nil, fmt.Errorf("product not found: %s", productID)
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	if product.Version != expectedVersion {
+		shard.mu.Unlock()
+		return nil, &ConcurrentModificationError{
+			Entity:          "product",
+			ID:              productID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   product.Version,
+		}
+	}
+
+	updated := product.Clone()
+	if err := update(updated); err != nil {
+		shard.mu.Unlock()
+		return nil, err
+	}
+
+	if updated.Stock < 0 {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("invalid stock for product %s: %d", productID, updated.Stock)
 	}
 
-	return product, nil
+	product.Price = updated.Price
+	product.Backorder = updated.Backorder
+	product.Reorder = updated.Reorder
+
+	var events []model.InventoryEvent
+	if updated.Stock != product.Stock {
+		shard.applyStockChange(product, model.StockMovementAdjustment, updated.Stock-product.Stock, "", "product update", actor)
+		events = shard.fulfillBackorders(product)
+	} else {
+		product.Version++
+	}
+
+	result := product.Clone()
+	shard.mu.Unlock()
+	s.publish(events)
+
+	return result, nil
+}
+
+// SuggestReorderQuantity estimates how many units to order so that, after the
+// supplier lead time at the recent reservation velocity, stock is back at the
+// reorder point with all outstanding backorders covered.
+func (s *InventoryService) SuggestReorderQuantity(productID string) (int, error) {
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	return shard.suggestReorderQuantity(product), nil
+}
+
+func (s *InventoryService) GetMovements(productID string, filter model.StockMovementFilter) []model.StockMovement {
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	var movements []model.StockMovement
+	for _, movement := range shard.movements[productID] {
+		if filter.Matches(movement) {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements
+}
+
+// Reconcile replays the product's journal from zero and compares the result
+// with the stock currently on record.
+func (s *InventoryService) Reconcile(productID string) (*model.StockReconciliation, error) {
+	shard := s.shard(productID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	reconciliation := shard.reconcile(product)
+	return &reconciliation, nil
+}
+
+func (s *InventoryService) ReconcileAll() []model.StockReconciliation {
+	var reconciliations []model.StockReconciliation
+
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for _, product := range shard.products {
+			reconciliations = append(reconciliations, shard.reconcile(product))
+		}
+		shard.mu.RUnlock()
+	}
+
+	sort.Slice(reconciliations, func(i, j int) bool {
+		return reconciliations[i].ProductID < reconciliations[j].ProductID
+	})
+
+	return reconciliations
+}
+
+func (s *InventoryService) shard(productID string) *inventoryShard {
+	return s.shards[shardIndex(productID)]
+}
+
+func (s *InventoryService) lockProducts(productIDs []string) func() {
+	indexes := shardIndexes(productIDs)
+	for _, index := range indexes {
+		s.shards[index].mu.Lock()
+	}
+
+	return func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			s.shards[indexes[i]].mu.Unlock()
+		}
+	}
+}
+
+func (s *InventoryService) rlockProducts(productIDs []string) func() {
+	indexes := shardIndexes(productIDs)
+	for _, index := range indexes {
+		s.shards[index].mu.RLock()
+	}
+
+	return func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			s.shards[indexes[i]].mu.RUnlock()
+		}
+	}
+}
+
+func (s *InventoryService) updateProduct(productID string, update func(*model.Product)) error {
+	shard := s.shard(productID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	product, exists := shard.products[productID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+	}
+
+	update(product)
+	product.Version++
+	return nil
+}
+
+func (s *InventoryService) indexReservations(orderID string, reservations []*model.InventoryReservation) {
+	index := s.reservations[shardIndex(orderID)]
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.byOrder[orderID] = append(index.byOrder[orderID], reservations...)
+}
+
+func (s *InventoryService) orderReservations(orderID string) []*model.InventoryReservation {
+	index := s.reservations[shardIndex(orderID)]
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return append([]*model.InventoryReservation(nil), index.byOrder[orderID]...)
+}
+
+func (s *InventoryService) publish(events []model.InventoryEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	subscribers := append([]func(model.InventoryEvent){}, s.subscribers...)
+	s.mu.RUnlock()
+
+	for _, event := range events {
+		for _, subscriber := range subscribers {
+			subscriber(event)
+		}
+	}
+}
+
+func (s *inventoryShard) reconcile(product *model.Product) model.StockReconciliation {
+	movements := s.movements[product.ID]
+
+	expected := 0
+	for _, movement := range movements {
+		expected += movement.Quantity
+	}
+
+	return model.StockReconciliation{
+		ProductID:     product.ID,
+		ExpectedStock: expected,
+		ActualStock:   product.Stock,
+		Discrepancy:   product.Stock - expected,
+		Movements:     len(movements),
+	}
+}
+
+// applyStockChange is the only place stock is modified, so every change bumps
+// the product version and lands in the journal.
+func (s *inventoryShard) applyStockChange(product *model.Product, movementType model.StockMovementType, quantity int, orderID, reason, actor string) {
+	product.Stock += quantity
+	product.Version++
+	s.movements[product.ID] = append(s.movements[product.ID], model.StockMovement{
+		ID:         uuid.New().String(),
+		TenantID:   product.TenantID,
+		ProductID:  product.ID,
+		Type:       movementType,
+		Quantity:   quantity,
+		StockAfter: product.Stock,
+		OrderID:    orderID,
+		Reason:     reason,
+		Actor:      actor,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func (s *inventoryShard) suggestReorderQuantity(product *model.Product) int {
+	window := product.Reorder.VelocityWindow
+	if window <= 0 {
+		window = defaultVelocityWindow
+	}
+
+	since := time.Now().Add(-window)
+	reserved := 0
+	for _, movement := range s.movements[product.ID] {
+		if movement.Type == model.StockMovementReserve && !movement.CreatedAt.Before(since) {
+			reserved -= movement.Quantity
+		}
+	}
+
+	velocity := float64(reserved) / window.Hours()
+	leadTimeDemand := int(math.Ceil(velocity * product.Reorder.LeadTime.Hours()))
+
+	suggested := product.Reorder.Point + leadTimeDemand + s.backorderedQuantity(product.ID) - product.Stock
+	if suggested < 0 {
+		return 0
+	}
+	return suggested
+}
+
+func (s *inventoryShard) lowStockEvent(product *model.Product, stockBefore int) (model.InventoryEvent, bool) {
+	if !product.Reorder.Enabled() || stockBefore < product.Reorder.Point || product.Stock >= product.Reorder.Point {
+		return model.InventoryEvent{}, false
+	}
+
+	return model.InventoryEvent{
+		Type:              model.InventoryEventLowStock,
+		ProductID:         product.ID,
+		Stock:             product.Stock,
+		SuggestedQuantity: s.suggestReorderQuantity(product),
+		CreatedAt:         time.Now(),
+	}, true
+}
+
+// settlePending takes units of a pending reservation out of the pending
+// count, e.g. when they are released or the reservation is committed.
+func (s *inventoryShard) settlePending(reservation *model.InventoryReservation, units int) {
+	if !reservation.Pending {
+		return
+	}
+
+	s.pending[reservation.ProductID] -= units
+	if units == reservation.Quantity {
+		reservation.Pending = false
+	}
+}
+
+func (s *inventoryShard) backorderedQuantity(productID string) int {
+	total := 0
+	for _, reservation := range s.backorders[productID] {
+		total += reservation.Quantity
+	}
+	return total
+}
+
+func (s *inventoryShard) removeBackorder(reservation *model.InventoryReservation) {
+	queue := s.backorders[reservation.ProductID]
+	for i, queued := range queue {
+		if queued.ID == reservation.ID {
+			s.backorders[reservation.ProductID] = append(queue[:i:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// fulfillBackorders allocates stock to queued backorders strictly in FIFO
+// order, so a large early backorder is never overtaken by a smaller later one.
+func (s *inventoryShard) fulfillBackorders(product *model.Product) []model.InventoryEvent {
+	if product.Backorder.IsPreorder(time.Now()) {
+		return nil
+	}
+
+	var events []model.InventoryEvent
+	stockBefore := product.Stock
+	queue := s.backorders[product.ID]
+	for len(queue) > 0 && queue[0].Quantity <= product.Stock {
+		reservation := queue[0]
+		reservation.Status = model.ReservationStatusReserved
+		s.applyStockChange(product, model.StockMovementReserve, -reservation.Quantity, reservation.OrderID, "backorder fulfilled", systemActor)
+		events = append(events, newInventoryEvent(model.InventoryEventBackorderFulfilled, reservation))
+		queue = queue[1:]
+	}
+
+	if len(queue) == 0 {
+		delete(s.backorders, product.ID)
+	} else {
+		s.backorders[product.ID] = queue
+	}
+
+	if event, crossed := s.lowStockEvent(product, stockBefore); crossed {
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func newInventoryEvent(eventType model.InventoryEventType, reservation *model.InventoryReservation) model.InventoryEvent {
+	return model.InventoryEvent{
+		Type:          eventType,
+		ProductID:     reservation.ProductID,
+		OrderID:       reservation.OrderID,
+		ReservationID: reservation.ID,
+		Quantity:      reservation.Quantity,
+		CreatedAt:     time.Now(),
+	}
 }
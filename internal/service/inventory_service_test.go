@@ -1,7 +1,11 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"homework/internal/model"
 )
@@ -59,3 +63,422 @@ func TestInventoryService_ReleaseItems(t *testing.T) {
 		t.Errorf("Expected stock 10, got %d", product.Stock)
 	}
 }
+
+func TestInventoryService_ReserveItems_Backorder(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 1)
+	service.SetBackorderLimit("product1", 5)
+
+	var events []model.InventoryEvent
+	service.Subscribe(func(event model.InventoryEvent) {
+		events = append(events, event)
+	})
+
+	reservations, err := service.ReserveItems("order1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 3, Price: 100.0},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if reservations[0].Status != model.ReservationStatusBackordered {
+		t.Errorf("Expected status %s, got %s", model.ReservationStatusBackordered, reservations[0].Status)
+	}
+
+	if stock := service.GetStock("product1"); stock != 1 {
+		t.Errorf("Expected stock 1, got %d", stock)
+	}
+
+	_, err = service.ReserveItems("order2", []model.OrderItem{
+		{ProductID: "product1", Quantity: 3, Price: 100.0},
+	})
+	if err == nil {
+		t.Error("Expected error when backorder limit is exceeded")
+	}
+
+	if len(events) != 1 || events[0].Type != model.InventoryEventBackordered {
+		t.Errorf("Expected one backordered event, got %v", events)
+	}
+}
+
+func TestInventoryService_Restock_FulfillsBackordersInOrder(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 0)
+	service.SetBackorderLimit("product1", 10)
+
+	var fulfilled []string
+	service.Subscribe(func(event model.InventoryEvent) {
+		if event.Type == model.InventoryEventBackorderFulfilled {
+			fulfilled = append(fulfilled, event.OrderID)
+		}
+	})
+
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 3}})
+	service.ReserveItems("order2", []model.OrderItem{{ProductID: "product1", Quantity: 1}})
+
+	if err := service.Restock("product1", 2, "supplier delivery", "warehouse"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(fulfilled) != 0 {
+		t.Errorf("Expected later backorder to wait for earlier one, got %v", fulfilled)
+	}
+
+	if err := service.Restock("product1", 3, "supplier delivery", "warehouse"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(fulfilled) != 2 || fulfilled[0] != "order1" || fulfilled[1] != "order2" {
+		t.Errorf("Expected [order1 order2] fulfilled, got %v", fulfilled)
+	}
+
+	if stock := service.GetStock("product1"); stock != 1 {
+		t.Errorf("Expected stock 1, got %d", stock)
+	}
+
+	if qty := service.GetBackorderedQuantity("product1"); qty != 0 {
+		t.Errorf("Expected no backordered units, got %d", qty)
+	}
+}
+
+func TestInventoryService_Preorder(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+	service.SetPreorder("product1", time.Now().Add(time.Hour), 5)
+
+	reservations, err := service.ReserveItems("order1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if reservations[0].Status != model.ReservationStatusBackordered {
+		t.Errorf("Expected status %s, got %s", model.ReservationStatusBackordered, reservations[0].Status)
+	}
+
+	service.Restock("product1", 5, "supplier delivery", "warehouse")
+	if status := service.GetReservations("order1")[0].Status; status != model.ReservationStatusBackordered {
+		t.Errorf("Expected pre-order to stay backordered before release date, got %s", status)
+	}
+
+	service.SetPreorder("product1", time.Now().Add(-time.Minute), 5)
+	service.FulfillBackorders("product1")
+
+	if status := service.GetReservations("order1")[0].Status; status != model.ReservationStatusReserved {
+		t.Errorf("Expected status %s after release, got %s", model.ReservationStatusReserved, status)
+	}
+
+	if stock := service.GetStock("product1"); stock != 13 {
+		t.Errorf("Expected stock 13, got %d", stock)
+	}
+}
+
+func TestInventoryService_ReleaseItems_Backordered(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 0)
+	service.SetBackorderLimit("product1", 5)
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 2}})
+
+	if err := service.ReleaseItems("order1"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if qty := service.GetBackorderedQuantity("product1"); qty != 0 {
+		t.Errorf("Expected no backordered units, got %d", qty)
+	}
+
+	service.Restock("product1", 2, "supplier delivery", "warehouse")
+	if stock := service.GetStock("product1"); stock != 2 {
+		t.Errorf("Expected stock 2, got %d", stock)
+	}
+}
+
+func TestInventoryService_GetMovements(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 3}})
+	service.ReleaseItems("order1")
+	service.Restock("product1", 5, "supplier delivery", "warehouse")
+	service.RecordShrinkage("product1", 2, "damaged in storage", "auditor")
+
+	movements := service.GetMovements("product1", model.StockMovementFilter{})
+	expected := []model.StockMovementType{
+		model.StockMovementAdjustment,
+		model.StockMovementReserve,
+		model.StockMovementRelease,
+		model.StockMovementRestock,
+		model.StockMovementShrinkage,
+	}
+	if len(movements) != len(expected) {
+		t.Fatalf("Expected %d movements, got %d", len(expected), len(movements))
+	}
+	for i, movementType := range expected {
+		if movements[i].Type != movementType {
+			t.Errorf("Expected movement %d to be %s, got %s", i, movementType, movements[i].Type)
+		}
+	}
+
+	if movements[1].Quantity != -3 || movements[1].OrderID != "order1" {
+		t.Errorf("Expected reserve of -3 for order1, got %d for %s", movements[1].Quantity, movements[1].OrderID)
+	}
+
+	if movements[4].Actor != "auditor" || movements[4].StockAfter != 13 {
+		t.Errorf("Expected shrinkage by auditor leaving 13, got %s leaving %d", movements[4].Actor, movements[4].StockAfter)
+	}
+
+	shrinkage := service.GetMovements("product1", model.StockMovementFilter{
+		Types: []model.StockMovementType{model.StockMovementShrinkage},
+	})
+	if len(shrinkage) != 1 {
+		t.Errorf("Expected 1 shrinkage movement, got %d", len(shrinkage))
+	}
+}
+
+func TestInventoryService_Reconcile(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 4}})
+	service.SetStock("product1", 20)
+
+	reconciliation, err := service.Reconcile("product1")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if !reconciliation.Balanced() {
+		t.Errorf("Expected balanced journal, got discrepancy %d", reconciliation.Discrepancy)
+	}
+
+	service.shard("product1").products["product1"].Stock = 25
+
+	reconciliation, _ = service.Reconcile("product1")
+	if reconciliation.Discrepancy != 5 {
+		t.Errorf("Expected discrepancy 5, got %d", reconciliation.Discrepancy)
+	}
+}
+
+func TestInventoryService_LowStockEvent(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+	service.SetReorderPolicy("product1", model.ReorderPolicy{Point: 5, LeadTime: 24 * time.Hour})
+
+	var lowStock []model.InventoryEvent
+	service.Subscribe(func(event model.InventoryEvent) {
+		if event.Type == model.InventoryEventLowStock {
+			lowStock = append(lowStock, event)
+		}
+	})
+
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 5}})
+	if len(lowStock) != 0 {
+		t.Fatalf("Expected no low stock event at the reorder point, got %d", len(lowStock))
+	}
+
+	service.ReserveItems("order2", []model.OrderItem{{ProductID: "product1", Quantity: 2}})
+	if len(lowStock) != 1 {
+		t.Fatalf("Expected 1 low stock event, got %d", len(lowStock))
+	}
+
+	if lowStock[0].Stock != 3 {
+		t.Errorf("Expected stock 3 in event, got %d", lowStock[0].Stock)
+	}
+
+	if lowStock[0].SuggestedQuantity <= 2 {
+		t.Errorf("Expected suggestion above the gap to the reorder point, got %d", lowStock[0].SuggestedQuantity)
+	}
+
+	service.ReserveItems("order3", []model.OrderItem{{ProductID: "product1", Quantity: 1}})
+	if len(lowStock) != 1 {
+		t.Errorf("Expected no repeated event while already below threshold, got %d", len(lowStock))
+	}
+}
+
+func TestInventoryService_SuggestReorderQuantity(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 100)
+	service.SetReorderPolicy("product1", model.ReorderPolicy{
+		Point:          10,
+		LeadTime:       48 * time.Hour,
+		VelocityWindow: 24 * time.Hour,
+	})
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 92}})
+
+	suggested, err := service.SuggestReorderQuantity("product1")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := 10 + 184 - 8
+	if suggested != expected {
+		t.Errorf("Expected suggested quantity %d, got %d", expected, suggested)
+	}
+}
+
+func TestInventoryService_ReserveItems_Concurrent(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 50)
+	service.SetStock("product2", 50)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			items := []model.OrderItem{
+				{ProductID: "product1", Quantity: 1, Price: 100.0},
+				{ProductID: "product2", Quantity: 1, Price: 100.0},
+			}
+			if _, err := service.ReserveItems(fmt.Sprintf("order%d", index), items); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if reserved != 50 {
+		t.Errorf("Expected 50 successful reservations, got %d", reserved)
+	}
+
+	if service.GetStock("product1") != 0 || service.GetStock("product2") != 0 {
+		t.Errorf("Expected both products sold out, got %d and %d",
+			service.GetStock("product1"), service.GetStock("product2"))
+	}
+}
+
+func TestInventoryService_UpdateProduct(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+
+	product, _ := service.GetProduct("product1")
+	product.Stock = 1000
+
+	if stock := service.GetStock("product1"); stock != 10 {
+		t.Fatalf("Expected mutation of returned product not to leak, got stock %d", stock)
+	}
+
+	updated, err := service.UpdateProduct("product1", product.Version, "admin", func(p *model.Product) error {
+		p.Price = 150.0
+		p.Stock = 12
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if updated.Version != product.Version+1 || updated.Stock != 12 || updated.Price != 150.0 {
+		t.Errorf("Unexpected product after update: %+v", updated)
+	}
+
+	_, err = service.UpdateProduct("product1", product.Version, "admin", func(p *model.Product) error {
+		p.Price = 90.0
+		return nil
+	})
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("Expected ErrConcurrentModification, got: %v", err)
+	}
+
+	reconciliation, _ := service.Reconcile("product1")
+	if !reconciliation.Balanced() {
+		t.Errorf("Expected stock update to be journaled, got discrepancy %d", reconciliation.Discrepancy)
+	}
+}
+
+func TestInventoryService_ReleaseQuantities(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 5)
+	service.SetBackorderLimit("product1", 10)
+
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 4}})
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 3}})
+
+	if err := service.ReleaseQuantities("order1", []model.OrderItem{{ProductID: "product1", Quantity: 4}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if backordered := service.GetBackorderedQuantity("product1"); backordered != 0 {
+		t.Errorf("Expected backorder to be released first, got %d backordered", backordered)
+	}
+
+	if stock := service.GetStock("product1"); stock != 2 {
+		t.Errorf("Expected stock 2, got %d", stock)
+	}
+
+	if err := service.ReleaseQuantities("order1", []model.OrderItem{{ProductID: "product1", Quantity: 4}}); err == nil {
+		t.Error("Expected releasing more than the order holds to fail")
+	}
+}
+
+func TestInventoryService_ReleaseQuantities_ConcurrentProducts(t *testing.T) {
+	service := NewInventoryService()
+
+	// Two products in different shards of the same order.
+	products := []string{"product0"}
+	for i := 1; len(products) < 2; i++ {
+		if productID := fmt.Sprintf("product%d", i); shardIndex(productID) != shardIndex(products[0]) {
+			products = append(products, productID)
+		}
+	}
+
+	const units = 20
+	for _, productID := range products {
+		service.SetStock(productID, units)
+		service.ReserveItems("order1", []model.OrderItem{{ProductID: productID, Quantity: units}})
+	}
+
+	var wg sync.WaitGroup
+	for _, productID := range products {
+		wg.Add(1)
+		go func(productID string) {
+			defer wg.Done()
+			for i := 0; i < units; i++ {
+				if err := service.ReleaseQuantities("order1", []model.OrderItem{{ProductID: productID, Quantity: 1}}); err != nil {
+					t.Errorf("Failed to release %s: %v", productID, err)
+					return
+				}
+			}
+		}(productID)
+	}
+	wg.Wait()
+
+	for _, productID := range products {
+		if stock := service.GetStock(productID); stock != units {
+			t.Errorf("Expected all of %s back in stock, got %d", productID, stock)
+		}
+	}
+}
+
+func TestInventoryService_PendingStockLevel(t *testing.T) {
+	service := NewInventoryService()
+	service.SetStock("product1", 10)
+
+	service.ReserveItems("order1", []model.OrderItem{{ProductID: "product1", Quantity: 3}})
+	service.ReserveItems("order2", []model.OrderItem{{ProductID: "product1", Quantity: 4}})
+
+	level := service.GetStockLevel("product1")
+	if level.Stock != 3 || level.Pending != 7 {
+		t.Fatalf("Expected stock 3 with 7 pending, got %+v", level)
+	}
+
+	if err := service.CommitReservations("order1"); err != nil {
+		t.Fatalf("Failed to commit reservations: %v", err)
+	}
+	if err := service.ReleaseQuantities("order2", []model.OrderItem{{ProductID: "product1", Quantity: 1}}); err != nil {
+		t.Fatalf("Failed to release quantities: %v", err)
+	}
+
+	level = service.GetStockLevel("product1")
+	if level.Stock != 4 || level.Pending != 3 {
+		t.Errorf("Expected stock 4 with 3 pending, got %+v", level)
+	}
+
+	service.ReleaseItems("order2")
+	if level = service.GetStockLevel("product1"); level.Stock != 7 || level.Pending != 0 {
+		t.Errorf("Expected stock 7 with none pending, got %+v", level)
+	}
+}
@@ -0,0 +1,398 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"homework/internal/model"
+)
+
+func DefaultLoyaltyProgram() model.LoyaltyProgram {
+	return model.LoyaltyProgram{
+		PointsPerUnit: 1.0,
+		PointValue:    0.01,
+		PointsTTL:     365 * 24 * time.Hour,
+	}
+}
+
+// LoyaltyService keeps point accounts in shards keyed by user ID. Accrued
+// points form lots that expire together; redemptions spend the lots closest
+// to expiry first and remember which lots they drew from so a reversal can
+// put the points back where they came from.
+type LoyaltyService struct {
+	tenantID string
+	program  model.LoyaltyProgram
+	now      func() time.Time
+
+	shards     [shardCount]*loyaltyShard
+	shouldFail atomic.Bool
+}
+
+type loyaltyShard struct {
+	mu       sync.Mutex
+	accounts map[string]*loyaltyAccount
+}
+
+// owed counts points taken back by accrual reversals that the balance could
+// not cover; later accruals settle them first.
+type loyaltyAccount struct {
+	lots        []*pointLot
+	accruals    map[string]*pointLot
+	redemptions map[string]*pointRedemption
+	owed        int
+	history     []model.LoyaltyTransaction
+}
+
+type pointLot struct {
+	accrual   model.LoyaltyTransaction
+	remaining int
+}
+
+type pointRedemption struct {
+	redemption model.LoyaltyTransaction
+	uses       []lotUse
+}
+
+type lotUse struct {
+	lot    *pointLot
+	points int
+}
+
+func NewLoyaltyService(program model.LoyaltyProgram) *LoyaltyService {
+	return NewLoyaltyServiceForTenant("", program)
+}
+
+// NewLoyaltyServiceForTenant returns a service holding the point accounts of
+// one tenant.
+func NewLoyaltyServiceForTenant(tenantID string, program model.LoyaltyProgram) *LoyaltyService {
+	service := &LoyaltyService{tenantID: tenantID, program: program, now: time.Now}
+
+	for i := 0; i < shardCount; i++ {
+		service.shards[i] = &loyaltyShard{accounts: make(map[string]*loyaltyAccount)}
+	}
+
+	return service
+}
+
+// Balance is the points the user can redeem. It is negative while the user
+// owes points taken back by an accrual reversal.
+func (s *LoyaltyService) Balance(userID string) int {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.account(shard, userID).balance()
+}
+
+// History returns every points transaction of the user, oldest first.
+func (s *LoyaltyService) History(userID string) []model.LoyaltyTransaction {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	account := s.account(shard, userID)
+	return append([]model.LoyaltyTransaction(nil), account.history...)
+}
+
+func (s *LoyaltyService) SetShouldFail(shouldFail bool) {
+	s.shouldFail.Store(shouldFail)
+}
+
+// AccruePoints credits the user for amount spent on an order. An order earns
+// points once until its accrual is reversed; amounts too small to earn a
+// point accrue nothing and return a nil transaction. Points the user owes
+// are settled from the new lot first.
+func (s *LoyaltyService) AccruePoints(userID, orderID string, amount float64) (*model.LoyaltyTransaction, error) {
+	if s.shouldFail.Load() {
+		return nil, fmt.Errorf("loyalty service unavailable")
+	}
+
+	points := int(math.Floor(amount*s.program.PointsPerUnit + 1e-9))
+	if points <= 0 {
+		return nil, nil
+	}
+
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	account := s.account(shard, userID)
+	if _, exists := account.accruals[orderID]; exists {
+		return nil, fmt.Errorf("points already accrued for order: %s", orderID)
+	}
+
+	now := s.now()
+	lot := &pointLot{
+		accrual: model.LoyaltyTransaction{
+			ID:        uuid.New().String(),
+			TenantID:  s.tenantID,
+			UserID:    userID,
+			OrderID:   orderID,
+			Type:      model.LoyaltyTransactionAccrual,
+			Points:    points,
+			ExpiresAt: now.Add(s.program.PointsTTL),
+			CreatedAt: now,
+		},
+		remaining: points,
+	}
+	settled := account.owed
+	if settled > points {
+		settled = points
+	}
+	lot.remaining -= settled
+	account.owed -= settled
+
+	account.lots = append(account.lots, lot)
+	sort.SliceStable(account.lots, func(i, j int) bool {
+		return account.lots[i].accrual.ExpiresAt.Before(account.lots[j].accrual.ExpiresAt)
+	})
+	account.accruals[orderID] = lot
+	account.history = append(account.history, lot.accrual)
+
+	accrual := lot.accrual
+	return &accrual, nil
+}
+
+func (s *LoyaltyService) GetAccrual(userID, orderID string) (*model.LoyaltyTransaction, error) {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	lot, exists := s.account(shard, userID).accruals[orderID]
+	if !exists {
+		return nil, fmt.Errorf("no points accrued for order: %s", orderID)
+	}
+
+	accrual := lot.accrual
+	return &accrual, nil
+}
+
+// ReverseAccrual takes back the points an order earned. What is left of the
+// lot is taken back first; points of the lot already redeemed are taken from
+// the user's other lots, soonest to expire first, and whatever the balance
+// cannot cover is owed. Points of the lot that expired unspent are not taken
+// back again.
+func (s *LoyaltyService) ReverseAccrual(userID, orderID string) (*model.LoyaltyTransaction, error) {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	account := s.account(shard, userID)
+	lot, exists := account.accruals[orderID]
+	if !exists {
+		return nil, fmt.Errorf("no points accrued for order: %s", orderID)
+	}
+
+	spent := 0
+	for _, redemption := range account.redemptions {
+		for _, use := range redemption.uses {
+			if use.lot == lot {
+				spent += use.points
+			}
+		}
+	}
+
+	reversal := s.record(account, model.LoyaltyTransaction{
+		UserID:  userID,
+		OrderID: orderID,
+		Type:    model.LoyaltyTransactionAccrualReversal,
+		Points:  lot.remaining + spent,
+	})
+	lot.remaining = 0
+	delete(account.accruals, orderID)
+
+	for _, other := range account.lots {
+		taken := other.remaining
+		if taken > spent {
+			taken = spent
+		}
+		other.remaining -= taken
+		spent -= taken
+	}
+	account.owed += spent
+
+	return &reversal, nil
+}
+
+// RedeemPoints spends up to points of the user's balance towards an order,
+// never more than needed to cover maxAmount. Redeeming nothing because the
+// order is already covered returns a nil transaction.
+func (s *LoyaltyService) RedeemPoints(userID, orderID string, points int, maxAmount float64) (*model.LoyaltyTransaction, error) {
+	if points <= 0 {
+		return nil, fmt.Errorf("invalid points to redeem for order %s: %d", orderID, points)
+	}
+
+	if s.program.PointValue > 0 {
+		covering := int(math.Floor(maxAmount/s.program.PointValue + 1e-9))
+		if covering < points {
+			points = covering
+		}
+	}
+	if points <= 0 {
+		return nil, nil
+	}
+
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	account := s.account(shard, userID)
+	if _, exists := account.redemptions[orderID]; exists {
+		return nil, fmt.Errorf("points already redeemed for order: %s", orderID)
+	}
+
+	balance := account.balance()
+	if balance < points {
+		return nil, &InsufficientPointsError{UserID: userID, Balance: balance, Requested: points}
+	}
+
+	redemption := &pointRedemption{}
+	left := points
+	for _, lot := range account.lots {
+		if left == 0 {
+			break
+		}
+		taken := lot.remaining
+		if taken > left {
+			taken = left
+		}
+		if taken == 0 {
+			continue
+		}
+		lot.remaining -= taken
+		left -= taken
+		redemption.uses = append(redemption.uses, lotUse{lot: lot, points: taken})
+	}
+
+	redemption.redemption = s.record(account, model.LoyaltyTransaction{
+		UserID:  userID,
+		OrderID: orderID,
+		Type:    model.LoyaltyTransactionRedemption,
+		Points:  points,
+		Amount:  roundCents(float64(points) * s.program.PointValue),
+	})
+	account.redemptions[orderID] = redemption
+
+	transaction := redemption.redemption
+	return &transaction, nil
+}
+
+func (s *LoyaltyService) GetRedemption(userID, orderID string) (*model.LoyaltyTransaction, error) {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	redemption, exists := s.account(shard, userID).redemptions[orderID]
+	if !exists {
+		return nil, fmt.Errorf("no points redeemed for order: %s", orderID)
+	}
+
+	transaction := redemption.redemption
+	return &transaction, nil
+}
+
+// ReverseRedemption gives the points redeemed for an order back to the lots
+// they were drawn from. Points returned to a lot that has expired meanwhile
+// expire right away.
+func (s *LoyaltyService) ReverseRedemption(userID, orderID string) (*model.LoyaltyTransaction, error) {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	account := s.account(shard, userID)
+	redemption, exists := account.redemptions[orderID]
+	if !exists {
+		return nil, fmt.Errorf("no points redeemed for order: %s", orderID)
+	}
+
+	delete(account.redemptions, orderID)
+
+	reversal := s.record(account, model.LoyaltyTransaction{
+		UserID:  userID,
+		OrderID: orderID,
+		Type:    model.LoyaltyTransactionRedemptionReversal,
+		Points:  redemption.redemption.Points,
+		Amount:  redemption.redemption.Amount,
+	})
+
+	now := s.now()
+	for _, use := range redemption.uses {
+		if now.Before(use.lot.accrual.ExpiresAt) {
+			use.lot.remaining += use.points
+			continue
+		}
+
+		s.record(account, model.LoyaltyTransaction{
+			UserID:  userID,
+			OrderID: use.lot.accrual.OrderID,
+			Type:    model.LoyaltyTransactionExpiry,
+			Points:  use.points,
+		})
+	}
+
+	return &reversal, nil
+}
+
+func (s *LoyaltyService) shard(userID string) *loyaltyShard {
+	return s.shards[shardIndex(userID)]
+}
+
+// account returns the user's account with expired points already written
+// off. The caller must hold the shard lock.
+func (s *LoyaltyService) account(shard *loyaltyShard, userID string) *loyaltyAccount {
+	account, exists := shard.accounts[userID]
+	if !exists {
+		account = &loyaltyAccount{
+			accruals:    make(map[string]*pointLot),
+			redemptions: make(map[string]*pointRedemption),
+		}
+		shard.accounts[userID] = account
+	}
+
+	s.expire(account, s.now())
+	return account
+}
+
+// expire writes off the points left in lots past their expiry and drops the
+// lots from the balance.
+func (s *LoyaltyService) expire(account *loyaltyAccount, now time.Time) {
+	active := account.lots[:0]
+	for _, lot := range account.lots {
+		if now.Before(lot.accrual.ExpiresAt) {
+			active = append(active, lot)
+			continue
+		}
+
+		if lot.remaining > 0 {
+			s.record(account, model.LoyaltyTransaction{
+				UserID:  lot.accrual.UserID,
+				OrderID: lot.accrual.OrderID,
+				Type:    model.LoyaltyTransactionExpiry,
+				Points:  lot.remaining,
+			})
+			lot.remaining = 0
+		}
+	}
+	account.lots = active
+}
+
+func (a *loyaltyAccount) balance() int {
+	balance := -a.owed
+	for _, lot := range a.lots {
+		balance += lot.remaining
+	}
+	return balance
+}
+
+func (s *LoyaltyService) record(account *loyaltyAccount, transaction model.LoyaltyTransaction) model.LoyaltyTransaction {
+	transaction.ID = uuid.New().String()
+	transaction.TenantID = s.tenantID
+	transaction.CreatedAt = s.now()
+	account.history = append(account.history, transaction)
+	return transaction
+}
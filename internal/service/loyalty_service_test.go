@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"homework/internal/model"
+)
+
+func TestLoyaltyService_AccrueAndRedeem(t *testing.T) {
+	service := NewLoyaltyService(DefaultLoyaltyProgram())
+
+	accrual, err := service.AccruePoints("user1", "order1", 250.75)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if accrual.Points != 250 {
+		t.Errorf("Expected 250 points, got %d", accrual.Points)
+	}
+
+	if _, err := service.AccruePoints("user1", "order1", 100.0); err == nil {
+		t.Error("Expected error for accruing the same order twice")
+	}
+
+	redemption, err := service.RedeemPoints("user1", "order2", 1000, 1.5)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if redemption.Points != 150 || redemption.Amount != 1.5 {
+		t.Errorf("Expected redemption capped at 150 points worth 1.50, got %+v", redemption)
+	}
+	if balance := service.Balance("user1"); balance != 100 {
+		t.Errorf("Expected 100 points left, got %d", balance)
+	}
+
+	_, err = service.RedeemPoints("user1", "order3", 200, 100.0)
+	if !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("Expected ErrInsufficientPoints, got: %v", err)
+	}
+
+	service.ReverseRedemption("user1", "order2")
+	if balance := service.Balance("user1"); balance != 250 {
+		t.Errorf("Expected redeemed points back, got %d", balance)
+	}
+
+	service.ReverseAccrual("user1", "order1")
+	if balance := service.Balance("user1"); balance != 0 {
+		t.Errorf("Expected accrual to be reversed, got %d", balance)
+	}
+
+	expected := []model.LoyaltyTransactionType{
+		model.LoyaltyTransactionAccrual,
+		model.LoyaltyTransactionRedemption,
+		model.LoyaltyTransactionRedemptionReversal,
+		model.LoyaltyTransactionAccrualReversal,
+	}
+	history := service.History("user1")
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d transactions, got %d", len(expected), len(history))
+	}
+	for i, transaction := range history {
+		if transaction.Type != expected[i] {
+			t.Errorf("Expected transaction %d to be %s, got %s", i, expected[i], transaction.Type)
+		}
+	}
+}
+
+func TestLoyaltyService_Expiry(t *testing.T) {
+	service := NewLoyaltyService(model.LoyaltyProgram{PointsPerUnit: 1.0, PointValue: 0.01, PointsTTL: 24 * time.Hour})
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	service.AccruePoints("user1", "order1", 100.0)
+	now = now.Add(12 * time.Hour)
+	service.AccruePoints("user1", "order2", 50.0)
+
+	// Redemption spends the lot closest to expiry first.
+	service.RedeemPoints("user1", "order3", 120, 100.0)
+
+	now = now.Add(13 * time.Hour)
+	if balance := service.Balance("user1"); balance != 30 {
+		t.Errorf("Expected 30 points of the second lot, got %d", balance)
+	}
+
+	// The first lot has expired, so points given back to it expire too.
+	service.ReverseRedemption("user1", "order3")
+	if balance := service.Balance("user1"); balance != 50 {
+		t.Errorf("Expected only the second lot's points back, got %d", balance)
+	}
+
+	history := service.History("user1")
+	last := history[len(history)-1]
+	if last.Type != model.LoyaltyTransactionExpiry || last.Points != 100 || last.OrderID != "order1" {
+		t.Errorf("Expected the first lot's points to expire, got %+v", last)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if balance := service.Balance("user1"); balance != 0 {
+		t.Errorf("Expected every point to expire, got %d", balance)
+	}
+}
+
+func TestLoyaltyService_ReverseAccrualClawsBackSpentPoints(t *testing.T) {
+	service := NewLoyaltyService(DefaultLoyaltyProgram())
+
+	service.AccruePoints("user1", "order1", 100.0)
+	service.AccruePoints("user1", "order2", 50.0)
+
+	// The lot of order1 expires first, so it pays for the redemption.
+	service.RedeemPoints("user1", "order3", 80, 100.0)
+
+	reversal, err := service.ReverseAccrual("user1", "order1")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if reversal.Points != 100 {
+		t.Errorf("Expected the whole accrual of 100 points to be reversed, got %d", reversal.Points)
+	}
+	if balance := service.Balance("user1"); balance != -30 {
+		t.Errorf("Expected the 80 spent points to be taken from the 50 left and 30 owed, got %d", balance)
+	}
+
+	if _, err := service.RedeemPoints("user1", "order4", 1, 100.0); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("Expected ErrInsufficientPoints while points are owed, got: %v", err)
+	}
+
+	service.AccruePoints("user1", "order5", 40.0)
+	if balance := service.Balance("user1"); balance != 10 {
+		t.Errorf("Expected the next accrual to settle the 30 owed points, got %d", balance)
+	}
+
+	// Giving the redemption back returns its points to the reversed lot, so
+	// the user ends up with the points of order2 and order5 only.
+	service.ReverseRedemption("user1", "order3")
+	if balance := service.Balance("user1"); balance != 90 {
+		t.Errorf("Expected 90 points once the redemption is reversed, got %d", balance)
+	}
+}
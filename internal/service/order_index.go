@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"homework/internal/model"
+)
+
+const (
+	defaultOrderPageSize = 20
+	maxOrderPageSize     = 100
+)
+
+// userOrderIndex maps a user to the IDs of their orders.
+type userOrderIndex struct {
+	mu     sync.RWMutex
+	orders map[string][]string
+}
+
+// statusOrderIndex maps a status to the IDs of orders currently in it. It is
+// updated under the lock of the order's shard, so it must never be held while
+// acquiring a shard lock.
+type statusOrderIndex struct {
+	mu     sync.RWMutex
+	orders map[model.OrderStatus]map[string]struct{}
+}
+
+// createdOrderIndex keeps order IDs sorted by creation time, then ID, so that
+// date ranges are answered with a binary search and listings sorted by
+// creation time can be read from a cursor on. Like the status index, it is
+// updated under the lock of the order's shard.
+type createdOrderIndex struct {
+	mu      sync.RWMutex
+	entries []createdEntry
+}
+
+type createdEntry struct {
+	createdAt time.Time
+	orderID   string
+}
+
+func (i *userOrderIndex) add(userID, orderID string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.orders[userID] = append(i.orders[userID], orderID)
+}
+
+func (i *userOrderIndex) get(userID string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]string(nil), i.orders[userID]...)
+}
+
+func (i *statusOrderIndex) move(orderID string, from, to model.OrderStatus) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if from != "" {
+		delete(i.orders[from], orderID)
+	}
+	if i.orders[to] == nil {
+		i.orders[to] = make(map[string]struct{})
+	}
+	i.orders[to][orderID] = struct{}{}
+}
+
+func (i *statusOrderIndex) get(statuses []model.OrderStatus) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var ids []string
+	for _, status := range statuses {
+		for id := range i.orders[status] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (i *statusOrderIndex) count(statuses []model.OrderStatus) int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	total := 0
+	for _, status := range statuses {
+		total += len(i.orders[status])
+	}
+	return total
+}
+
+func (i *createdOrderIndex) add(createdAt time.Time, orderID string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry := createdEntry{createdAt: createdAt, orderID: orderID}
+	position := sort.Search(len(i.entries), func(n int) bool {
+		return comparePositions(model.OrderSortByCreatedAt, i.entries[n].position(), entry.position()) > 0
+	})
+	i.entries = append(i.entries, createdEntry{})
+	copy(i.entries[position+1:], i.entries[position:])
+	i.entries[position] = entry
+}
+
+// between returns the IDs created in [from, to); zero bounds are open.
+func (i *createdOrderIndex) between(from, to time.Time) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(i.entries), func(n int) bool {
+			return !i.entries[n].createdAt.Before(from)
+		})
+	}
+	end := len(i.entries)
+	if !to.IsZero() {
+		end = sort.Search(len(i.entries), func(n int) bool {
+			return !i.entries[n].createdAt.Before(to)
+		})
+	}
+	if start >= end {
+		return nil
+	}
+
+	ids := make([]string, 0, end-start)
+	for _, entry := range i.entries[start:end] {
+		ids = append(ids, entry.orderID)
+	}
+	return ids
+}
+
+// scan returns up to n entries created in [from, to) that come after the
+// position in listing order, walking backwards when descending. A nil
+// position starts at the beginning of the listing.
+func (i *createdOrderIndex) scan(from, to time.Time, after *orderPosition, descending bool, n int) []createdEntry {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(i.entries), func(n int) bool {
+			return !i.entries[n].createdAt.Before(from)
+		})
+	}
+	end := len(i.entries)
+	if !to.IsZero() {
+		end = sort.Search(len(i.entries), func(n int) bool {
+			return !i.entries[n].createdAt.Before(to)
+		})
+	}
+
+	if after != nil {
+		// First entry past the cursor, in ascending order.
+		past := sort.Search(len(i.entries), func(n int) bool {
+			return comparePositions(model.OrderSortByCreatedAt, i.entries[n].position(), *after) > 0
+		})
+		if descending {
+			if past > 0 && comparePositions(model.OrderSortByCreatedAt, i.entries[past-1].position(), *after) == 0 {
+				past--
+			}
+			if past < end {
+				end = past
+			}
+		} else if past > start {
+			start = past
+		}
+	}
+
+	var entries []createdEntry
+	for k := 0; k < end-start && len(entries) < n; k++ {
+		index := start + k
+		if descending {
+			index = end - 1 - k
+		}
+		entries = append(entries, i.entries[index])
+	}
+	return entries
+}
+
+func (e createdEntry) position() orderPosition {
+	return orderPosition{createdAt: e.createdAt.UnixNano(), orderID: e.orderID}
+}
+
+// orderPosition is where an order falls in a listing: its sort keys and ID,
+// which breaks ties between orders with equal keys. A cursor encodes the
+// position of the last order of a page.
+type orderPosition struct {
+	createdAt int64
+	total     float64
+	orderID   string
+}
+
+func positionOf(order *model.Order) orderPosition {
+	return orderPosition{createdAt: order.CreatedAt.UnixNano(), total: order.Total, orderID: order.ID}
+}
+
+// comparePositions orders a and b ascending by sortBy, then by order ID.
+func comparePositions(sortBy model.OrderSortField, a, b orderPosition) int {
+	switch {
+	case sortBy == model.OrderSortByTotal && a.total != b.total:
+		if a.total < b.total {
+			return -1
+		}
+		return 1
+	case sortBy != model.OrderSortByTotal && a.createdAt != b.createdAt:
+		if a.createdAt < b.createdAt {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.orderID, b.orderID)
+}
+
+func encodeOrderCursor(sortBy model.OrderSortField, order *model.Order) string {
+	key := strconv.FormatInt(order.CreatedAt.UnixNano(), 10)
+	if sortBy == model.OrderSortByTotal {
+		key = strconv.FormatFloat(order.Total, 'g', -1, 64)
+	}
+
+	raw := fmt.Sprintf("%s|%s|%s", sortBy, key, order.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string, sortBy model.OrderSortField) (orderPosition, error) {
+	var position orderPosition
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 {
+		return position, fmt.Errorf("%w: malformed cursor", ErrInvalidCursor)
+	}
+
+	if model.OrderSortField(parts[0]) != sortBy {
+		return position, fmt.Errorf("%w: cursor was issued for sorting by %s", ErrInvalidCursor, parts[0])
+	}
+
+	if sortBy == model.OrderSortByTotal {
+		position.total, err = strconv.ParseFloat(parts[1], 64)
+	} else {
+		position.createdAt, err = strconv.ParseInt(parts[1], 10, 64)
+	}
+	if err != nil {
+		return position, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	position.orderID = parts[2]
+	return position, nil
+}
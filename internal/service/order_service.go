@@ -2,91 +2,506 @@ package service
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"homework/internal/model"
 )
 
+type TransitionHook func(order model.Order, change model.OrderStatusChange)
+
+// OrderService keeps orders in shards keyed by order ID. The user, status and
+// created-at indexes hold order IDs only and back ListOrders.
 type OrderService struct {
+	tenantID string
+
+	shards    [shardCount]*orderShard
+	byUser    [shardCount]*userOrderIndex
+	byStatus  *statusOrderIndex
+	byCreated *createdOrderIndex
+
+	mu       sync.RWMutex
+	hooks    []TransitionHook
+	currency atomic.Value
+}
+
+type orderShard struct {
 	mu     sync.RWMutex
 	orders map[string]*model.Order
 }
 
 func NewOrderService() *OrderService {
-	return &OrderService{
-		orders: make(map[string]*model.Order),
+	return NewOrderServiceForTenant("")
+}
+
+// NewOrderServiceForTenant returns a service holding the orders of one
+// tenant; every order it creates carries tenantID.
+func NewOrderServiceForTenant(tenantID string) *OrderService {
+	service := &OrderService{
+		tenantID:  tenantID,
+		byStatus:  &statusOrderIndex{orders: make(map[model.OrderStatus]map[string]struct{})},
+		byCreated: &createdOrderIndex{},
 	}
+
+	for i := range service.shards {
+		service.shards[i] = &orderShard{orders: make(map[string]*model.Order)}
+		service.byUser[i] = &userOrderIndex{orders: make(map[string][]string)}
+	}
+
+	return service
 }
 
-func (s *OrderService) CreateOrder(userID string, items []model.OrderItem) (*model.Order, error) {
+// SetCurrency sets the currency new orders are priced in.
+func (s *OrderService) SetCurrency(currency string) {
+	s.currency.Store(currency)
+}
+
+// loadCurrency returns the currency stored in value, or "" if none was set.
+func loadCurrency(value *atomic.Value) string {
+	currency, _ := value.Load().(string)
+	return currency
+}
+
+// OnTransition registers a hook called after every status change. Hooks run
+// outside the order lock and receive a snapshot of the order.
+func (s *OrderService) OnTransition(hook TransitionHook) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
 
-	order := &model.Order{
-		ID:        uuid.New().String(),
-		UserID:    userID,
-		Items:     items,
-		Status:    model.OrderStatusPending,
-		CreatedAt: time.Now(),
+func (s *OrderService) CreateOrder(userID string, items []model.OrderItem) (*model.Order, error) {
+	if userID == "" {
+		return nil, &OrderValidationError{Field: "user_id", Reason: "is required"}
+	}
+	if err := ValidateOrderItems(items); err != nil {
+		return nil, err
 	}
 
-	for _, item := range items {
-		order.Total += item.Price * float64(item.Quantity)
+	now := time.Now()
+	order := &model.Order{
+		ID:       uuid.New().String(),
+		TenantID: s.tenantID,
+		UserID:   userID,
+		Items:    make([]model.OrderItem, len(items)),
+		Status:   model.OrderStatusPending,
+		Currency: loadCurrency(&s.currency),
+		StatusHistory: []model.OrderStatusChange{
+			{To: model.OrderStatusPending, Reason: "order created", At: now},
+		},
+		Version:   1,
+		CreatedAt: now,
+	}
+	for i, item := range items {
+		item.Discount = 0
+		order.Items[i] = item
 	}
+	order.Total = order.Subtotal()
+
+	shard := s.shard(order.ID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.orders[order.ID] = order
+	s.byUser[shardIndex(userID)].add(userID, order.ID)
+	s.byStatus.move(order.ID, "", order.Status)
+	s.byCreated.add(order.CreatedAt, order.ID)
+
+	return order.Clone(), nil
+}
 
-	s.orders[order.ID] = order
-	return order, nil
+func (s *OrderService) MarkAwaitingPayment(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusAwaitingPayment, "payment requested")
 }
 
 func (s *OrderService) ConfirmOrder(orderID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.Transition(orderID, model.OrderStatusConfirmed, "payment captured")
+}
+
+func (s *OrderService) ShipOrder(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusShipped, "order shipped")
+}
+
+func (s *OrderService) DeliverOrder(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusDelivered, "order delivered")
+}
+
+func (s *OrderService) CancelOrder(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusCancelled, "order cancelled")
+}
+
+func (s *OrderService) FailOrder(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusFailed, "order failed")
+}
 
-	order, exists := s.orders[orderID]
+func (s *OrderService) RefundOrder(orderID string) error {
+	return s.Transition(orderID, model.OrderStatusRefunded, "order refunded")
+}
+
+func (s *OrderService) Transition(orderID string, to model.OrderStatus, reason string) error {
+	shard := s.shard(orderID)
+	shard.mu.Lock()
+
+	order, exists := shard.orders[orderID]
 	if !exists {
+		shard.mu.Unlock()
 		return fmt.Errorf("order not found: %s", orderID)
 	}
 
-	order.Status = model.OrderStatusConfirmed
+	change, err := transition(order, to, reason)
+	if err != nil {
+		shard.mu.Unlock()
+		return err
+	}
+	s.byStatus.move(orderID, change.From, change.To)
+
+	snapshot := order.Clone()
+	shard.mu.Unlock()
+	s.fireHooks(snapshot, change)
+
 	return nil
 }
 
-func (s *OrderService) CancelOrder(orderID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// SetShippingCost adds the shipping cost to the total of an order that has not
+// shipped yet. A paid order is requoted when it is modified.
+func (s *OrderService) SetShippingCost(orderID string, cost float64) (*model.Order, error) {
+	shard := s.shard(orderID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	order, exists := s.orders[orderID]
+	order, exists := shard.orders[orderID]
 	if !exists {
-		return fmt.Errorf("order not found: %s", orderID)
+		return nil, fmt.Errorf("order not found: %s", orderID)
 	}
 
-	order.Status = model.OrderStatusCancelled
-	return nil
+	switch order.Status {
+	case model.OrderStatusPending, model.OrderStatusAwaitingPayment, model.OrderStatusConfirmed:
+	default:
+		return nil, fmt.Errorf("shipping cost cannot be changed for order %s in status %s", orderID, order.Status)
+	}
+
+	order.ShippingCost = cost
+	order.Total = order.Subtotal() + cost
+	order.Version++
+
+	return order.Clone(), nil
 }
 
-func (s *OrderService) FailOrder(orderID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// SetPromoCode attaches a promo code to an order that has not been paid yet.
+// The code is redeemed when the discount is applied.
+func (s *OrderService) SetPromoCode(orderID, code string) (*model.Order, error) {
+	shard := s.shard(orderID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	order, exists := s.orders[orderID]
+	order, exists := shard.orders[orderID]
 	if !exists {
-		return fmt.Errorf("order not found: %s", orderID)
+		return nil, fmt.Errorf("order not found: %s", orderID)
 	}
 
-	order.Status = model.OrderStatusFailed
-	return nil
+	if order.Status != model.OrderStatusPending {
+		return nil, fmt.Errorf("promo code cannot be changed for order %s in status %s", orderID, order.Status)
+	}
+
+	order.PromoCode = code
+	order.Version++
+
+	return order.Clone(), nil
+}
+
+// SetLineDiscounts records how the order discount is split over the lines.
+// Lines without an allocation get no discount.
+func (s *OrderService) SetLineDiscounts(orderID string, lines []model.LineAllocation) (*model.Order, error) {
+	shard := s.shard(orderID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	order, exists := shard.orders[orderID]
+	if !exists {
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+
+	discounts := make([]float64, len(order.Items))
+	for _, line := range lines {
+		if line.Line < 0 || line.Line >= len(order.Items) || order.Items[line.Line].ProductID != line.ProductID {
+			return nil, fmt.Errorf("discount allocation does not match line %d of order %s", line.Line, orderID)
+		}
+		discounts[line.Line] += line.Amount
+	}
+
+	for i := range order.Items {
+		order.Items[i].Discount = discounts[i]
+	}
+	order.Version++
+
+	return order.Clone(), nil
 }
 
 func (s *OrderService) GetOrder(orderID string) (*model.Order, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	shard := s.shard(orderID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	order, exists := s.orders[orderID]
+	order, exists := shard.orders[orderID]
 	if !exists {
 		return nil, fmt.Errorf("order not found: %s", orderID)
 	}
 
-	return order, nil
+	return order.Clone(), nil
+}
+
+// ListOrders returns one page of the orders matching query.Filter, sorted by
+// query.SortBy (creation time by default). Listings by creation time walk the
+// created index from the cursor and stop once the page is full. Otherwise
+// candidates come from the narrowest index that applies to the filter, so a
+// listing never scans every shard.
+func (s *OrderService) ListOrders(query model.OrderQuery) (*model.OrderPage, error) {
+	sortBy := query.SortBy
+	switch sortBy {
+	case "":
+		sortBy = model.OrderSortByCreatedAt
+	case model.OrderSortByCreatedAt, model.OrderSortByTotal:
+	default:
+		return nil, fmt.Errorf("unsupported sort field: %s", sortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultOrderPageSize
+	}
+	if limit > maxOrderPageSize {
+		limit = maxOrderPageSize
+	}
+
+	var after *orderPosition
+	if query.Cursor != "" {
+		position, err := decodeOrderCursor(query.Cursor, sortBy)
+		if err != nil {
+			return nil, err
+		}
+		after = &position
+	}
+
+	var orders []*model.Order
+	if sortBy == model.OrderSortByCreatedAt && query.Filter.UserID == "" {
+		orders = s.scanByCreated(query, after, limit+1)
+	} else {
+		orders = s.sortedCandidates(query, sortBy, after)
+	}
+
+	page := &model.OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		page.NextCursor = encodeOrderCursor(sortBy, page.Orders[limit-1])
+	}
+
+	return page, nil
+}
+
+// scanByCreated reads orders from the created index in listing order, from
+// the cursor on, until it has found n that match the filter.
+func (s *OrderService) scanByCreated(query model.OrderQuery, after *orderPosition, n int) []*model.Order {
+	var orders []*model.Order
+	for len(orders) < n {
+		entries := s.byCreated.scan(query.Filter.CreatedFrom, query.Filter.CreatedTo, after, query.Descending, n)
+		for _, entry := range entries {
+			order, err := s.GetOrder(entry.orderID)
+			if err == nil && query.Filter.Matches(order) {
+				orders = append(orders, order)
+				if len(orders) == n {
+					break
+				}
+			}
+		}
+
+		if len(entries) < n {
+			break
+		}
+		last := entries[len(entries)-1].position()
+		after = &last
+	}
+	return orders
+}
+
+// sortedCandidates reads every candidate order for the filter that comes
+// after the cursor and sorts them.
+func (s *OrderService) sortedCandidates(query model.OrderQuery, sortBy model.OrderSortField, after *orderPosition) []*model.Order {
+	seen := make(map[string]bool)
+	var orders []*model.Order
+	for _, orderID := range s.candidateOrderIDs(query.Filter) {
+		if seen[orderID] {
+			continue
+		}
+		seen[orderID] = true
+
+		order, err := s.GetOrder(orderID)
+		if err != nil || !query.Filter.Matches(order) {
+			continue
+		}
+
+		if after != nil {
+			cmp := comparePositions(sortBy, positionOf(order), *after)
+			if (query.Descending && cmp >= 0) || (!query.Descending && cmp <= 0) {
+				continue
+			}
+		}
+
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		cmp := comparePositions(sortBy, positionOf(orders[i]), positionOf(orders[j]))
+		if query.Descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	return orders
+}
+
+func (s *OrderService) candidateOrderIDs(filter model.OrderFilter) []string {
+	if filter.UserID != "" {
+		return s.byUser[shardIndex(filter.UserID)].get(filter.UserID)
+	}
+
+	if len(filter.Statuses) == 0 {
+		return s.byCreated.between(filter.CreatedFrom, filter.CreatedTo)
+	}
+
+	if filter.CreatedFrom.IsZero() && filter.CreatedTo.IsZero() {
+		return s.byStatus.get(filter.Statuses)
+	}
+
+	inRange := s.byCreated.between(filter.CreatedFrom, filter.CreatedTo)
+	if len(inRange) <= s.byStatus.count(filter.Statuses) {
+		return inRange
+	}
+	return s.byStatus.get(filter.Statuses)
+}
+
+// UpdateOrder applies update to a copy of the order and stores it only if the
+// order is still at expectedVersion. Items and status are taken from the
+// copy; the total is recomputed from the items and a status change must be a
+// legal transition.
+func (s *OrderService) UpdateOrder(orderID string, expectedVersion int64, update func(*model.Order) error) (*model.Order, error) {
+	shard := s.shard(orderID)
+	shard.mu.Lock()
+
+	order, exists := shard.orders[orderID]
+	if !exists {
+		shard.mu.Unlock()
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+
+	if order.Version != expectedVersion {
+		shard.mu.Unlock()
+		return nil, &ConcurrentModificationError{
+			Entity:          "order",
+			ID:              orderID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   order.Version,
+		}
+	}
+
+	updated := order.Clone()
+	if err := update(updated); err != nil {
+		shard.mu.Unlock()
+		return nil, err
+	}
+
+	if err := ValidateOrderItems(updated.Items); err != nil {
+		shard.mu.Unlock()
+		return nil, err
+	}
+
+	if updated.Status != order.Status && !order.Status.CanTransitionTo(updated.Status) {
+		shard.mu.Unlock()
+		return nil, &InvalidTransitionError{OrderID: orderID, From: order.Status, To: updated.Status}
+	}
+
+	order.Items = append([]model.OrderItem(nil), updated.Items...)
+	order.Total = order.Subtotal() + order.ShippingCost
+
+	statusChanged := updated.Status != order.Status
+	var change model.OrderStatusChange
+	if statusChanged {
+		change, _ = transition(order, updated.Status, "order updated")
+		s.byStatus.move(orderID, change.From, change.To)
+	} else {
+		order.Version++
+	}
+
+	snapshot := order.Clone()
+	shard.mu.Unlock()
+
+	if statusChanged {
+		s.fireHooks(snapshot, change)
+	}
+
+	return snapshot.Clone(), nil
+}
+
+// ValidateOrderItems checks that an order has at least one line and that every
+// line names a product, orders a positive quantity and has a valid price.
+func ValidateOrderItems(items []model.OrderItem) error {
+	if len(items) == 0 {
+		return &OrderValidationError{Field: "items", Reason: "must not be empty"}
+	}
+
+	for i, item := range items {
+		if item.ProductID == "" {
+			return &OrderValidationError{Field: fmt.Sprintf("items[%d].product_id", i), Reason: "is required"}
+		}
+		if item.Quantity <= 0 {
+			return &OrderValidationError{
+				Field:  fmt.Sprintf("items[%d].quantity", i),
+				Reason: fmt.Sprintf("must be positive, got %d", item.Quantity),
+			}
+		}
+		if item.Price < 0 || math.IsNaN(item.Price) || math.IsInf(item.Price, 0) {
+			return &OrderValidationError{
+				Field:  fmt.Sprintf("items[%d].price", i),
+				Reason: fmt.Sprintf("must be a non-negative amount, got %v", item.Price),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *OrderService) fireHooks(order *model.Order, change model.OrderStatusChange) {
+	s.mu.RLock()
+	hooks := append([]TransitionHook(nil), s.hooks...)
+	s.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(*order.Clone(), change)
+	}
+}
+
+func (s *OrderService) shard(orderID string) *orderShard {
+	return s.shards[shardIndex(orderID)]
+}
+
+func transition(order *model.Order, to model.OrderStatus, reason string) (model.OrderStatusChange, error) {
+	if !order.Status.CanTransitionTo(to) {
+		return model.OrderStatusChange{}, &InvalidTransitionError{OrderID: order.ID, From: order.Status, To: to}
+	}
+
+	change := model.OrderStatusChange{
+		From:   order.Status,
+		To:     to,
+		Reason: reason,
+		At:     time.Now(),
+	}
+
+	order.Status = to
+	order.StatusHistory = append(order.StatusHistory, change)
+	order.Version++
+	return change, nil
 }
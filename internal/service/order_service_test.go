@@ -1,7 +1,12 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"homework/internal/model"
 )
@@ -42,6 +47,7 @@ func TestOrderService_ConfirmOrder(t *testing.T) {
 		{ProductID: "product1", Quantity: 1, Price: 100.0},
 	}
 	order, _ := service.CreateOrder("user1", items)
+	service.MarkAwaitingPayment(order.ID)
 
 	err := service.ConfirmOrder(order.ID)
 	if err != nil {
@@ -71,3 +77,339 @@ func TestOrderService_CancelOrder(t *testing.T) {
 		t.Errorf("Expected status %s, got %s", model.OrderStatusCancelled, cancelledOrder.Status)
 	}
 }
+
+func TestOrderService_GetOrder_ReturnsCopy(t *testing.T) {
+	service := NewOrderService()
+	order, _ := service.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+
+	fetched, _ := service.GetOrder(order.ID)
+	fetched.Status = model.OrderStatusConfirmed
+	fetched.Items[0].Quantity = 10
+
+	stored, _ := service.GetOrder(order.ID)
+	if stored.Status != model.OrderStatusPending || stored.Items[0].Quantity != 1 {
+		t.Errorf("Expected stored order to be unaffected, got status %s and quantity %d",
+			stored.Status, stored.Items[0].Quantity)
+	}
+}
+
+func TestOrderService_UpdateOrder_VersionConflict(t *testing.T) {
+	service := NewOrderService()
+	order, _ := service.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+
+	updated, err := service.UpdateOrder(order.ID, order.Version, func(o *model.Order) error {
+		o.Items[0].Quantity = 3
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if updated.Total != 300.0 || updated.Version != order.Version+1 {
+		t.Errorf("Expected total 300.00 at version %d, got %.2f at version %d",
+			order.Version+1, updated.Total, updated.Version)
+	}
+
+	_, err = service.UpdateOrder(order.ID, order.Version, func(o *model.Order) error {
+		o.Items[0].Quantity = 5
+		return nil
+	})
+
+	var conflict *ConcurrentModificationError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConcurrentModificationError, got: %v", err)
+	}
+
+	if conflict.ActualVersion != updated.Version {
+		t.Errorf("Expected actual version %d, got %d", updated.Version, conflict.ActualVersion)
+	}
+}
+
+func TestOrderService_IllegalTransition(t *testing.T) {
+	service := NewOrderService()
+	order, _ := service.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	service.CancelOrder(order.ID)
+
+	err := service.ConfirmOrder(order.ID)
+
+	var invalid *InvalidTransitionError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected InvalidTransitionError, got: %v", err)
+	}
+
+	if invalid.From != model.OrderStatusCancelled || invalid.To != model.OrderStatusConfirmed {
+		t.Errorf("Expected cancelled -> confirmed, got %s -> %s", invalid.From, invalid.To)
+	}
+
+	stored, _ := service.GetOrder(order.ID)
+	if stored.Status != model.OrderStatusCancelled {
+		t.Errorf("Expected status %s, got %s", model.OrderStatusCancelled, stored.Status)
+	}
+}
+
+func TestOrderService_StatusHistoryAndHooks(t *testing.T) {
+	service := NewOrderService()
+
+	var fired []model.OrderStatusChange
+	service.OnTransition(func(order model.Order, change model.OrderStatusChange) {
+		fired = append(fired, change)
+	})
+
+	order, _ := service.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	service.MarkAwaitingPayment(order.ID)
+	service.ConfirmOrder(order.ID)
+	service.ShipOrder(order.ID)
+	service.DeliverOrder(order.ID)
+
+	stored, _ := service.GetOrder(order.ID)
+	expected := []model.OrderStatus{
+		model.OrderStatusPending,
+		model.OrderStatusAwaitingPayment,
+		model.OrderStatusConfirmed,
+		model.OrderStatusShipped,
+		model.OrderStatusDelivered,
+	}
+	if len(stored.StatusHistory) != len(expected) {
+		t.Fatalf("Expected %d history entries, got %d", len(expected), len(stored.StatusHistory))
+	}
+	for i, status := range expected {
+		if stored.StatusHistory[i].To != status {
+			t.Errorf("Expected history entry %d to be %s, got %s", i, status, stored.StatusHistory[i].To)
+		}
+		if stored.StatusHistory[i].At.IsZero() {
+			t.Errorf("Expected history entry %d to have a timestamp", i)
+		}
+	}
+
+	if len(fired) != 4 {
+		t.Errorf("Expected 4 hook calls, got %d", len(fired))
+	}
+}
+
+func TestOrderService_ListOrders_FiltersAndPagination(t *testing.T) {
+	service := NewOrderService()
+	start := time.Now()
+
+	var created []*model.Order
+	for i := 1; i <= 5; i++ {
+		order, _ := service.CreateOrder("user1", []model.OrderItem{
+			{ProductID: "product1", Quantity: i, Price: 10.0},
+		})
+		created = append(created, order)
+	}
+	service.CreateOrder("user2", []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 10.0}})
+	service.MarkAwaitingPayment(created[1].ID)
+	service.CancelOrder(created[3].ID)
+
+	var listed []string
+	cursor := ""
+	for {
+		page, err := service.ListOrders(model.OrderQuery{
+			Filter: model.OrderFilter{UserID: "user1"},
+			SortBy: model.OrderSortByTotal,
+			Limit:  2,
+			Cursor: cursor,
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, order := range page.Orders {
+			listed = append(listed, order.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(listed) != len(created) {
+		t.Fatalf("Expected %d orders, got %d", len(created), len(listed))
+	}
+	for i, order := range created {
+		if listed[i] != order.ID {
+			t.Errorf("Expected order %d to be %s, got %s", i, order.ID, listed[i])
+		}
+	}
+
+	page, _ := service.ListOrders(model.OrderQuery{
+		Filter: model.OrderFilter{Statuses: []model.OrderStatus{model.OrderStatusPending}, CreatedFrom: start},
+	})
+	if len(page.Orders) != 4 {
+		t.Errorf("Expected 4 pending orders, got %d", len(page.Orders))
+	}
+
+	page, _ = service.ListOrders(model.OrderQuery{
+		Filter:     model.OrderFilter{Statuses: []model.OrderStatus{model.OrderStatusCancelled}},
+		Descending: true,
+	})
+	if len(page.Orders) != 1 || page.Orders[0].ID != created[3].ID {
+		t.Errorf("Expected only the cancelled order, got %d orders", len(page.Orders))
+	}
+
+	page, _ = service.ListOrders(model.OrderQuery{Filter: model.OrderFilter{CreatedTo: start}})
+	if len(page.Orders) != 0 {
+		t.Errorf("Expected no orders before %v, got %d", start, len(page.Orders))
+	}
+}
+
+func TestOrderService_ListOrders_CreatedIndexPages(t *testing.T) {
+	service := NewOrderService()
+
+	var cancelled []*model.Order
+	var all []*model.Order
+	for i := 0; i < 25; i++ {
+		order, _ := service.CreateOrder(fmt.Sprintf("user%d", i%4), []model.OrderItem{
+			{ProductID: "product1", Quantity: 1, Price: 10.0},
+		})
+		all = append(all, order)
+		if i%3 == 0 {
+			service.CancelOrder(order.ID)
+			cancelled = append(cancelled, order)
+		}
+	}
+
+	byCreated := func(orders []*model.Order, descending bool) []string {
+		sorted := append([]*model.Order(nil), orders...)
+		sort.Slice(sorted, func(i, j int) bool {
+			cmp := comparePositions(model.OrderSortByCreatedAt, positionOf(sorted[i]), positionOf(sorted[j]))
+			return (cmp < 0) != descending
+		})
+		ids := make([]string, len(sorted))
+		for i, order := range sorted {
+			ids[i] = order.ID
+		}
+		return ids
+	}
+
+	listAll := func(query model.OrderQuery) []string {
+		var ids []string
+		for {
+			page, err := service.ListOrders(query)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			for _, order := range page.Orders {
+				ids = append(ids, order.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+
+	cases := []struct {
+		name     string
+		query    model.OrderQuery
+		expected []string
+	}{
+		{"ascending", model.OrderQuery{Limit: 7}, byCreated(all, false)},
+		{"descending", model.OrderQuery{Limit: 4, Descending: true}, byCreated(all, true)},
+		{"cancelled descending", model.OrderQuery{
+			Filter:     model.OrderFilter{Statuses: []model.OrderStatus{model.OrderStatusCancelled}},
+			Limit:      2,
+			Descending: true,
+		}, byCreated(cancelled, true)},
+	}
+	for _, c := range cases {
+		listed := listAll(c.query)
+		if strings.Join(listed, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, listed)
+		}
+	}
+}
+
+func TestOrderService_ListOrders_InvalidCursor(t *testing.T) {
+	service := NewOrderService()
+	for i := 0; i < 3; i++ {
+		service.CreateOrder("user1", []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 10.0}})
+	}
+
+	page, _ := service.ListOrders(model.OrderQuery{Limit: 1})
+
+	_, err := service.ListOrders(model.OrderQuery{SortBy: model.OrderSortByTotal, Limit: 1, Cursor: page.NextCursor})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for a cursor from another sort, got: %v", err)
+	}
+
+	_, err = service.ListOrders(model.OrderQuery{Cursor: "not a cursor"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got: %v", err)
+	}
+}
+
+func TestOrderService_CreateOrder_Validation(t *testing.T) {
+	service := NewOrderService()
+	valid := model.OrderItem{ProductID: "product1", Quantity: 1, Price: 100.0}
+
+	tests := []struct {
+		name   string
+		userID string
+		items  []model.OrderItem
+		field  string
+	}{
+		{"empty user", "", []model.OrderItem{valid}, "user_id"},
+		{"no items", "user1", nil, "items"},
+		{"empty product", "user1", []model.OrderItem{{Quantity: 1, Price: 10.0}}, "items[0].product_id"},
+		{"zero quantity", "user1", []model.OrderItem{valid, {ProductID: "product2", Price: 10.0}}, "items[1].quantity"},
+		{"negative quantity", "user1", []model.OrderItem{{ProductID: "product1", Quantity: -1, Price: 10.0}}, "items[0].quantity"},
+		{"negative price", "user1", []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: -10.0}}, "items[0].price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateOrder(tt.userID, tt.items)
+			if !errors.Is(err, ErrInvalidOrder) {
+				t.Fatalf("Expected ErrInvalidOrder, got: %v", err)
+			}
+
+			var validationErr *OrderValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.field {
+				t.Errorf("Expected invalid field %s, got: %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestOrderService_SetLineDiscounts(t *testing.T) {
+	service := NewOrderService()
+	order, _ := service.CreateOrder("user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 50.0},
+		{ProductID: "product2", Quantity: 1, Price: 20.0, Discount: 5.0},
+	})
+
+	if order.DiscountTotal() != 0 {
+		t.Errorf("Expected caller-provided discounts to be ignored, got %.2f", order.DiscountTotal())
+	}
+
+	updated, err := service.SetLineDiscounts(order.ID, []model.LineAllocation{
+		{Line: 0, ProductID: "product1", Amount: 12.5},
+		{Line: 1, ProductID: "product2", Amount: 2.5},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if updated.Items[0].Discount != 12.5 || updated.Items[1].Discount != 2.5 {
+		t.Errorf("Unexpected line discounts: %v", updated.Items)
+	}
+	if updated.DiscountTotal() != 15.0 {
+		t.Errorf("Expected discount total 15.00, got %.2f", updated.DiscountTotal())
+	}
+	if updated.Version != order.Version+1 {
+		t.Errorf("Expected version %d, got %d", order.Version+1, updated.Version)
+	}
+
+	_, err = service.SetLineDiscounts(order.ID, []model.LineAllocation{{Line: 1, ProductID: "product1", Amount: 1.0}})
+	if err == nil {
+		t.Error("Expected error for an allocation that does not match the line")
+	}
+}
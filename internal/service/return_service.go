@@ -0,0 +1,254 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"homework/internal/model"
+)
+
+type ReturnService struct {
+	tenantID string
+
+	mu      sync.RWMutex
+	returns map[string]*model.ReturnRequest
+	byOrder map[string][]*model.ReturnRequest
+}
+
+func NewReturnService() *ReturnService {
+	return NewReturnServiceForTenant("")
+}
+
+// NewReturnServiceForTenant returns a service holding the returns of one
+// tenant. It only accepts returns of that tenant's orders.
+func NewReturnServiceForTenant(tenantID string) *ReturnService {
+	return &ReturnService{
+		tenantID: tenantID,
+		returns:  make(map[string]*model.ReturnRequest),
+		byOrder:  make(map[string][]*model.ReturnRequest),
+	}
+}
+
+// RequestReturn opens a return for some line items of the order. Quantities
+// already covered by other open or completed returns cannot be requested
+// again.
+func (s *ReturnService) RequestReturn(order *model.Order, items []model.ReturnItem, reason string) (*model.ReturnRequest, error) {
+	if order.TenantID != s.tenantID {
+		return nil, fmt.Errorf("order %s belongs to another tenant", order.ID)
+	}
+
+	switch order.Status {
+	case model.OrderStatusConfirmed, model.OrderStatusShipped, model.OrderStatusDelivered:
+	default:
+		return nil, fmt.Errorf("order %s cannot be returned in status %s", order.ID, order.Status)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("return for order %s has no items", order.ID)
+	}
+
+	ordered := make(map[string]int)
+	for _, item := range order.Items {
+		ordered[item.ProductID] += item.Quantity
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requested := s.requestedQuantities(order.ID)
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("invalid return quantity for product %s: %d", item.ProductID, item.Quantity)
+		}
+
+		requested[item.ProductID] += item.Quantity
+		if requested[item.ProductID] > ordered[item.ProductID] {
+			return nil, fmt.Errorf("return exceeds ordered quantity for product %s: requested %d, ordered %d",
+				item.ProductID, requested[item.ProductID], ordered[item.ProductID])
+		}
+	}
+
+	now := time.Now()
+	request := &model.ReturnRequest{
+		ID:       uuid.New().String(),
+		TenantID: s.tenantID,
+		OrderID:  order.ID,
+		UserID:   order.UserID,
+		Items:    append([]model.ReturnItem(nil), items...),
+		Reason:   reason,
+		Status:   model.ReturnStatusRequested,
+		StatusHistory: []model.ReturnStatusChange{
+			{To: model.ReturnStatusRequested, Reason: reason, At: now},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	for i := range request.Items {
+		request.Items[i].Sellable = 0
+	}
+
+	s.returns[request.ID] = request
+	s.byOrder[order.ID] = append(s.byOrder[order.ID], request)
+	return request.Clone(), nil
+}
+
+func (s *ReturnService) ApproveReturn(returnID string) error {
+	return s.transition(returnID, model.ReturnStatusApproved, "return approved")
+}
+
+func (s *ReturnService) RejectReturn(returnID, reason string) error {
+	return s.transition(returnID, model.ReturnStatusRejected, reason)
+}
+
+func (s *ReturnService) MarkReceived(returnID string) error {
+	return s.transition(returnID, model.ReturnStatusReceived, "items received")
+}
+
+// RecordInspection stores how many units of each returned product can be
+// sold again; the remainder is written off.
+func (s *ReturnService) RecordInspection(returnID string, sellable map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, exists := s.returns[returnID]
+	if !exists {
+		return fmt.Errorf("return not found: %s", returnID)
+	}
+
+	if !request.Status.CanTransitionTo(model.ReturnStatusInspected) {
+		return fmt.Errorf("invalid transition for return %s: %s -> %s", returnID, request.Status, model.ReturnStatusInspected)
+	}
+
+	remaining := make(map[string]int, len(sellable))
+	for productID, quantity := range sellable {
+		remaining[productID] = quantity
+	}
+
+	items := append([]model.ReturnItem(nil), request.Items...)
+	for i := range items {
+		units := remaining[items[i].ProductID]
+		if units > items[i].Quantity {
+			units = items[i].Quantity
+		}
+		if units < 0 {
+			return fmt.Errorf("invalid sellable quantity for product %s: %d", items[i].ProductID, units)
+		}
+		items[i].Sellable = units
+		remaining[items[i].ProductID] -= units
+	}
+
+	for productID, left := range remaining {
+		if left > 0 {
+			return fmt.Errorf("sellable quantity exceeds returned quantity for product %s", productID)
+		}
+	}
+
+	request.Items = items
+	s.applyTransition(request, model.ReturnStatusInspected, "items inspected")
+	return nil
+}
+
+func (s *ReturnService) CompleteReturn(returnID string, refundAmount float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, exists := s.returns[returnID]
+	if !exists {
+		return fmt.Errorf("return not found: %s", returnID)
+	}
+
+	if !request.Status.CanTransitionTo(model.ReturnStatusCompleted) {
+		return fmt.Errorf("invalid transition for return %s: %s -> %s", returnID, request.Status, model.ReturnStatusCompleted)
+	}
+
+	request.RefundAmount = refundAmount
+	s.applyTransition(request, model.ReturnStatusCompleted, "refund issued")
+	return nil
+}
+
+func (s *ReturnService) GetReturn(returnID string) (*model.ReturnRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	request, exists := s.returns[returnID]
+	if !exists {
+		return nil, fmt.Errorf("return not found: %s", returnID)
+	}
+
+	return request.Clone(), nil
+}
+
+func (s *ReturnService) GetReturnsByOrder(orderID string) []*model.ReturnRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	requests := make([]*model.ReturnRequest, 0, len(s.byOrder[orderID]))
+	for _, request := range s.byOrder[orderID] {
+		requests = append(requests, request.Clone())
+	}
+
+	return requests
+}
+
+// ReturnedQuantities sums the units of completed returns per product.
+func (s *ReturnService) ReturnedQuantities(orderID string) map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	returned := make(map[string]int)
+	for _, request := range s.byOrder[orderID] {
+		if request.Status != model.ReturnStatusCompleted {
+			continue
+		}
+		for _, item := range request.Items {
+			returned[item.ProductID] += item.Quantity
+		}
+	}
+
+	return returned
+}
+
+func (s *ReturnService) transition(returnID string, to model.ReturnStatus, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, exists := s.returns[returnID]
+	if !exists {
+		return fmt.Errorf("return not found: %s", returnID)
+	}
+
+	if !request.Status.CanTransitionTo(to) {
+		return fmt.Errorf("invalid transition for return %s: %s -> %s", returnID, request.Status, to)
+	}
+
+	s.applyTransition(request, to, reason)
+	return nil
+}
+
+func (s *ReturnService) applyTransition(request *model.ReturnRequest, to model.ReturnStatus, reason string) {
+	now := time.Now()
+	request.StatusHistory = append(request.StatusHistory, model.ReturnStatusChange{
+		From:   request.Status,
+		To:     to,
+		Reason: reason,
+		At:     now,
+	})
+	request.Status = to
+	request.UpdatedAt = now
+}
+
+func (s *ReturnService) requestedQuantities(orderID string) map[string]int {
+	requested := make(map[string]int)
+	for _, request := range s.byOrder[orderID] {
+		if request.Status == model.ReturnStatusRejected {
+			continue
+		}
+		for _, item := range request.Items {
+			requested[item.ProductID] += item.Quantity
+		}
+	}
+	return requested
+}
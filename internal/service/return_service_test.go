@@ -0,0 +1,85 @@
+package service
+
+import (
+	"testing"
+
+	"homework/internal/model"
+)
+
+func confirmedTestOrder() *model.Order {
+	return &model.Order{
+		ID:     "order1",
+		UserID: "user1",
+		Status: model.OrderStatusConfirmed,
+		Items: []model.OrderItem{
+			{ProductID: "product1", Quantity: 2, Price: 100.0},
+			{ProductID: "product2", Quantity: 1, Price: 50.0},
+		},
+		Total: 250.0,
+	}
+}
+
+func TestReturnService_Workflow(t *testing.T) {
+	service := NewReturnService()
+
+	request, err := service.RequestReturn(confirmedTestOrder(), []model.ReturnItem{
+		{ProductID: "product1", Quantity: 2},
+	}, "wrong size")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if request.Status != model.ReturnStatusRequested {
+		t.Errorf("Expected status %s, got %s", model.ReturnStatusRequested, request.Status)
+	}
+
+	if err := service.MarkReceived(request.ID); err == nil {
+		t.Error("Expected receiving an unapproved return to fail")
+	}
+
+	service.ApproveReturn(request.ID)
+	service.MarkReceived(request.ID)
+	if err := service.RecordInspection(request.ID, map[string]int{"product1": 1}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	inspected, _ := service.GetReturn(request.ID)
+	if inspected.Status != model.ReturnStatusInspected {
+		t.Errorf("Expected status %s, got %s", model.ReturnStatusInspected, inspected.Status)
+	}
+
+	if inspected.Items[0].Sellable != 1 {
+		t.Errorf("Expected 1 sellable unit, got %d", inspected.Items[0].Sellable)
+	}
+
+	if len(inspected.StatusHistory) != 4 {
+		t.Errorf("Expected 4 status changes, got %d", len(inspected.StatusHistory))
+	}
+}
+
+func TestReturnService_RequestReturn_ExceedsOrdered(t *testing.T) {
+	service := NewReturnService()
+	order := confirmedTestOrder()
+
+	_, err := service.RequestReturn(order, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 1},
+	}, "damaged")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	_, err = service.RequestReturn(order, []model.ReturnItem{
+		{ProductID: "product1", Quantity: 2},
+	}, "damaged")
+	if err == nil {
+		t.Error("Expected error when returning more than was ordered")
+	}
+
+	order.Status = model.OrderStatusCancelled
+	_, err = service.RequestReturn(order, []model.ReturnItem{
+		{ProductID: "product2", Quantity: 1},
+	}, "damaged")
+	if err == nil {
+		t.Error("Expected error for return of a cancelled order")
+	}
+}
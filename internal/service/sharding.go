@@ -0,0 +1,32 @@
+package service
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+const shardCount = 64
+
+func shardIndex(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % shardCount)
+}
+
+// shardIndexes returns the distinct shards owning keys in ascending order.
+// Operations spanning several shards must lock them in this order to avoid
+// deadlocks.
+func shardIndexes(keys []string) []int {
+	seen := make(map[int]bool, len(keys))
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		index := shardIndex(key)
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+
+	sort.Ints(indexes)
+	return indexes
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"homework/internal/model"
+)
+
+// RateTable quotes every carrier able to ship the items. Implementations can
+// be swapped to plug in real carrier pricing.
+type RateTable interface {
+	Quote(items []model.OrderItem) []model.ShippingQuote
+}
+
+type CarrierRate struct {
+	Base          float64
+	PerUnit       float64
+	EstimatedDays int
+}
+
+// FlatRateTable prices a shipment as a base fee plus a fee per unit.
+type FlatRateTable map[string]CarrierRate
+
+func (t FlatRateTable) Quote(items []model.OrderItem) []model.ShippingQuote {
+	units := 0
+	for _, item := range items {
+		units += item.Quantity
+	}
+
+	quotes := make([]model.ShippingQuote, 0, len(t))
+	for carrier, rate := range t {
+		quotes = append(quotes, model.ShippingQuote{
+			Carrier:       carrier,
+			Cost:          rate.Base + rate.PerUnit*float64(units),
+			EstimatedDays: rate.EstimatedDays,
+		})
+	}
+	return quotes
+}
+
+func DefaultRateTable() FlatRateTable {
+	return FlatRateTable{
+		"standard": {Base: 5.0, PerUnit: 1.0, EstimatedDays: 5},
+		"express":  {Base: 15.0, PerUnit: 2.0, EstimatedDays: 2},
+	}
+}
+
+// DefaultWarehouse ships every product that has not been assigned to a
+// warehouse.
+const DefaultWarehouse = "main"
+
+// WarehouseItems are the items of an order shipped from one warehouse.
+type WarehouseItems struct {
+	Warehouse string
+	Items     []model.OrderItem
+}
+
+type ShippingService struct {
+	tenantID string
+	rates    RateTable
+
+	shards  [shardCount]*shipmentShard
+	byOrder [shardCount]*orderShipmentIndex
+
+	warehouseMu sync.RWMutex
+	warehouses  map[string]string
+}
+
+type shipmentShard struct {
+	mu        sync.RWMutex
+	shipments map[string]*model.Shipment
+}
+
+type orderShipmentIndex struct {
+	mu        sync.RWMutex
+	shipments map[string][]string
+}
+
+func NewShippingService(rates RateTable) *ShippingService {
+	return NewShippingServiceForTenant("", rates)
+}
+
+// NewShippingServiceForTenant returns a service holding the shipments of one
+// tenant, priced with the tenant's rates.
+func NewShippingServiceForTenant(tenantID string, rates RateTable) *ShippingService {
+	service := &ShippingService{tenantID: tenantID, rates: rates, warehouses: make(map[string]string)}
+
+	for i := 0; i < shardCount; i++ {
+		service.shards[i] = &shipmentShard{shipments: make(map[string]*model.Shipment)}
+		service.byOrder[i] = &orderShipmentIndex{shipments: make(map[string][]string)}
+	}
+
+	return service
+}
+
+// SelectCarrier picks the cheapest quote, preferring the faster carrier on a
+// tie and the carrier name after that so the choice is deterministic.
+func (s *ShippingService) SelectCarrier(items []model.OrderItem) (model.ShippingQuote, error) {
+	quotes := s.rates.Quote(items)
+	if len(quotes) == 0 {
+		return model.ShippingQuote{}, fmt.Errorf("no carrier available for shipment")
+	}
+
+	sort.Slice(quotes, func(i, j int) bool {
+		if quotes[i].Cost != quotes[j].Cost {
+			return quotes[i].Cost < quotes[j].Cost
+		}
+		if quotes[i].EstimatedDays != quotes[j].EstimatedDays {
+			return quotes[i].EstimatedDays < quotes[j].EstimatedDays
+		}
+		return quotes[i].Carrier < quotes[j].Carrier
+	})
+
+	return quotes[0], nil
+}
+
+// SetProductWarehouse makes warehouse ship productID.
+func (s *ShippingService) SetProductWarehouse(productID, warehouse string) {
+	s.warehouseMu.Lock()
+	defer s.warehouseMu.Unlock()
+	s.warehouses[productID] = warehouse
+}
+
+func (s *ShippingService) GetProductWarehouse(productID string) string {
+	s.warehouseMu.RLock()
+	defer s.warehouseMu.RUnlock()
+
+	if warehouse, exists := s.warehouses[productID]; exists {
+		return warehouse
+	}
+	return DefaultWarehouse
+}
+
+// SplitByWarehouse groups items by the warehouse shipping them, ordered by
+// warehouse name so the split is deterministic.
+func (s *ShippingService) SplitByWarehouse(items []model.OrderItem) []WarehouseItems {
+	byWarehouse := make(map[string][]model.OrderItem)
+	for _, item := range items {
+		warehouse := s.GetProductWarehouse(item.ProductID)
+		byWarehouse[warehouse] = append(byWarehouse[warehouse], item)
+	}
+
+	groups := make([]WarehouseItems, 0, len(byWarehouse))
+	for warehouse, items := range byWarehouse {
+		groups = append(groups, WarehouseItems{Warehouse: warehouse, Items: items})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Warehouse < groups[j].Warehouse
+	})
+	return groups
+}
+
+func (s *ShippingService) CreateShipment(orderID, userID string, items []model.OrderItem) (*model.Shipment, error) {
+	return s.CreateWarehouseShipment(orderID, userID, DefaultWarehouse, items)
+}
+
+// CreateWarehouseShipment creates a shipment of items sent from warehouse.
+func (s *ShippingService) CreateWarehouseShipment(orderID, userID, warehouse string, items []model.OrderItem) (*model.Shipment, error) {
+	quote, err := s.SelectCarrier(items)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	shipment := &model.Shipment{
+		ID:             uuid.New().String(),
+		TenantID:       s.tenantID,
+		OrderID:        orderID,
+		UserID:         userID,
+		Warehouse:      warehouse,
+		Carrier:        quote.Carrier,
+		Cost:           quote.Cost,
+		EstimatedDays:  quote.EstimatedDays,
+		TrackingNumber: strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:16]),
+		Status:         model.ShipmentStatusCreated,
+		Tracking: []model.TrackingEvent{
+			{Status: model.ShipmentStatusCreated, At: now},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	shard := s.shard(shipment.ID)
+	shard.mu.Lock()
+	shard.shipments[shipment.ID] = shipment
+	shard.mu.Unlock()
+
+	index := s.byOrder[shardIndex(orderID)]
+	index.mu.Lock()
+	index.shipments[orderID] = append(index.shipments[orderID], shipment.ID)
+	index.mu.Unlock()
+
+	return shipment.Clone(), nil
+}
+
+func (s *ShippingService) UpdateTracking(shipmentID string, status model.ShipmentStatus, location string) (*model.Shipment, error) {
+	shard := s.shard(shipmentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shipment, exists := shard.shipments[shipmentID]
+	if !exists {
+		return nil, fmt.Errorf("shipment not found: %s", shipmentID)
+	}
+
+	if !shipment.Status.CanTransitionTo(status) {
+		return nil, fmt.Errorf("invalid transition for shipment %s: %s -> %s", shipmentID, shipment.Status, status)
+	}
+
+	now := time.Now()
+	shipment.Status = status
+	shipment.Tracking = append(shipment.Tracking, model.TrackingEvent{Status: status, Location: location, At: now})
+	shipment.UpdatedAt = now
+
+	return shipment.Clone(), nil
+}
+
+func (s *ShippingService) CancelShipment(shipmentID string) error {
+	_, err := s.UpdateTracking(shipmentID, model.ShipmentStatusCancelled, "")
+	return err
+}
+
+// CancelShipmentsByOrderID cancels every shipment of the order that has not
+// been picked up yet.
+func (s *ShippingService) CancelShipmentsByOrderID(orderID string) error {
+	for _, shipment := range s.GetShipmentsByOrderID(orderID) {
+		if shipment.Status == model.ShipmentStatusCancelled {
+			continue
+		}
+		if err := s.CancelShipment(shipment.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShippingService) GetShipment(shipmentID string) (*model.Shipment, error) {
+	shard := s.shard(shipmentID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	shipment, exists := shard.shipments[shipmentID]
+	if !exists {
+		return nil, fmt.Errorf("shipment not found: %s", shipmentID)
+	}
+
+	return shipment.Clone(), nil
+}
+
+func (s *ShippingService) GetShipmentsByOrderID(orderID string) []*model.Shipment {
+	index := s.byOrder[shardIndex(orderID)]
+	index.mu.RLock()
+	shipmentIDs := append([]string(nil), index.shipments[orderID]...)
+	index.mu.RUnlock()
+
+	shipments := make([]*model.Shipment, 0, len(shipmentIDs))
+	for _, shipmentID := range shipmentIDs {
+		if shipment, err := s.GetShipment(shipmentID); err == nil {
+			shipments = append(shipments, shipment)
+		}
+	}
+
+	return shipments
+}
+
+func (s *ShippingService) shard(shipmentID string) *shipmentShard {
+	return s.shards[shardIndex(shipmentID)]
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+
+	"homework/internal/model"
+)
+
+func TestShippingService_CreateShipment_CheapestCarrier(t *testing.T) {
+	service := NewShippingService(FlatRateTable{
+		"slow":  {Base: 3.0, PerUnit: 1.0, EstimatedDays: 7},
+		"quick": {Base: 10.0, PerUnit: 0.5, EstimatedDays: 1},
+	})
+	items := []model.OrderItem{
+		{ProductID: "product1", Quantity: 2, Price: 100.0},
+	}
+
+	shipment, err := service.CreateShipment("order1", "user1", items)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if shipment.Carrier != "slow" || shipment.Cost != 5.0 {
+		t.Errorf("Expected slow carrier at 5.00, got %s at %.2f", shipment.Carrier, shipment.Cost)
+	}
+
+	if shipment.TrackingNumber == "" {
+		t.Error("Expected tracking number to be set")
+	}
+
+	bulk := []model.OrderItem{
+		{ProductID: "product1", Quantity: 20, Price: 100.0},
+	}
+	shipment, _ = service.CreateShipment("order2", "user1", bulk)
+	if shipment.Carrier != "quick" {
+		t.Errorf("Expected quick carrier for bulk order, got %s", shipment.Carrier)
+	}
+}
+
+func TestShippingService_UpdateTracking(t *testing.T) {
+	service := NewShippingService(DefaultRateTable())
+	shipment, _ := service.CreateShipment("order1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+
+	if _, err := service.UpdateTracking(shipment.ID, model.ShipmentStatusDelivered, "Moscow"); err == nil {
+		t.Error("Expected delivering a shipment that was never picked up to fail")
+	}
+
+	service.UpdateTracking(shipment.ID, model.ShipmentStatusPickedUp, "Warehouse")
+	updated, err := service.UpdateTracking(shipment.ID, model.ShipmentStatusInTransit, "Tver")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(updated.Tracking) != 3 || updated.Tracking[2].Location != "Tver" {
+		t.Errorf("Unexpected tracking history: %+v", updated.Tracking)
+	}
+
+	if err := service.CancelShipment(shipment.ID); err == nil {
+		t.Error("Expected cancelling a picked up shipment to fail")
+	}
+}
+
+func TestShippingService_NoCarrier(t *testing.T) {
+	service := NewShippingService(FlatRateTable{})
+
+	_, err := service.CreateShipment("order1", "user1", []model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 100.0},
+	})
+	if err == nil {
+		t.Error("Expected error when no carrier is available")
+	}
+}
+
+func TestShippingService_SplitByWarehouse(t *testing.T) {
+	service := NewShippingService(DefaultRateTable())
+	service.SetProductWarehouse("product1", "west")
+	service.SetProductWarehouse("product2", "east")
+
+	groups := service.SplitByWarehouse([]model.OrderItem{
+		{ProductID: "product1", Quantity: 1, Price: 10.0},
+		{ProductID: "product2", Quantity: 2, Price: 10.0},
+		{ProductID: "product3", Quantity: 1, Price: 10.0},
+		{ProductID: "product4", Quantity: 1, Price: 10.0},
+	})
+
+	expected := []struct {
+		warehouse string
+		items     int
+	}{{"east", 1}, {DefaultWarehouse, 2}, {"west", 1}}
+	if len(groups) != len(expected) {
+		t.Fatalf("Expected %d warehouses, got %d", len(expected), len(groups))
+	}
+	for i, group := range groups {
+		if group.Warehouse != expected[i].warehouse || len(group.Items) != expected[i].items {
+			t.Errorf("Expected %d items from %s, got %d from %s", expected[i].items, expected[i].warehouse, len(group.Items), group.Warehouse)
+		}
+	}
+
+	shipment, err := service.CreateWarehouseShipment("order1", "user1", groups[0].Warehouse, groups[0].Items)
+	if err != nil {
+		t.Fatalf("Failed to create shipment: %v", err)
+	}
+	if shipment.Warehouse != "east" {
+		t.Errorf("Expected shipment from east, got %s", shipment.Warehouse)
+	}
+}
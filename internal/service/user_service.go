@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"homework/internal/model"
+)
+
+// UserService keeps accounts in shards keyed by user ID. Emails are unique
+// across users; the email index is locked after a user shard, never before.
+type UserService struct {
+	tenantID string
+
+	shards [shardCount]*userShard
+
+	emailMu sync.Mutex
+	emails  map[string]string
+}
+
+type userShard struct {
+	mu    sync.RWMutex
+	users map[string]*model.User
+}
+
+func NewUserService() *UserService {
+	return NewUserServiceForTenant("")
+}
+
+// NewUserServiceForTenant returns a service holding the users of one tenant.
+// Emails only need to be unique within the tenant.
+func NewUserServiceForTenant(tenantID string) *UserService {
+	service := &UserService{tenantID: tenantID, emails: make(map[string]string)}
+
+	for i := 0; i < shardCount; i++ {
+		service.shards[i] = &userShard{users: make(map[string]*model.User)}
+	}
+
+	return service
+}
+
+func (s *UserService) RegisterUser(userID string, profile model.UserProfile) (*model.User, error) {
+	if strings.TrimSpace(userID) == "" {
+		return nil, fmt.Errorf("%w: user ID is required", ErrInvalidUser)
+	}
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, exists := shard.users[userID]; exists {
+		return nil, fmt.Errorf("user already exists: %s", userID)
+	}
+
+	if err := s.claimEmail(userID, profile.Email, ""); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &model.User{
+		ID:        userID,
+		TenantID:  s.tenantID,
+		Profile:   profile,
+		Status:    model.UserStatusActive,
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	user.Profile.Addresses = append([]model.Address(nil), profile.Addresses...)
+	shard.users[userID] = user
+
+	return user.Clone(), nil
+}
+
+func (s *UserService) GetUser(userID string) (*model.User, error) {
+	shard := s.shard(userID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	user, exists := shard.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	return user.Clone(), nil
+}
+
+// UserSegments returns the segment of the user's profile, if any, so that
+// discount evaluation can use it.
+func (s *UserService) UserSegments(userID string) []string {
+	shard := s.shard(userID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	user, exists := shard.users[userID]
+	if !exists || user.Profile.Segment == "" {
+		return nil
+	}
+	return []string{user.Profile.Segment}
+}
+
+// UpdateProfile replaces the user's profile, including the shipping
+// addresses.
+func (s *UserService) UpdateProfile(userID string, profile model.UserProfile) (*model.User, error) {
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	user, exists := shard.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	if err := s.claimEmail(userID, profile.Email, user.Profile.Email); err != nil {
+		return nil, err
+	}
+
+	user.Profile = profile
+	user.Profile.Addresses = append([]model.Address(nil), profile.Addresses...)
+	user.Version++
+	user.UpdatedAt = time.Now()
+
+	return user.Clone(), nil
+}
+
+func (s *UserService) SuspendUser(userID string) error {
+	return s.setStatus(userID, model.UserStatusSuspended)
+}
+
+func (s *UserService) ActivateUser(userID string) error {
+	return s.setStatus(userID, model.UserStatusActive)
+}
+
+// ValidateCustomer checks that userID may place orders: the user must be
+// registered and active.
+func (s *UserService) ValidateCustomer(userID string) (*model.User, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status != model.UserStatusActive {
+		return nil, fmt.Errorf("%w: %s", ErrUserSuspended, userID)
+	}
+
+	return user, nil
+}
+
+func (s *UserService) setStatus(userID string, status model.UserStatus) error {
+	shard := s.shard(userID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	user, exists := shard.users[userID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	if user.Status == status {
+		return nil
+	}
+
+	user.Status = status
+	user.Version++
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *UserService) shard(userID string) *userShard {
+	return s.shards[shardIndex(userID)]
+}
+
+// claimEmail moves the user's email in the index from previous to email. The
+// caller must hold the user's shard lock.
+func (s *UserService) claimEmail(userID, email, previous string) error {
+	key := strings.ToLower(email)
+	previousKey := strings.ToLower(previous)
+	if key == previousKey {
+		return nil
+	}
+
+	s.emailMu.Lock()
+	defer s.emailMu.Unlock()
+
+	if owner, exists := s.emails[key]; exists && owner != userID {
+		return fmt.Errorf("%w: email %s is already registered", ErrInvalidUser, email)
+	}
+
+	s.emails[key] = userID
+	if previousKey != "" {
+		delete(s.emails, previousKey)
+	}
+	return nil
+}
+
+func validateProfile(profile model.UserProfile) error {
+	if strings.TrimSpace(profile.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidUser)
+	}
+
+	at := strings.Index(profile.Email, "@")
+	if at <= 0 || at == len(profile.Email)-1 {
+		return fmt.Errorf("%w: invalid email %q", ErrInvalidUser, profile.Email)
+	}
+
+	for i, address := range profile.Addresses {
+		if address.Line1 == "" || address.City == "" || address.Country == "" {
+			return fmt.Errorf("%w: address %d needs a street, city and country", ErrInvalidUser, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"homework/internal/model"
+)
+
+func TestUserService_RegisterAndUpdateProfile(t *testing.T) {
+	service := NewUserService()
+	profile := model.UserProfile{
+		Name:      "Dasha",
+		Email:     "dasha@example.com",
+		Segment:   "vip",
+		Addresses: []model.Address{{Line1: "Main st. 1", City: "Moscow", Country: "RU"}},
+	}
+
+	user, err := service.RegisterUser("user1", profile)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if user.Status != model.UserStatusActive || user.Profile.Segment != "vip" {
+		t.Errorf("Unexpected user: %+v", user)
+	}
+
+	if _, err := service.RegisterUser("user1", profile); err == nil {
+		t.Error("Expected error for duplicate user ID")
+	}
+
+	other := model.UserProfile{Name: "Nastya", Email: "DASHA@example.com"}
+	if _, err := service.RegisterUser("user2", other); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected ErrInvalidUser for a taken email, got: %v", err)
+	}
+
+	profile.Email = "dasha@example.org"
+	profile.Addresses = append(profile.Addresses, model.Address{Line1: "Second st. 2", City: "Kazan", Country: "RU"})
+	updated, err := service.UpdateProfile("user1", profile)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(updated.Profile.Addresses) != 2 || updated.Version != 2 {
+		t.Errorf("Expected two addresses at version 2, got %+v", updated)
+	}
+
+	if _, err := service.RegisterUser("user2", other); err != nil {
+		t.Errorf("Expected the released email to be available, got: %v", err)
+	}
+}
+
+func TestUserService_Validation(t *testing.T) {
+	service := NewUserService()
+
+	tests := []struct {
+		name    string
+		userID  string
+		profile model.UserProfile
+	}{
+		{"empty ID", "", model.UserProfile{Name: "Dasha", Email: "dasha@example.com"}},
+		{"empty name", "user1", model.UserProfile{Email: "dasha@example.com"}},
+		{"bad email", "user1", model.UserProfile{Name: "Dasha", Email: "dasha"}},
+		{"incomplete address", "user1", model.UserProfile{Name: "Dasha", Email: "dasha@example.com", Addresses: []model.Address{{City: "Moscow"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.RegisterUser(tt.userID, tt.profile); !errors.Is(err, ErrInvalidUser) {
+				t.Errorf("Expected ErrInvalidUser, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestUserService_ValidateCustomer(t *testing.T) {
+	service := NewUserService()
+	service.RegisterUser("user1", model.UserProfile{Name: "Dasha", Email: "dasha@example.com"})
+
+	if _, err := service.ValidateCustomer("user1"); err != nil {
+		t.Errorf("Expected active user to be valid, got: %v", err)
+	}
+
+	if _, err := service.ValidateCustomer("unknown"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got: %v", err)
+	}
+
+	service.SuspendUser("user1")
+	if _, err := service.ValidateCustomer("user1"); !errors.Is(err, ErrUserSuspended) {
+		t.Errorf("Expected ErrUserSuspended, got: %v", err)
+	}
+
+	service.ActivateUser("user1")
+	if _, err := service.ValidateCustomer("user1"); err != nil {
+		t.Errorf("Expected reactivated user to be valid, got: %v", err)
+	}
+}
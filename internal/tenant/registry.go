@@ -0,0 +1,87 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"homework/internal/model"
+	"homework/internal/saga"
+	"homework/internal/service"
+)
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// Tenant is one storefront with its own services and orchestrator. Nothing
+// is shared between tenants, so one tenant's orders, balances, stock,
+// discounts and sagas are unreachable from another's.
+type Tenant struct {
+	Config       model.Tenant
+	Orders       *service.OrderService
+	Billing      *service.BillingService
+	Inventory    *service.InventoryService
+	Discounts    *service.DiscountService
+	Orchestrator *saga.SagaOrchestrator
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tenants: make(map[string]*Tenant)}
+}
+
+// Register creates the services of a new tenant and applies its currency
+// and discount policy. Extra orchestrator options, e.g. a user service, are passed on
+// after the tenant's own; services supplied that way must be created for the
+// same tenant.
+func (r *Registry) Register(config model.Tenant, options ...saga.OrchestratorOption) (*Tenant, error) {
+	if config.ID == "" {
+		return nil, fmt.Errorf("tenant ID is required")
+	}
+	if len(config.Currency) != 3 {
+		return nil, fmt.Errorf("invalid currency for tenant %s: %q", config.ID, config.Currency)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tenants[config.ID]; exists {
+		return nil, fmt.Errorf("tenant already exists: %s", config.ID)
+	}
+
+	tenant := &Tenant{
+		Config:    config,
+		Orders:    service.NewOrderServiceForTenant(config.ID),
+		Billing:   service.NewBillingServiceForTenant(config.ID),
+		Inventory: service.NewInventoryServiceForTenant(config.ID),
+		Discounts: service.NewDiscountServiceForTenant(config.ID),
+	}
+	tenant.Orders.SetCurrency(config.Currency)
+	tenant.Billing.SetCurrency(config.Currency)
+	tenant.Discounts.SetDiscountCap(config.DiscountPolicy.Cap)
+	tenant.Discounts.SetPromoCodesDisabled(config.DiscountPolicy.DisablePromoCodes)
+	tenant.Orchestrator = saga.NewSagaOrchestrator(
+		tenant.Orders,
+		tenant.Billing,
+		tenant.Inventory,
+		tenant.Discounts,
+		append([]saga.OrchestratorOption{saga.WithTenant(config.ID)}, options...)...,
+	)
+
+	r.tenants[config.ID] = tenant
+	return tenant, nil
+}
+
+func (r *Registry) Get(tenantID string) (*Tenant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tenant, exists := r.tenants[tenantID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, tenantID)
+	}
+	return tenant, nil
+}
@@ -0,0 +1,104 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+
+	"homework/internal/model"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	registry := NewRegistry()
+
+	shop, err := registry.Register(model.Tenant{
+		ID:             "shop1",
+		Currency:       "USD",
+		DiscountPolicy: model.DiscountPolicy{Cap: model.DiscountCap{Amount: 5.0}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := registry.Register(model.Tenant{ID: "shop1", Currency: "USD"}); err == nil {
+		t.Error("Expected error for a duplicate tenant")
+	}
+	if _, err := registry.Register(model.Tenant{ID: "shop2", Currency: "dollars"}); err == nil {
+		t.Error("Expected error for an invalid currency")
+	}
+	if _, err := registry.Get("shop2"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Expected ErrTenantNotFound, got: %v", err)
+	}
+
+	shop.Billing.SetUserBalance("user1", 1000.0)
+	shop.Inventory.SetStock("product1", 10)
+	shop.Discounts.SetUserDiscount("user1", 50.0)
+
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 100.0}}
+	result := shop.Orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	discount, _ := shop.Discounts.GetDiscountByOrderID(result.Execution.OrderID)
+	if discount.Amount != 5.0 {
+		t.Errorf("Expected the tenant's cap to limit the discount to 5.00, got %.2f", discount.Amount)
+	}
+
+	payment, _ := shop.Billing.GetPaymentByOrderID(result.Execution.OrderID)
+	order, _ := shop.Orders.GetOrder(result.Execution.OrderID)
+	for name, tenantID := range map[string]string{
+		"order":    order.TenantID,
+		"payment":  payment.TenantID,
+		"discount": discount.TenantID,
+		"saga":     result.Execution.TenantID,
+	} {
+		if tenantID != "shop1" {
+			t.Errorf("Expected %s of shop1, got %q", name, tenantID)
+		}
+	}
+
+	if order.Currency != "USD" || payment.Currency != "USD" {
+		t.Errorf("Expected the order and payment in USD, got %q and %q", order.Currency, payment.Currency)
+	}
+}
+
+func TestRegistry_TenantsShareNothing(t *testing.T) {
+	registry := NewRegistry()
+	shop1, _ := registry.Register(model.Tenant{ID: "shop1", Currency: "USD"})
+	shop2, _ := registry.Register(model.Tenant{ID: "shop2", Currency: "EUR"})
+
+	shop1.Billing.SetUserBalance("user1", 1000.0)
+	shop1.Inventory.SetStock("product1", 10)
+
+	if balance := shop2.Billing.GetUserBalance("user1"); balance != 0 {
+		t.Errorf("Expected shop2 not to see shop1's balance, got %.2f", balance)
+	}
+	if stock := shop2.Inventory.GetStock("product1"); stock != 0 {
+		t.Errorf("Expected shop2 not to see shop1's stock, got %d", stock)
+	}
+
+	items := []model.OrderItem{{ProductID: "product1", Quantity: 1, Price: 100.0}}
+	result := shop1.Orchestrator.ExecuteOrderSaga("saga-1", "order-1", "user1", items)
+	if !result.Success {
+		t.Fatalf("Expected success, got error: %v", result.Error)
+	}
+
+	if _, err := shop2.Orchestrator.GetOrder(result.Execution.OrderID); err == nil {
+		t.Error("Expected shop2 not to find shop1's order")
+	}
+	if _, err := shop2.Orchestrator.GetSagaExecution("saga-1"); err == nil {
+		t.Error("Expected shop2 not to find shop1's saga")
+	}
+
+	discount, _ := shop1.Discounts.GetDiscountByOrderID(result.Execution.OrderID)
+	if discount != nil {
+		if err := shop2.Discounts.RestoreDiscount(discount); err == nil {
+			t.Error("Expected shop2 to refuse a discount of shop1")
+		}
+	}
+
+	order, _ := shop1.Orders.GetOrder(result.Execution.OrderID)
+	if _, err := shop2.Orchestrator.RequestReturn(order.ID, []model.ReturnItem{{ProductID: "product1", Quantity: 1}}, "wrong tenant"); err == nil {
+		t.Error("Expected shop2 to refuse a return of shop1's order")
+	}
+}